@@ -1,12 +1,17 @@
 package toxiproxy
 
 import (
+	"context"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -34,6 +39,10 @@ type ApiServer struct {
 	Collection *ProxyCollection
 	Metrics    *metricsContainer
 	Logger     *zerolog.Logger
+
+	lock     sync.Mutex
+	http     *http.Server
+	shutdown bool
 }
 
 func NewServer(m *metricsContainer, logger zerolog.Logger) *ApiServer {
@@ -61,6 +70,22 @@ func (server *ApiServer) PopulateConfig(filename string) {
 }
 
 func (server *ApiServer) Listen(host string, port string) {
+	server.lock.Lock()
+	shutdown := server.shutdown
+	server.lock.Unlock()
+	if shutdown {
+		return
+	}
+
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		server.Logger.Fatal().Err(err).Msg("ListenAndServe finished with error")
+	}
+	server.Serve(ln)
+}
+
+// Serve runs the HTTP server on the listener, until the server is shut down.
+func (server *ApiServer) Serve(ln net.Listener) {
 	r := mux.NewRouter()
 	r.Use(hlog.NewHandler(*server.Logger))
 	r.Use(hlog.RequestIDHandler("request_id", "X-Toxiproxy-Request-Id"))
@@ -94,6 +119,8 @@ func (server *ApiServer) Listen(host string, port string) {
 		Name("ProxyUpdate")
 	r.HandleFunc("/proxies/{proxy}", server.ProxyDelete).Methods("DELETE").
 		Name("ProxyDelete")
+	r.HandleFunc("/proxies/{proxy}/drain", server.ProxyDrain).Methods("POST").
+		Name("ProxyDrain")
 	r.HandleFunc("/proxies/{proxy}/toxics", server.ToxicIndex).Methods("GET").
 		Name("ToxicIndex")
 	r.HandleFunc("/proxies/{proxy}/toxics", server.ToxicCreate).Methods("POST").
@@ -105,12 +132,35 @@ func (server *ApiServer) Listen(host string, port string) {
 	r.HandleFunc("/proxies/{proxy}/toxics/{toxic}", server.ToxicDelete).Methods("DELETE").
 		Name("ToxicDelete")
 
+	r.HandleFunc("/proxies/{proxy}/connections", server.ConnectionIndex).Methods("GET").
+		Name("ConnectionIndex")
+	r.HandleFunc("/proxies/{proxy}/connections/{connection}", server.ConnectionDelete).
+		Methods("DELETE").
+		Name("ConnectionDelete")
+
+	r.HandleFunc("/proxies/{proxy}/upstreams", server.UpstreamIndex).Methods("GET").
+		Name("UpstreamIndex")
+	r.HandleFunc("/proxies/{proxy}/upstreams/{upstream}", server.UpstreamUpdate).
+		Methods("POST").
+		Name("UpstreamUpdate")
+
+	r.HandleFunc("/bulk/enable", server.BulkEnable).Methods("POST").Name("BulkEnable")
+	r.HandleFunc("/bulk/disable", server.BulkDisable).Methods("POST").Name("BulkDisable")
+	r.HandleFunc("/bulk/toxics", server.BulkToxicCreate).Methods("POST").
+		Name("BulkToxicCreate")
+	r.HandleFunc("/bulk/toxics/{toxic}", server.BulkToxicUpdate).Methods("POST").
+		Name("BulkToxicUpdate")
+	r.HandleFunc("/bulk/toxics/{toxic}", server.BulkToxicDelete).Methods("DELETE").
+		Name("BulkToxicDelete")
+
+	r.HandleFunc("/tls/ca", server.CACertificate).Methods("GET").Name("CACertificate")
 	r.HandleFunc("/version", server.Version).Methods("GET").Name("Version")
 
 	if server.Metrics.anyMetricsEnabled() {
 		r.Handle("/metrics", server.Metrics.handler()).Name("Metrics")
 	}
 
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
 	server.Logger.
 		Info().
 		Str("host", host).
@@ -120,23 +170,88 @@ func (server *ApiServer) Listen(host string, port string) {
 
 	srv := &http.Server{
 		Handler:      r,
-		Addr:         net.JoinHostPort(host, port),
+		Addr:         ln.Addr().String(),
 		WriteTimeout: 30 * time.Second,
 		ReadTimeout:  10 * time.Second,
 	}
 
-	err := srv.ListenAndServe()
-	if err != nil {
+	server.lock.Lock()
+	if server.shutdown {
+		server.lock.Unlock()
+		ln.Close()
+		return
+	}
+	server.http = srv
+	server.lock.Unlock()
+
+	err := srv.Serve(ln)
+	if err != nil && err != http.ErrServerClosed {
 		server.Logger.Fatal().Err(err).Msg("ListenAndServe finished with error")
 	}
 }
 
+// Shutdown stops the HTTP server once the requests in progress are done, drains
+// the proxies for up to drainTimeout, then stops and removes them. Listen and
+// Serve return as soon as the shutdown starts. Once the context is done the
+// proxies are stopped in the background, and the context's error is returned
+// without waiting for them.
+func (server *ApiServer) Shutdown(ctx context.Context, drainTimeout time.Duration) error {
+	server.lock.Lock()
+	server.shutdown = true
+	srv := server.http
+	server.lock.Unlock()
+
+	server.Logger.Info().Msg("Shutting down HTTP server")
+	var err error
+	if srv != nil {
+		err = srv.Shutdown(ctx)
+	}
+
+	if drainTimeout > 0 {
+		drained := make(chan struct{})
+		go func() {
+			server.Collection.DrainAll(drainTimeout)
+			close(drained)
+		}()
+
+		select {
+		case <-drained:
+		case <-ctx.Done():
+		}
+	}
+
+	// Stopping the proxies is bound by the context too, so that a stuck proxy
+	// does not hold up the exit.
+	server.Logger.Info().Msg("Stopping proxies")
+	cleared := make(chan error, 1)
+	go func() {
+		cleared <- server.Collection.Clear()
+	}()
+
+	select {
+	case clearErr := <-cleared:
+		if err == nil {
+			err = clearErr
+		}
+	case <-ctx.Done():
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
 func (server *ApiServer) ProxyIndex(response http.ResponseWriter, request *http.Request) {
-	proxies := server.Collection.Proxies()
+	selector, err := ParseSelector(request.URL.Query().Get("selector"))
+	if server.apiError(response, err) {
+		return
+	}
+
+	proxies := server.Collection.Select(selector)
 	marshalData := make(map[string]interface{}, len(proxies))
 
-	for name, proxy := range proxies {
-		marshalData[name] = proxyWithToxics(proxy)
+	for _, proxy := range proxies {
+		marshalData[proxy.Name()] = proxyWithToxics(proxy)
 	}
 
 	data, err := json.Marshal(marshalData)
@@ -185,12 +300,15 @@ func (server *ApiServer) ProxyCreate(response http.ResponseWriter, request *http
 		server.apiError(response, joinError(fmt.Errorf("name"), ErrMissingField))
 		return
 	}
-	if len(input.Upstream) < 1 {
+	if len(input.Upstream) < 1 && len(input.Upstreams) < 1 && input.Mode == "" {
 		server.apiError(response, joinError(fmt.Errorf("upstream"), ErrMissingField))
 		return
 	}
 
-	proxy := NewProxyTCP(server, input.Name, input.Listen, input.Upstream)
+	proxy, err := NewProxy(server, input)
+	if server.apiError(response, err) {
+		return
+	}
 
 	err = server.Collection.Add(proxy, input.Enabled)
 	if server.apiError(response, err) {
@@ -272,11 +390,26 @@ func (server *ApiServer) ProxyUpdate(response http.ResponseWriter, request *http
 	}
 
 	// Default fields are the same as existing proxy
-	input := ProxyConfig{Listen: proxy.Listen(), Upstream: proxy.Upstream(), Enabled: proxy.Enabled()}
+	input := proxy.Config()
+	// Labels sent replace the existing ones, instead of being merged with them.
+	labels := input.Labels
+	input.Labels = nil
 	err = json.NewDecoder(request.Body).Decode(&input)
 	if server.apiError(response, joinError(err, ErrBadRequestBody)) {
 		return
 	}
+	if input.Labels == nil {
+		input.Labels = labels
+	}
+
+	protocol, err := parseProtocol(input.Protocol)
+	if server.apiError(response, err) {
+		return
+	}
+	if protocol != proxy.Protocol() {
+		server.apiError(response, ErrProtocolChanged)
+		return
+	}
 
 	err = proxy.Update(input)
 	if server.apiError(response, err) {
@@ -312,6 +445,45 @@ func (server *ApiServer) ProxyDelete(response http.ResponseWriter, request *http
 	}
 }
 
+func (server *ApiServer) ProxyDrain(response http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	proxy, err := server.Collection.Get(vars["proxy"])
+	if server.apiError(response, err) {
+		return
+	}
+
+	input := struct {
+		Timeout int64 `json:"timeout"`
+	}{
+		Timeout: DefaultDrainTimeout.Milliseconds(),
+	}
+	err = json.NewDecoder(request.Body).Decode(&input)
+	if err != nil && err != io.EOF {
+		server.apiError(response, joinError(err, ErrBadRequestBody))
+		return
+	}
+	if input.Timeout < 0 {
+		server.apiError(response, ErrInvalidDrainTimeout)
+		return
+	}
+
+	proxy.Drain(time.Duration(input.Timeout) * time.Millisecond)
+
+	data, err := json.Marshal(proxyWithToxics(proxy))
+	if server.apiError(response, err) {
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusAccepted)
+	_, err = response.Write(data)
+	if err != nil {
+		log := zerolog.Ctx(request.Context())
+		log.Warn().Err(err).Msg("ProxyDrain: Failed to write response to client")
+	}
+}
+
 func (server *ApiServer) ToxicIndex(response http.ResponseWriter, request *http.Request) {
 	vars := mux.Vars(request)
 
@@ -435,6 +607,240 @@ func (server *ApiServer) ToxicDelete(response http.ResponseWriter, request *http
 	}
 }
 
+func (server *ApiServer) ConnectionIndex(response http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	proxy, err := server.Collection.Get(vars["proxy"])
+	if server.apiError(response, err) {
+		return
+	}
+
+	data, err := json.Marshal(proxy.Toxics().GetConnections())
+	if server.apiError(response, err) {
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(data)
+	if err != nil {
+		log := zerolog.Ctx(request.Context())
+		log.Warn().Err(err).Msg("ConnectionIndex: Failed to write response to client")
+	}
+}
+
+func (server *ApiServer) ConnectionDelete(response http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	log := zerolog.Ctx(request.Context())
+
+	proxy, err := server.Collection.Get(vars["proxy"])
+	if server.apiError(response, err) {
+		return
+	}
+
+	reset := false
+	if value := request.URL.Query().Get("reset"); value != "" {
+		reset, err = strconv.ParseBool(value)
+		if err != nil {
+			server.apiError(response, joinError(err, ErrBadRequestBody))
+			return
+		}
+	}
+
+	err = proxy.CloseConnection(vars["connection"], reset)
+	if server.apiError(response, err) {
+		return
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+	_, err = response.Write(nil)
+	if err != nil {
+		log.Warn().Err(err).Msg("ConnectionDelete: Failed to write headers to client")
+	}
+}
+
+func (server *ApiServer) UpstreamIndex(response http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	proxy, err := server.Collection.Get(vars["proxy"])
+	if server.apiError(response, err) {
+		return
+	}
+
+	data, err := json.Marshal(proxy.Upstreams())
+	if server.apiError(response, err) {
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(data)
+	if err != nil {
+		log := zerolog.Ctx(request.Context())
+		log.Warn().Err(err).Msg("UpstreamIndex: Failed to write response to client")
+	}
+}
+
+func (server *ApiServer) UpstreamUpdate(response http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	proxy, err := server.Collection.Get(vars["proxy"])
+	if server.apiError(response, err) {
+		return
+	}
+
+	input := struct {
+		Down bool `json:"down"`
+	}{}
+	err = json.NewDecoder(request.Body).Decode(&input)
+	if server.apiError(response, joinError(err, ErrBadRequestBody)) {
+		return
+	}
+
+	upstream, err := proxy.SetUpstreamDown(vars["upstream"], input.Down)
+	if server.apiError(response, err) {
+		return
+	}
+
+	data, err := json.Marshal(upstream)
+	if server.apiError(response, err) {
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(data)
+	if err != nil {
+		log := zerolog.Ctx(request.Context())
+		log.Warn().Err(err).Msg("UpstreamUpdate: Failed to write response to client")
+	}
+}
+
+// BulkEnable starts all the proxies matching the selector, or none of them when
+// one fails to start.
+func (server *ApiServer) BulkEnable(response http.ResponseWriter, request *http.Request) {
+	server.bulkSetEnabled(response, request, true)
+}
+
+// BulkDisable stops all the proxies matching the selector.
+func (server *ApiServer) BulkDisable(response http.ResponseWriter, request *http.Request) {
+	server.bulkSetEnabled(response, request, false)
+}
+
+func (server *ApiServer) bulkSetEnabled(
+	response http.ResponseWriter,
+	request *http.Request,
+	enabled bool,
+) {
+	selector, err := bulkSelector(request)
+	if server.apiError(response, err) {
+		return
+	}
+
+	proxies, err := server.Collection.SetEnabled(selector, enabled)
+	if server.apiError(response, err) {
+		return
+	}
+	server.writeBulkResponse(response, request, proxies)
+}
+
+// BulkToxicCreate adds the toxic to all the proxies matching the selector.
+func (server *ApiServer) BulkToxicCreate(response http.ResponseWriter, request *http.Request) {
+	selector, err := bulkSelector(request)
+	if server.apiError(response, err) {
+		return
+	}
+
+	proxies, err := server.Collection.AddToxicJson(selector, request.Body)
+	if server.apiError(response, err) {
+		return
+	}
+	server.writeBulkResponse(response, request, proxies)
+}
+
+// BulkToxicUpdate updates the toxic of all the proxies matching the selector.
+func (server *ApiServer) BulkToxicUpdate(response http.ResponseWriter, request *http.Request) {
+	selector, err := bulkSelector(request)
+	if server.apiError(response, err) {
+		return
+	}
+
+	proxies, err := server.Collection.UpdateToxicJson(
+		selector,
+		mux.Vars(request)["toxic"],
+		request.Body,
+	)
+	if server.apiError(response, err) {
+		return
+	}
+	server.writeBulkResponse(response, request, proxies)
+}
+
+// BulkToxicDelete removes the toxic of all the proxies matching the selector.
+func (server *ApiServer) BulkToxicDelete(response http.ResponseWriter, request *http.Request) {
+	selector, err := bulkSelector(request)
+	if server.apiError(response, err) {
+		return
+	}
+
+	proxies, err := server.Collection.RemoveToxic(
+		request.Context(),
+		selector,
+		mux.Vars(request)["toxic"],
+	)
+	if server.apiError(response, err) {
+		return
+	}
+	server.writeBulkResponse(response, request, proxies)
+}
+
+// bulkSelector returns the selector of a bulk operation, which is required so an
+// operation does not apply to all the proxies by mistake.
+func bulkSelector(request *http.Request) (Selector, error) {
+	value := request.URL.Query().Get("selector")
+	if strings.TrimSpace(value) == "" {
+		return nil, joinError(fmt.Errorf("selector"), ErrMissingField)
+	}
+	return ParseSelector(value)
+}
+
+// writeBulkResponse writes the proxies a bulk operation applied to, by name.
+func (server *ApiServer) writeBulkResponse(
+	response http.ResponseWriter,
+	request *http.Request,
+	proxies []Proxy,
+) {
+	marshalData := make(map[string]interface{}, len(proxies))
+	for _, proxy := range proxies {
+		marshalData[proxy.Name()] = proxyWithToxics(proxy)
+	}
+
+	data, err := json.Marshal(marshalData)
+	if server.apiError(response, err) {
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(data)
+	if err != nil {
+		log := zerolog.Ctx(request.Context())
+		log.Warn().Err(err).Msg("Bulk: Failed to write response to client")
+	}
+}
+
+// CACertificate returns the PEM certificate of the internal CA, which signs the
+// certificates of the proxies terminating TLS without a certificate of their own.
+func (server *ApiServer) CACertificate(response http.ResponseWriter, request *http.Request) {
+	cert, err := internalCA.caCertificate()
+	if server.apiError(response, err) {
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/x-pem-file")
+	err = pem.Encode(response, &pem.Block{Type: "CERTIFICATE", Bytes: cert})
+	if err != nil {
+		log := zerolog.Ctx(request.Context())
+		log.Warn().Err(err).Msg("CACertificate: Failed to write response to client")
+	}
+}
+
 func (server *ApiServer) Version(response http.ResponseWriter, request *http.Request) {
 	response.Header().Set("Content-Type", "text/plain;charset=utf-8")
 	_, err := response.Write([]byte(Version))
@@ -473,9 +879,75 @@ var (
 		"stream was invalid, can be either upstream or downstream",
 		http.StatusBadRequest,
 	)
+	ErrInvalidProtocol = newError(
+		"protocol was invalid, can be either tcp or udp",
+		http.StatusBadRequest,
+	)
+	ErrUnixSocketProtocol = newError(
+		"unix sockets can only be used by tcp proxies",
+		http.StatusBadRequest,
+	)
+	ErrInvalidMode = newError(
+		"mode was invalid, can be either socks5 or http_connect",
+		http.StatusBadRequest,
+	)
+	ErrModeProtocol = newError(
+		"socks5 and http_connect modes can only be used by tcp proxies",
+		http.StatusBadRequest,
+	)
+	ErrModeChanged = newError(
+		"mode of an existing proxy cannot be changed",
+		http.StatusBadRequest,
+	)
+	ErrForwardUpstream = newError(
+		"socks5 and http_connect proxies connect clients to their destination, "+
+			"they have no upstream",
+		http.StatusBadRequest,
+	)
+	ErrProtocolChanged = newError(
+		"protocol of an existing proxy cannot be changed",
+		http.StatusBadRequest,
+	)
 	ErrInvalidToxicType   = newError("invalid toxic type", http.StatusBadRequest)
 	ErrToxicAlreadyExists = newError("toxic already exists", http.StatusConflict)
 	ErrToxicNotFound      = newError("toxic not found", http.StatusNotFound)
+	ErrToxicNotDatagram   = newError(
+		"toxic type does not preserve packet boundaries and can not be used on udp proxies",
+		http.StatusBadRequest,
+	)
+	ErrInvalidBalance = newError(
+		"balance was invalid, can be round_robin, random or failover",
+		http.StatusBadRequest,
+	)
+	ErrInvalidUpstreams = newError(
+		"upstreams must be distinct, and start with the upstream when both are set",
+		http.StatusBadRequest,
+	)
+	ErrInvalidResolver = newError(
+		"resolver ttl must not be negative, and its hosts must map to IP addresses",
+		http.StatusBadRequest,
+	)
+	ErrInvalidPortRange = newError(
+		"port ranges must be from-to, and the upstream ranges the size of the listen range",
+		http.StatusBadRequest,
+	)
+	ErrPortRangeProtocol = newError(
+		"port ranges can only be used by tcp proxies",
+		http.StatusBadRequest,
+	)
+	ErrInvalidLabels = newError(
+		"label keys and values can only have letters, digits and -_./, keys can not be empty",
+		http.StatusBadRequest,
+	)
+	ErrInvalidSelector = newError(
+		"selector must be comma-separated key=value, key!=value, key or !key",
+		http.StatusBadRequest,
+	)
+	ErrInvalidTLS          = newError("tls config was invalid", http.StatusBadRequest)
+	ErrTLSProtocol         = newError("tls can only be used by tcp proxies", http.StatusBadRequest)
+	ErrUpstreamNotFound    = newError("upstream not found", http.StatusNotFound)
+	ErrConnectionNotFound  = newError("connection not found", http.StatusNotFound)
+	ErrInvalidDrainTimeout = newError("drain timeout must not be negative", http.StatusBadRequest)
 )
 
 func (server *ApiServer) apiError(resp http.ResponseWriter, err error) bool {
@@ -501,11 +973,13 @@ func (server *ApiServer) apiError(resp http.ResponseWriter, err error) bool {
 
 type proxyToxics struct {
 	ProxyConfig
-	Toxics []toxics.Toxic `json:"toxics"`
+	Draining bool           `json:"draining,omitempty"`
+	Toxics   []toxics.Toxic `json:"toxics"`
 }
 
 func proxyWithToxics(proxy Proxy) (result proxyToxics) {
 	result.ProxyConfig = proxy.Config()
+	result.Draining = proxy.Draining()
 	result.Toxics = proxy.Toxics().GetToxicArray()
 	return
 }
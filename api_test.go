@@ -1,9 +1,19 @@
 package toxiproxy_test
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -518,6 +528,148 @@ func TestCreateProxyWithSave(t *testing.T) {
 	})
 }
 
+func TestCreateUDPProxy(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxy := client.NewProxy()
+		testProxy.Name = "statsd"
+		testProxy.Listen = "localhost:8125"
+		testProxy.Upstream = "localhost:20001"
+		testProxy.Protocol = "udp"
+		testProxy.Enabled = true
+
+		err := testProxy.Save()
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		proxy, err := client.Proxy("statsd")
+		if err != nil {
+			t.Fatal("Unable to retriecve proxy:", err)
+		}
+
+		if proxy.Protocol != "udp" || proxy.Listen != "127.0.0.1:8125" || !proxy.Enabled {
+			t.Fatalf(
+				"Unexpected proxy metadata: %s, %s, %v",
+				proxy.Protocol,
+				proxy.Listen,
+				proxy.Enabled,
+			)
+		}
+
+		_, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8125})
+		if err == nil {
+			t.Fatal("Expected UDP proxy to be listening on 127.0.0.1:8125")
+		}
+	})
+}
+
+func TestCreateProxyDefaultsToTCP(t *testing.T) {
+	WithServer(t, func(addr string) {
+		proxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		if proxy.Protocol != "tcp" {
+			t.Fatalf("Expected proxy protocol to be tcp, got %s", proxy.Protocol)
+		}
+	})
+}
+
+func TestCreateProxyInvalidProtocol(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxy := client.NewProxy()
+		testProxy.Name = "mysql_master"
+		testProxy.Listen = "localhost:3310"
+		testProxy.Upstream = "localhost:20001"
+		testProxy.Protocol = "sctp"
+
+		err := testProxy.Save()
+		if err == nil {
+			t.Fatal("Expected error creating proxy, got nil")
+		} else if err.Error() !=
+			"Create: HTTP 400: protocol was invalid, can be either tcp or udp" {
+			t.Fatal("Expected different error creating proxy:", err)
+		}
+	})
+}
+
+func TestUpdateProxyProtocol(t *testing.T) {
+	WithServer(t, func(addr string) {
+		proxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		proxy.Protocol = "udp"
+		err = proxy.Save()
+		if err == nil {
+			t.Fatal("Expected error updating proxy protocol, got nil")
+		} else if err.Error() !=
+			"Save: HTTP 400: protocol of an existing proxy cannot be changed" {
+			t.Fatal("Expected different error updating proxy:", err)
+		}
+	})
+}
+
+func TestPopulateUDPProxy(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxies, err := client.Populate([]tclient.Proxy{
+			{
+				Name:     "one",
+				Listen:   "localhost:7070",
+				Upstream: "localhost:7171",
+				Enabled:  true,
+			},
+			{
+				Name:     "two",
+				Listen:   "localhost:7373",
+				Upstream: "localhost:7474",
+				Protocol: "udp",
+				Enabled:  true,
+			},
+		})
+		if err != nil {
+			t.Fatal("Unable to populate:", err)
+		}
+
+		if len(testProxies) != 2 {
+			t.Fatalf("Wrong number of proxies returned: %d != 2", len(testProxies))
+		}
+
+		if testProxies[0].Protocol != "tcp" || testProxies[1].Protocol != "udp" {
+			t.Fatalf(
+				"Wrong proxy protocols returned: %s, %s",
+				testProxies[0].Protocol,
+				testProxies[1].Protocol,
+			)
+		}
+
+		AssertProxyUp(t, testProxies[0].Listen, true)
+	})
+}
+
+func TestPopulateInvalidProtocol(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxies, err := client.Populate([]tclient.Proxy{
+			{
+				Name:     "one",
+				Listen:   "localhost:7070",
+				Upstream: "localhost:7171",
+				Protocol: "sctp",
+				Enabled:  true,
+			},
+		})
+		if err == nil {
+			t.Fatal("Expected Populate to fail.")
+		}
+
+		if len(testProxies) != 0 {
+			t.Fatalf("Wrong number of proxies returned: %d != 0", len(testProxies))
+		}
+	})
+}
+
 func TestCreateDisabledProxy(t *testing.T) {
 	WithServer(t, func(addr string) {
 		disabledProxy := client.NewProxy()
@@ -1073,6 +1225,1190 @@ func TestRemoveToxic(t *testing.T) {
 	})
 }
 
+func TestScheduledToxic(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		schedule := &tclient.Schedule{Start: 100, Duration: 200, Period: 500}
+		toxic, err := testProxy.AddScheduledToxic("", "latency", "downstream", 1, nil, schedule)
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+		if toxic.Schedule == nil || toxic.Schedule.Phase != "pending" {
+			t.Fatal("Expected scheduled toxic to be pending:", toxic.Schedule)
+		}
+
+		assertPhase := func(after time.Duration, phase string) {
+			time.Sleep(after)
+			toxics, err := testProxy.Toxics()
+			if err != nil {
+				t.Fatal("Error returning toxics:", err)
+			}
+			toxic := AssertToxicExists(t, toxics, "latency_downstream", "latency", "downstream", true)
+			if toxic.Schedule == nil || toxic.Schedule.Phase != phase {
+				t.Fatalf("Expected toxic to be %s, got %+v", phase, toxic.Schedule)
+			}
+		}
+		assertPhase(200*time.Millisecond, "active")
+		assertPhase(200*time.Millisecond, "inactive")
+		assertPhase(300*time.Millisecond, "active")
+
+		toxic, err = testProxy.ScheduleToxic("latency_downstream", nil)
+		if err != nil {
+			t.Fatal("Error removing schedule:", err)
+		}
+		if toxic.Schedule != nil {
+			t.Fatal("Expected toxic to be unscheduled:", toxic.Schedule)
+		}
+
+		_, err = testProxy.ScheduleToxic("latency_downstream", &tclient.Schedule{
+			Duration: 200,
+			Period:   100,
+		})
+		if err == nil {
+			t.Fatal("Expected period shorter than duration to be rejected")
+		}
+
+		err = testProxy.RemoveToxic("latency_downstream")
+		if err != nil {
+			t.Fatal("Error removing toxic:", err)
+		}
+	})
+}
+
+func TestScheduledToxicFinishes(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		schedule := &tclient.Schedule{Duration: 50}
+		_, err = testProxy.AddScheduledToxic("", "latency", "downstream", 1, nil, schedule)
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+
+		time.Sleep(150 * time.Millisecond)
+		toxics, err := testProxy.Toxics()
+		if err != nil {
+			t.Fatal("Error returning toxics:", err)
+		}
+		toxic := AssertToxicExists(t, toxics, "latency_downstream", "latency", "downstream", true)
+		if toxic.Schedule == nil || toxic.Schedule.Phase != "finished" {
+			t.Fatal("Expected toxic to be finished:", toxic.Schedule)
+		}
+	})
+}
+
+func TestAddToxicWithMatch(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		toxic, err := testProxy.CreateToxic(&tclient.Toxic{
+			Type:     "latency",
+			Toxicity: 1,
+			Match:    &tclient.Match{Client: "10.0.0.0/8", Ports: "1000-2000", Prefix: "GET "},
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+		expected := tclient.Match{Client: "10.0.0.0/8", Ports: "1000-2000", Prefix: "GET "}
+		if toxic.Match == nil || *toxic.Match != expected {
+			t.Fatalf("Expected match %+v, got %+v", expected, toxic.Match)
+		}
+
+		_, err = testProxy.CreateToxic(&tclient.Toxic{
+			Name:     "invalid",
+			Type:     "latency",
+			Toxicity: 1,
+			Match:    &tclient.Match{Client: "10.0.0.0/40"},
+		})
+		if err == nil {
+			t.Fatal("Expected invalid match to be rejected")
+		}
+	})
+}
+
+// newEchoServer starts a TCP server echoing the data of every connection.
+func newEchoServer(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal("Failed to create TCP server", err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln
+}
+
+// dialEchoProxy opens a connection through the proxy to an echo server, and
+// checks that data goes through.
+func dialEchoProxy(t *testing.T, testProxy *tclient.Proxy) net.Conn {
+	conn, err := net.Dial("tcp", testProxy.Listen)
+	if err != nil {
+		t.Fatal("Unable to dial proxy", err)
+	}
+
+	conn.Write([]byte("hello"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatal("Expected data to be echoed:", err)
+	}
+	return conn
+}
+
+// withEchoConnection opens a connection through a new proxy to an echo server,
+// and checks that data goes through.
+func withEchoConnection(t *testing.T, f func(*tclient.Proxy, net.Conn)) {
+	WithServer(t, func(addr string) {
+		ln := newEchoServer(t)
+		defer ln.Close()
+
+		testProxy, err := client.CreateProxy("echo", "localhost:0", ln.Addr().String())
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		conn := dialEchoProxy(t, testProxy)
+		defer conn.Close()
+
+		f(testProxy, conn)
+	})
+}
+
+func TestListConnections(t *testing.T) {
+	withEchoConnection(t, func(testProxy *tclient.Proxy, conn net.Conn) {
+		_, err := testProxy.AddToxic("", "latency", "upstream", 1, nil)
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+		_, err = testProxy.CreateToxic(&tclient.Toxic{
+			Name:     "unmatched",
+			Type:     "latency",
+			Toxicity: 1,
+			Match:    &tclient.Match{Client: "10.0.0.0/8"},
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+		_, err = testProxy.AddToxic("disabled", "latency", "upstream", 0, nil)
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+
+		// The toxicity is rolled once the toxics start on the links.
+		var connections []tclient.Connection
+		for i := 0; i < 10; i++ {
+			connections, err = testProxy.Connections()
+			if err != nil {
+				t.Fatal("Error listing connections:", err)
+			}
+			if len(connections) == 1 && len(connections[0].Toxics) > 0 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if len(connections) != 1 {
+			t.Fatalf("Expected 1 connection, got %+v", connections)
+		}
+
+		connection := connections[0]
+		if connection.Client != conn.LocalAddr().String() {
+			t.Fatalf("Expected client %s, got %s", conn.LocalAddr(), connection.Client)
+		}
+		if time.Since(connection.Started) > time.Second {
+			t.Fatal("Expected connection to start recently, got", connection.Started)
+		}
+		if connection.UpstreamBytes != 5 || connection.DownstreamBytes != 5 {
+			t.Fatalf("Expected 5 bytes in each direction, got %d upstream and %d downstream",
+				connection.UpstreamBytes, connection.DownstreamBytes)
+		}
+		if len(connection.Toxics) != 1 || connection.Toxics[0] != "latency_upstream" {
+			t.Fatal("Expected the latency toxic to apply to the connection, got", connection.Toxics)
+		}
+	})
+}
+
+func TestCloseConnection(t *testing.T) {
+	for _, reset := range []bool{false, true} {
+		withEchoConnection(t, func(testProxy *tclient.Proxy, conn net.Conn) {
+			err := testProxy.CloseConnection(conn.LocalAddr().String(), reset)
+			if err != nil {
+				t.Fatal("Error closing connection:", err)
+			}
+
+			_, err = conn.Read(make([]byte, 1))
+			if reset && (err == nil || err == io.EOF) {
+				t.Fatal("Expected connection to be reset, got", err)
+			} else if !reset && err != io.EOF {
+				t.Fatal("Expected connection to be closed, got", err)
+			}
+
+			// The connection is forgotten once its links are done.
+			for i := 0; i < 10; i++ {
+				connections, err := testProxy.Connections()
+				if err != nil {
+					t.Fatal("Error listing connections:", err)
+				}
+				if len(connections) == 0 {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			err = testProxy.CloseConnection(conn.LocalAddr().String(), reset)
+			if err == nil {
+				t.Fatal("Expected closing a closed connection to fail")
+			}
+		})
+	}
+}
+
+func TestDrainProxy(t *testing.T) {
+	withEchoConnection(t, func(testProxy *tclient.Proxy, conn net.Conn) {
+		err := testProxy.Drain(time.Minute)
+		if err != nil {
+			t.Fatal("Error draining proxy:", err)
+		}
+		if testProxy.Enabled || !testProxy.Draining {
+			t.Fatalf("Expected proxy to be disabled and draining, got enabled=%v draining=%v",
+				testProxy.Enabled, testProxy.Draining)
+		}
+		AssertProxyUp(t, testProxy.Listen, false)
+
+		// The open connection still goes through.
+		conn.Write([]byte("world"))
+		if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+			t.Fatal("Expected data to be echoed while draining:", err)
+		}
+
+		err = testProxy.Drain(-time.Second)
+		if err == nil {
+			t.Fatal("Expected negative drain timeout to be rejected")
+		}
+	})
+}
+
+// newGreetingServer starts a TCP server writing the greeting to every client.
+func newGreetingServer(t *testing.T, greeting string) net.Listener {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal("Failed to create TCP server", err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(greeting))
+			conn.Close()
+		}
+	}()
+	return ln
+}
+
+func AssertGreeting(t *testing.T, addr, expected string) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("Unable to dial proxy", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	greeting, err := ioutil.ReadAll(conn)
+	if err != nil || string(greeting) != expected {
+		t.Fatalf("Expected greeting from %s, got %q: %v", expected, greeting, err)
+	}
+}
+
+func TestProxyUpstreams(t *testing.T) {
+	WithServer(t, func(addr string) {
+		primary := newGreetingServer(t, "primary")
+		defer primary.Close()
+		backup := newGreetingServer(t, "backup")
+		defer backup.Close()
+
+		// Nothing listens on a closed listener's address.
+		closed := newGreetingServer(t, "closed")
+		closed.Close()
+
+		testProxy := client.NewProxy()
+		testProxy.Name = "replicas"
+		testProxy.Listen = "localhost:0"
+		testProxy.Upstreams = []string{
+			closed.Addr().String(),
+			primary.Addr().String(),
+			backup.Addr().String(),
+		}
+		testProxy.Balance = "failover"
+		testProxy.Enabled = true
+		err := testProxy.Save()
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+		if testProxy.Upstream != closed.Addr().String() {
+			t.Fatal("Expected upstream to be the first upstream, got", testProxy.Upstream)
+		}
+
+		// Clients fall back on the next upstream when dialing one fails.
+		AssertGreeting(t, testProxy.Listen, "primary")
+
+		err = testProxy.SetUpstreamDown(primary.Addr().String(), true)
+		if err != nil {
+			t.Fatal("Error marking upstream down:", err)
+		}
+		AssertGreeting(t, testProxy.Listen, "backup")
+
+		upstreams, err := testProxy.UpstreamStates()
+		if err != nil {
+			t.Fatal("Error listing upstreams:", err)
+		}
+		if len(upstreams) != 3 || upstreams[0].Down || !upstreams[1].Down || upstreams[2].Down {
+			t.Fatalf("Expected only the primary upstream to be down, got %+v", upstreams)
+		}
+
+		err = testProxy.SetUpstreamDown(primary.Addr().String(), false)
+		if err != nil {
+			t.Fatal("Error marking upstream up:", err)
+		}
+		AssertGreeting(t, testProxy.Listen, "primary")
+
+		err = testProxy.SetUpstreamDown("localhost:1", true)
+		if err == nil {
+			t.Fatal("Expected marking an unknown upstream down to fail")
+		}
+
+		// Changing the upstream alone replaces the upstreams.
+		testProxy.Upstream = backup.Addr().String()
+		err = testProxy.Save()
+		if err != nil {
+			t.Fatal("Unable to update proxy:", err)
+		}
+		if len(testProxy.Upstreams) != 1 || testProxy.Balance != "failover" {
+			t.Fatalf("Expected a single upstream and the same balance, got %v and %s",
+				testProxy.Upstreams, testProxy.Balance)
+		}
+		AssertGreeting(t, testProxy.Listen, "backup")
+	})
+}
+
+func TestProxyResolver(t *testing.T) {
+	WithServer(t, func(addr string) {
+		upstream := newGreetingServer(t, "upstream")
+		defer upstream.Close()
+		_, port, _ := net.SplitHostPort(upstream.Addr().String())
+
+		testProxy := client.NewProxy()
+		testProxy.Name = "resolved"
+		testProxy.Listen = "localhost:0"
+		testProxy.Upstream = net.JoinHostPort("upstream.test", port)
+		testProxy.Resolver = &tclient.Resolver{
+			TTL:   1000,
+			Hosts: map[string]string{"upstream.test": "127.0.0.1"},
+		}
+		testProxy.Enabled = true
+		err := testProxy.Save()
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+		AssertGreeting(t, testProxy.Listen, "upstream")
+
+		_, err = testProxy.AddToxic("", "dns_nxdomain", "", 1, nil)
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+		AssertGreeting(t, testProxy.Listen, "")
+
+		testProxy.Resolver.Hosts["upstream.test"] = "upstream.other"
+		err = testProxy.Save()
+		if err == nil {
+			t.Fatal("Expected hosts mapping to hostnames to be rejected")
+		}
+
+		testProxy.Resolver = &tclient.Resolver{}
+		err = testProxy.Save()
+		if err != nil {
+			t.Fatal("Unable to update proxy:", err)
+		}
+		testProxy, err = client.Proxy("resolved")
+		if err != nil {
+			t.Fatal("Unable to retrieve proxy:", err)
+		}
+		if testProxy.Resolver != nil {
+			t.Fatal("Expected the default resolver, got", testProxy.Resolver)
+		}
+	})
+}
+
+func TestProxyTLS(t *testing.T) {
+	WithServer(t, func(addr string) {
+		upstream := httptest.NewTLSServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("upstream"))
+			},
+		))
+		defer upstream.Close()
+
+		testProxy := client.NewProxy()
+		testProxy.Name = "tls"
+		testProxy.Listen = "localhost:0"
+		testProxy.Upstream = upstream.Listener.Addr().String()
+		testProxy.TLS = &tclient.TLS{Cert: "cert.pem"}
+		testProxy.Enabled = true
+		err := testProxy.Save()
+		if err == nil {
+			t.Fatal("Expected a cert without a key to be rejected")
+		}
+
+		testProxy.TLS = &tclient.TLS{}
+		testProxy.UpstreamTLS = &tclient.UpstreamTLS{InsecureSkipVerify: true}
+		err = testProxy.Save()
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		ca, err := client.CACertificate()
+		if err != nil {
+			t.Fatal("Unable to retrieve the CA certificate:", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(ca) {
+			t.Fatal("Expected a PEM CA certificate, got", string(ca))
+		}
+		httpClient := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+			DisableKeepAlives: true,
+		}}
+
+		get := func() (int, string) {
+			resp, err := httpClient.Get("https://" + testProxy.Listen)
+			if err != nil {
+				t.Fatal("Unable to request through the proxy:", err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			return resp.StatusCode, string(body)
+		}
+
+		if status, body := get(); status != http.StatusOK || body != "upstream" {
+			t.Fatalf("Expected the upstream response, got %d: %s", status, body)
+		}
+
+		// Toxics see the plaintext of both sides.
+		_, err = testProxy.AddToxic("", "http", "downstream", 1, tclient.Attributes{
+			"status":      http.StatusServiceUnavailable,
+			"probability": 1,
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+		if status, _ := get(); status != http.StatusServiceUnavailable {
+			t.Fatal("Expected the http toxic to replace the response, got", status)
+		}
+	})
+}
+
+func TestProxyTLSHandshakeToxics(t *testing.T) {
+	WithServer(t, func(addr string) {
+		upstream := newGreetingServer(t, "upstream")
+		defer upstream.Close()
+
+		testProxy := client.NewProxy()
+		testProxy.Name = "tls_handshake"
+		testProxy.Listen = "localhost:0"
+		testProxy.Upstream = upstream.Addr().String()
+		testProxy.TLS = &tclient.TLS{}
+		testProxy.Enabled = true
+		err := testProxy.Save()
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		ca, err := client.CACertificate()
+		if err != nil {
+			t.Fatal("Unable to retrieve the CA certificate:", err)
+		}
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(ca)
+
+		for _, tc := range []struct {
+			name       string
+			toxic      string
+			attributes tclient.Attributes
+			failure    string
+		}{
+			{"none", "", nil, ""},
+			{"expired", "tls_certificate", tclient.Attributes{"expired": true}, "expired"},
+			{"wrong host", "tls_certificate", tclient.Attributes{"host": "other.test"}, "valid for"},
+			{"self signed", "tls_certificate", tclient.Attributes{"self_signed": true}, "unknown"},
+			{"abort", "tls_abort", nil, "internal error"},
+			{"reset", "tls_abort", tclient.Attributes{"reset": true}, "reset"},
+			{"version", "tls_version", tclient.Attributes{"max_version": "1.2"}, "version"},
+			{"delay", "tls_delay", tclient.Attributes{"delay": 100}, ""},
+		} {
+			var toxic *tclient.Toxic
+			if tc.toxic != "" {
+				toxic, err = testProxy.AddToxic("", tc.toxic, "", 1, tc.attributes)
+				if err != nil {
+					t.Fatalf("%s: Error setting toxic: %v", tc.name, err)
+				}
+			}
+
+			start := time.Now()
+			conn, err := tls.Dial("tcp", testProxy.Listen, &tls.Config{
+				RootCAs:    roots,
+				ServerName: "localhost",
+				MinVersion: tls.VersionTLS13,
+			})
+			if tc.failure == "" {
+				if err != nil {
+					t.Fatalf("%s: Expected the handshake to succeed, got %v", tc.name, err)
+				}
+				greeting, _ := io.ReadAll(conn)
+				conn.Close()
+				if string(greeting) != "upstream" {
+					t.Fatalf("%s: Expected greeting through the proxy, got %q", tc.name, greeting)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tc.failure) {
+				t.Fatalf("%s: Expected the handshake to fail with %q, got %v", tc.name, tc.failure, err)
+			}
+			if tc.toxic == "tls_delay" && time.Since(start) < 100*time.Millisecond {
+				t.Fatalf("%s: Expected the handshake to be delayed, took %v", tc.name, time.Since(start))
+			}
+
+			if toxic != nil {
+				err = testProxy.RemoveToxic(toxic.Name)
+				if err != nil {
+					t.Fatalf("%s: Error removing toxic: %v", tc.name, err)
+				}
+			}
+		}
+	})
+}
+
+func TestForwardProxyTLSAbortReset(t *testing.T) {
+	WithServer(t, func(addr string) {
+		upstream := newGreetingServer(t, "upstream")
+		defer upstream.Close()
+
+		testProxy := client.NewProxy()
+		testProxy.Name = "forward_tls"
+		testProxy.Listen = "localhost:0"
+		testProxy.Mode = "socks5"
+		testProxy.TLS = &tclient.TLS{}
+		testProxy.Enabled = true
+		if err := testProxy.Save(); err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+		_, err := testProxy.AddToxic("", "tls_abort", "", 1, tclient.Attributes{"reset": true})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+
+		// The client of a forward proxy is wrapped, it is still reset.
+		conn, code := dialSOCKS5(t, testProxy.Listen, upstream.Addr().String())
+		defer conn.Close()
+		if code != 0 {
+			t.Fatalf("Expected the destination to be reached, got reply %d", code)
+		}
+		err = tls.Client(conn, &tls.Config{InsecureSkipVerify: true}).Handshake()
+		if err == nil || !strings.Contains(err.Error(), "reset") {
+			t.Fatal("Expected the handshake to be reset, got", err)
+		}
+	})
+}
+
+// dialSOCKS5 requests the destination from a SOCKS5 proxy, and returns the
+// connection with the reply code.
+func dialSOCKS5(t *testing.T, addr, destination string) (net.Conn, byte) {
+	host, port, _ := net.SplitHostPort(destination)
+	portNumber, _ := strconv.Atoi(port)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("Unable to dial proxy", err)
+	}
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	request := []byte{5, 1, 0, 5, 1, 0, 3, byte(len(host))}
+	request = append(request, host...)
+	request = append(request, byte(portNumber>>8), byte(portNumber))
+	conn.Write(request)
+
+	reply := make([]byte, 12)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal("Expected a SOCKS5 reply:", err)
+	}
+	if reply[0] != 5 || reply[1] != 0 {
+		t.Fatalf("Expected the proxy to accept no authentication, got %v", reply[:2])
+	}
+	return conn, reply[3]
+}
+
+// dialHTTPConnect requests the destination from an HTTP CONNECT proxy, and
+// returns the connection, read through the buffer of the response, with its status
+// line.
+func dialHTTPConnect(t *testing.T, addr, destination string) (net.Conn, *bufio.Reader, string) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("Unable to dial proxy", err)
+	}
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", destination, destination)
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal("Expected a CONNECT response:", err)
+	}
+	if _, err := reader.ReadString('\n'); err != nil {
+		t.Fatal("Expected the end of the CONNECT response:", err)
+	}
+	return conn, reader, strings.TrimSpace(status)
+}
+
+func TestForwardProxies(t *testing.T) {
+	WithServer(t, func(addr string) {
+		first := newGreetingServer(t, "first")
+		defer first.Close()
+		second := newGreetingServer(t, "second")
+		defer second.Close()
+		_, secondPort, _ := net.SplitHostPort(second.Addr().String())
+
+		testProxy := client.NewProxy()
+		testProxy.Name = "forward"
+		testProxy.Listen = "localhost:0"
+		testProxy.Upstream = first.Addr().String()
+		testProxy.Mode = "socks5"
+		testProxy.Enabled = true
+		if err := testProxy.Save(); err == nil {
+			t.Fatal("Expected an upstream to be rejected on a forward proxy")
+		}
+
+		testProxy.Upstream = ""
+		if err := testProxy.Save(); err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+		httpProxy, err := client.Proxy("forward")
+		if err != nil {
+			t.Fatal("Unable to retrieve proxy:", err)
+		}
+		if httpProxy.Mode != "socks5" || httpProxy.Upstream != "" {
+			t.Fatalf("Expected a socks5 proxy without upstream, got %+v", httpProxy)
+		}
+
+		httpProxy = client.NewProxy()
+		httpProxy.Name = "forward_http"
+		httpProxy.Listen = "localhost:0"
+		httpProxy.Mode = "http_connect"
+		httpProxy.Enabled = true
+		if err := httpProxy.Save(); err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		// Only the clients connecting to the second server are refused.
+		for _, proxy := range []*tclient.Proxy{testProxy, httpProxy} {
+			_, err := proxy.CreateToxic(&tclient.Toxic{
+				Type:     "refuse",
+				Toxicity: 1,
+				Match:    &tclient.Match{Destination: "localhost", DestinationPorts: secondPort},
+			})
+			if err != nil {
+				t.Fatal("Error setting toxic:", err)
+			}
+		}
+
+		for _, tc := range []struct {
+			destination string
+			greeting    string
+		}{
+			{first.Addr().String(), "first"},
+			{net.JoinHostPort("localhost", secondPort), ""},
+			{second.Addr().String(), "second"},
+		} {
+			conn, code := dialSOCKS5(t, testProxy.Listen, tc.destination)
+			greeting, _ := io.ReadAll(conn)
+			conn.Close()
+			if tc.greeting == "" && code != 5 {
+				t.Fatalf("Expected %s to be refused, got reply %d", tc.destination, code)
+			} else if tc.greeting != "" && (code != 0 || string(greeting) != tc.greeting) {
+				t.Fatalf("Expected greeting from %s, got reply %d: %q", tc.destination, code, greeting)
+			}
+
+			conn, reader, status := dialHTTPConnect(t, httpProxy.Listen, tc.destination)
+			greeting, _ = io.ReadAll(reader)
+			conn.Close()
+			if tc.greeting == "" && status != "HTTP/1.1 502 Bad Gateway" {
+				t.Fatalf("Expected %s to be refused, got %s", tc.destination, status)
+			} else if tc.greeting != "" && (status != "HTTP/1.1 200 Connection established" ||
+				string(greeting) != tc.greeting) {
+				t.Fatalf("Expected greeting from %s, got %s: %q", tc.destination, status, greeting)
+			}
+		}
+
+		// Destinations nothing listens on are reported to the client.
+		closed := newGreetingServer(t, "")
+		closed.Close()
+		conn, code := dialSOCKS5(t, testProxy.Listen, closed.Addr().String())
+		conn.Close()
+		if code != 5 {
+			t.Fatalf("Expected the connection to be refused, got reply %d", code)
+		}
+		conn, _, status := dialHTTPConnect(t, httpProxy.Listen, closed.Addr().String())
+		conn.Close()
+		if status != "HTTP/1.1 502 Bad Gateway" {
+			t.Fatalf("Expected the connection to fail, got %s", status)
+		}
+	})
+}
+
+// listenPortRange listens on a range of consecutive free ports.
+func listenPortRange(t *testing.T, size int) []net.Listener {
+	for attempt := 0; attempt < 20; attempt++ {
+		first, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatal("Failed to create TCP server", err)
+		}
+		listeners := []net.Listener{first}
+		port := first.Addr().(*net.TCPAddr).Port
+		for i := 1; i < size; i++ {
+			ln, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(port+i)))
+			if err != nil {
+				break
+			}
+			listeners = append(listeners, ln)
+		}
+		if len(listeners) == size {
+			return listeners
+		}
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}
+	t.Fatal("Unable to find a range of free ports")
+	return nil
+}
+
+// portRange returns the range of ports of the listeners, as in a listen address.
+func portRange(listeners []net.Listener) string {
+	first := listeners[0].Addr().(*net.TCPAddr).Port
+	last := listeners[len(listeners)-1].Addr().(*net.TCPAddr).Port
+	return fmt.Sprintf("localhost:%d-%d", first, last)
+}
+
+func TestProxyPortRange(t *testing.T) {
+	WithServer(t, func(addr string) {
+		upstreams := listenPortRange(t, 3)
+		for i, ln := range upstreams {
+			defer ln.Close()
+			go func(ln net.Listener, greeting string) {
+				for {
+					conn, err := ln.Accept()
+					if err != nil {
+						return
+					}
+					conn.Write([]byte(greeting))
+					conn.Close()
+				}
+			}(ln, strconv.Itoa(i))
+		}
+
+		// The listen ports are released for the proxy to take them.
+		listeners := listenPortRange(t, 3)
+		for _, ln := range listeners {
+			ln.Close()
+		}
+
+		testProxy := client.NewProxy()
+		testProxy.Name = "sharded"
+		testProxy.Listen = portRange(listeners)
+		testProxy.Upstream = portRange(upstreams[:2])
+		testProxy.Enabled = true
+		if err := testProxy.Save(); err == nil {
+			t.Fatal("Expected upstream ports of a different range size to be rejected")
+		}
+
+		testProxy.Upstream = portRange(upstreams)
+		if err := testProxy.Save(); err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+		if testProxy.Listen != portRange(listeners) {
+			t.Fatalf("Expected the proxy to listen on %s, got %s", portRange(listeners), testProxy.Listen)
+		}
+
+		for i, ln := range listeners {
+			AssertGreeting(t, ln.Addr().String(), strconv.Itoa(i))
+		}
+
+		// All the ports share the toxics of the proxy.
+		_, err := testProxy.AddToxic("", "refuse", "", 1, nil)
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+		for _, ln := range listeners {
+			AssertGreeting(t, ln.Addr().String(), "")
+		}
+
+		udpProxy := client.NewProxy()
+		udpProxy.Name = "sharded_udp"
+		udpProxy.Listen = "localhost:0"
+		udpProxy.Upstream = portRange(upstreams)
+		udpProxy.Protocol = "udp"
+		if err := udpProxy.Save(); err == nil {
+			t.Fatal("Expected port ranges to be rejected on udp proxies")
+		}
+	})
+}
+
+// assertProxyNames checks the names of the proxies, in any order.
+func assertProxyNames(t *testing.T, proxies map[string]*tclient.Proxy, expected ...string) {
+	if len(proxies) != len(expected) {
+		t.Fatalf("Expected proxies %v, got %v", expected, proxies)
+	}
+	for _, name := range expected {
+		if _, ok := proxies[name]; !ok {
+			t.Fatalf("Expected proxies %v, got %v", expected, proxies)
+		}
+	}
+}
+
+func TestProxyLabels(t *testing.T) {
+	WithServer(t, func(addr string) {
+		for name, labels := range map[string]map[string]string{
+			"payments_prod":    {"team": "payments", "env": "prod"},
+			"payments_staging": {"team": "payments", "env": "staging"},
+			"search":           {"team": "search"},
+		} {
+			proxy := client.NewProxy()
+			proxy.Name = name
+			proxy.Listen = "localhost:0"
+			proxy.Upstream = "localhost:20000"
+			proxy.Labels = labels
+			proxy.Enabled = true
+			if err := proxy.Save(); err != nil {
+				t.Fatal("Unable to create proxy:", err)
+			}
+		}
+
+		for selector, expected := range map[string][]string{
+			"":                        {"payments_prod", "payments_staging", "search"},
+			"team=payments":           {"payments_prod", "payments_staging"},
+			"team=payments,env!=prod": {"payments_staging"},
+			"env":                     {"payments_prod", "payments_staging"},
+			"!env":                    {"search"},
+			"team==other":             {},
+		} {
+			proxies, err := client.SelectProxies(selector)
+			if err != nil {
+				t.Fatalf("Unable to select proxies with %q: %v", selector, err)
+			}
+			assertProxyNames(t, proxies, expected...)
+		}
+
+		if _, err := client.SelectProxies("team=a=b"); err == nil {
+			t.Fatal("Expected an invalid selector to be rejected")
+		}
+
+		proxy, err := client.Proxy("search")
+		if err != nil {
+			t.Fatal("Unable to retrieve proxy:", err)
+		}
+		proxy.Labels = map[string]string{"team name": "search"}
+		if err := proxy.Save(); err == nil {
+			t.Fatal("Expected invalid labels to be rejected")
+		}
+
+		// Labels sent replace the existing ones.
+		proxy.Labels = map[string]string{"owner": "search"}
+		if err := proxy.Save(); err != nil {
+			t.Fatal("Unable to update proxy:", err)
+		}
+		if len(proxy.Labels) != 1 || proxy.Labels["owner"] != "search" {
+			t.Fatalf("Expected the labels to be replaced, got %v", proxy.Labels)
+		}
+	})
+}
+
+func TestBulkOperations(t *testing.T) {
+	WithServer(t, func(addr string) {
+		for _, name := range []string{"payments_api", "payments_db", "search"} {
+			proxy := client.NewProxy()
+			proxy.Name = name
+			proxy.Listen = "localhost:0"
+			proxy.Upstream = "localhost:20000"
+			proxy.Labels = map[string]string{"team": strings.Split(name, "_")[0]}
+			proxy.Enabled = true
+			if err := proxy.Save(); err != nil {
+				t.Fatal("Unable to create proxy:", err)
+			}
+		}
+
+		if _, err := client.BulkDisable(""); err == nil {
+			t.Fatal("Expected a bulk operation without selector to be rejected")
+		}
+
+		proxies, err := client.BulkCreateToxic("team=payments", &tclient.Toxic{
+			Type:       "latency",
+			Toxicity:   1,
+			Attributes: tclient.Attributes{"latency": 100},
+		})
+		if err != nil {
+			t.Fatal("Unable to add toxic:", err)
+		}
+		assertProxyNames(t, proxies, "payments_api", "payments_db")
+		for _, proxy := range proxies {
+			AssertToxicExists(t, proxy.ActiveToxics, "latency_downstream", "latency", "downstream", true)
+		}
+		search, err := client.Proxy("search")
+		if err != nil {
+			t.Fatal("Unable to retrieve proxy:", err)
+		}
+		AssertToxicExists(t, search.ActiveToxics, "latency_downstream", "latency", "downstream", false)
+
+		// Operations failing on one of the proxies apply to none of them.
+		db, err := client.Proxy("payments_db")
+		if err != nil {
+			t.Fatal("Unable to retrieve proxy:", err)
+		}
+		if _, err := db.AddToxic("slow", "latency", "upstream", 1, nil); err != nil {
+			t.Fatal("Unable to add toxic:", err)
+		}
+		_, err = client.BulkCreateToxic("team=payments", &tclient.Toxic{
+			Name:     "slow",
+			Type:     "latency",
+			Stream:   "upstream",
+			Toxicity: 1,
+		})
+		if err == nil || !strings.Contains(err.Error(), "payments_db") {
+			t.Fatal("Expected the existing toxic to be reported, got", err)
+		}
+		_, err = client.BulkUpdateToxic("team=payments", "slow", -1, tclient.Attributes{"latency": 1})
+		if err == nil || !strings.Contains(err.Error(), "payments_api") {
+			t.Fatal("Expected the missing toxic to be reported, got", err)
+		}
+		_, err = client.BulkRemoveToxic("team=payments", "slow")
+		if err == nil {
+			t.Fatal("Expected the missing toxic to be reported")
+		}
+		_, err = client.BulkUpdateToxic("team=payments", "latency_downstream", -1,
+			tclient.Attributes{"distribution": "unknown"})
+		if err == nil {
+			t.Fatal("Expected an invalid update to be rejected")
+		}
+		api, err := client.Proxy("payments_api")
+		if err != nil {
+			t.Fatal("Unable to retrieve proxy:", err)
+		}
+		toxic := AssertToxicExists(t, api.ActiveToxics, "slow", "latency", "upstream", false)
+		if toxic != nil {
+			t.Fatal("Expected the failed operations to leave payments_api alone")
+		}
+		if _, err := db.AddToxic("", "latency", "", 1, nil); err == nil {
+			t.Fatal("Expected payments_db to still have its latency toxic")
+		}
+
+		proxies, err = client.BulkUpdateToxic("team=payments", "latency_downstream", -1,
+			tclient.Attributes{"latency": 500})
+		if err != nil {
+			t.Fatal("Unable to update toxic:", err)
+		}
+		for _, proxy := range proxies {
+			toxic := AssertToxicExists(t, proxy.ActiveToxics,
+				"latency_downstream", "latency", "downstream", true)
+			if toxic.Attributes["latency"] != 500.0 {
+				t.Fatalf("Expected the latency of %s to be updated, got %v",
+					proxy.Name, toxic.Attributes)
+			}
+		}
+
+		proxies, err = client.BulkRemoveToxic("team=payments", "latency_downstream")
+		if err != nil {
+			t.Fatal("Unable to remove toxic:", err)
+		}
+		for _, proxy := range proxies {
+			AssertToxicExists(t, proxy.ActiveToxics,
+				"latency_downstream", "latency", "downstream", false)
+		}
+
+		proxies, err = client.BulkDisable("team=payments")
+		if err != nil {
+			t.Fatal("Unable to disable proxies:", err)
+		}
+		for _, proxy := range proxies {
+			if proxy.Enabled {
+				t.Fatalf("Expected %s to be disabled", proxy.Name)
+			}
+		}
+
+		// Proxies enabled before one fails to start are disabled again.
+		ln, err := net.Listen("tcp", proxies["payments_db"].Listen)
+		if err != nil {
+			t.Fatal("Unable to listen on the port of payments_db:", err)
+		}
+		if _, err := client.BulkEnable("team=payments"); err == nil {
+			t.Fatal("Expected payments_db to fail to start")
+		}
+		ln.Close()
+		api, err = client.Proxy("payments_api")
+		if err != nil {
+			t.Fatal("Unable to retrieve proxy:", err)
+		}
+		if api.Enabled {
+			t.Fatal("Expected payments_api to be disabled again")
+		}
+
+		proxies, err = client.BulkEnable("team=payments")
+		if err != nil {
+			t.Fatal("Unable to enable proxies:", err)
+		}
+		for _, proxy := range proxies {
+			if !proxy.Enabled {
+				t.Fatalf("Expected %s to be enabled", proxy.Name)
+			}
+		}
+	})
+}
+
+func TestProxyRoundRobin(t *testing.T) {
+	WithServer(t, func(addr string) {
+		first := newGreetingServer(t, "first")
+		defer first.Close()
+		second := newGreetingServer(t, "second")
+		defer second.Close()
+
+		testProxy := client.NewProxy()
+		testProxy.Name = "replicas"
+		testProxy.Listen = "localhost:0"
+		testProxy.Upstreams = []string{first.Addr().String(), second.Addr().String()}
+		testProxy.Enabled = true
+		err := testProxy.Save()
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+		if testProxy.Balance != "round_robin" {
+			t.Fatal("Expected round robin balance by default, got", testProxy.Balance)
+		}
+
+		AssertGreeting(t, testProxy.Listen, "first")
+		AssertGreeting(t, testProxy.Listen, "second")
+		AssertGreeting(t, testProxy.Listen, "first")
+
+		testProxy.Balance = "fastest"
+		err = testProxy.Save()
+		if err == nil {
+			t.Fatal("Expected invalid balance to be rejected")
+		}
+	})
+}
+
+func TestServerShutdown(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		closeClient  bool
+		drainTimeout time.Duration
+		stuck        bool
+		err          error
+	}{
+		{"drained", true, time.Minute, false, nil},
+		{"no drain", false, 0, false, nil},
+		{"exit timeout", false, time.Minute, false, context.DeadlineExceeded},
+		{"stuck proxies", false, 0, true, context.DeadlineExceeded},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			server := toxiproxy.NewServer(
+				toxiproxy.NewMetricsContainer(prometheus.NewRegistry()),
+				zerolog.Nop(),
+			)
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal("Unable to listen for the API:", err)
+			}
+			listening := make(chan struct{})
+			go func() {
+				defer close(listening)
+				server.Serve(ln)
+			}()
+			time.Sleep(50 * time.Millisecond)
+
+			echo := newEchoServer(t)
+			defer echo.Close()
+
+			shutdownClient := tclient.NewClient("http://" + ln.Addr().String())
+			testProxy, err := shutdownClient.CreateProxy("echo", "localhost:0", echo.Addr().String())
+			if err != nil {
+				t.Fatal("Unable to create proxy:", err)
+			}
+			conn := dialEchoProxy(t, testProxy)
+			defer conn.Close()
+
+			if tc.closeClient {
+				time.AfterFunc(50*time.Millisecond, func() { conn.Close() })
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			if tc.stuck {
+				// Holding the collection keeps the proxies from being stopped.
+				server.Collection.RLock()
+			}
+			start := time.Now()
+			err = server.Shutdown(ctx, tc.drainTimeout)
+			if tc.stuck {
+				server.Collection.RUnlock()
+			}
+			if err != tc.err {
+				t.Fatalf("Expected shutdown to return %v, got %v", tc.err, err)
+			}
+			if tc.closeClient && time.Since(start) > 150*time.Millisecond {
+				t.Fatal("Expected shutdown once the connection was closed, took", time.Since(start))
+			}
+			if time.Since(start) > 300*time.Millisecond {
+				t.Fatal("Expected shutdown by the deadline, took", time.Since(start))
+			}
+			<-listening
+
+			// Past the deadline the proxies are stopped in the background.
+			for i := 0; len(server.Collection.Proxies()) != 0; i++ {
+				if i == 100 {
+					t.Fatal("Expected proxies to be removed")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			AssertProxyUp(t, testProxy.Listen, false)
+			_, err = shutdownClient.Proxies()
+			if err == nil {
+				t.Fatal("Expected HTTP server to be stopped")
+			}
+			if !tc.closeClient {
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+					t.Fatal("Expected connection to be closed, got", err)
+				}
+			}
+		})
+	}
+}
+
 func TestVersionEndpointReturnsVersion(t *testing.T) {
 	WithServer(t, func(addr string) {
 		resp, err := http.Get(addr + "/version")
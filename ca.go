@@ -0,0 +1,197 @@
+package toxiproxy
+
+import (
+	"container/list"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+var ErrInvalidCAKey = errors.New("CA key can not sign certificates")
+
+// maxCALeaves is the number of signed certificates kept by the CA. Clients choose
+// the server name, so the least recently used certificates are dropped past it.
+const maxCALeaves = 128
+
+// certAuthority signs the certificates of the proxies terminating TLS without a
+// certificate of their own. Unless loaded from files, the CA is generated on first
+// use and only lives as long as the server.
+type certAuthority struct {
+	sync.Mutex
+
+	cert *x509.Certificate
+	key  crypto.Signer
+	// Key of all the certificates signed, generated once
+	leafKey *ecdsa.PrivateKey
+	// Certificates already signed, by their options, and the most recently used
+	// first
+	leaves map[toxics.CertificateOptions]*list.Element
+	used   *list.List
+}
+
+type caLeaf struct {
+	options toxics.CertificateOptions
+	cert    *tls.Certificate
+}
+
+var internalCA = &certAuthority{}
+
+// LoadCA makes the internal CA use the PEM certificate and key of the files, so
+// clients can trust the certificates it signs across restarts of the server.
+func LoadCA(certFile, keyFile string) error {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return err
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return ErrInvalidCAKey
+	}
+
+	internalCA.Lock()
+	defer internalCA.Unlock()
+
+	internalCA.cert = cert
+	internalCA.key = key
+	internalCA.leaves = nil
+	internalCA.used = nil
+	return nil
+}
+
+// init generates the CA unless it was already generated or loaded, assumes the
+// lock has already been taken.
+func (ca *certAuthority) init() error {
+	if ca.cert != nil {
+		return nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          newSerialNumber(),
+		Subject:               pkix.Name{CommonName: "Toxiproxy CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+
+	ca.cert = cert
+	ca.key = key
+	return nil
+}
+
+// sign returns a certificate signed by the CA, for the server name of the options.
+// Clients sending no server name get a certificate for localhost and its addresses.
+func (ca *certAuthority) sign(options toxics.CertificateOptions) (*tls.Certificate, error) {
+	ca.Lock()
+	defer ca.Unlock()
+
+	if element, ok := ca.leaves[options]; ok {
+		ca.used.MoveToFront(element)
+		return element.Value.(*caLeaf).cert, nil
+	}
+	if err := ca.init(); err != nil {
+		return nil, err
+	}
+
+	if ca.leafKey == nil {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		ca.leafKey = key
+	}
+	key := ca.leafKey
+	serverName := options.ServerName
+	template := &x509.Certificate{
+		SerialNumber: newSerialNumber(),
+		Subject:      pkix.Name{CommonName: serverName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if options.Expired {
+		template.NotBefore = time.Now().AddDate(-1, 0, 0)
+		template.NotAfter = time.Now().AddDate(0, 0, -1)
+	}
+	if serverName == "" {
+		template.Subject.CommonName = "localhost"
+		template.DNSNames = []string{"localhost"}
+		template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	} else if ip := net.ParseIP(serverName); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{serverName}
+	}
+
+	parent, signer, chain := ca.cert, crypto.Signer(ca.key), [][]byte{ca.cert.Raw}
+	if options.SelfSigned {
+		parent, signer, chain = template, key, nil
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf := &tls.Certificate{
+		Certificate: append([][]byte{der}, chain...),
+		PrivateKey:  key,
+	}
+	if ca.leaves == nil {
+		ca.leaves = make(map[toxics.CertificateOptions]*list.Element)
+		ca.used = list.New()
+	}
+	ca.leaves[options] = ca.used.PushFront(&caLeaf{options, leaf})
+	if ca.used.Len() > maxCALeaves {
+		oldest := ca.used.Remove(ca.used.Back()).(*caLeaf)
+		delete(ca.leaves, oldest.options)
+	}
+	return leaf, nil
+}
+
+// caCertificate returns the DER certificate of the CA, generating it if needed.
+func (ca *certAuthority) caCertificate() ([]byte, error) {
+	ca.Lock()
+	defer ca.Unlock()
+
+	if err := ca.init(); err != nil {
+		return nil, err
+	}
+	return ca.cert.Raw, nil
+}
+
+func newSerialNumber() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return serial
+}
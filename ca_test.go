@@ -0,0 +1,75 @@
+package toxiproxy
+
+import (
+	"crypto/x509"
+	"fmt"
+	"testing"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+func TestCACertificates(t *testing.T) {
+	ca := &certAuthority{}
+	der, err := ca.caCertificate()
+	if err != nil {
+		t.Fatal("Failed to generate CA", err)
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal("Failed to parse CA certificate", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+
+	for _, tc := range []struct {
+		serverName string
+		verify     []string
+	}{
+		{"db.internal", []string{"db.internal"}},
+		{"10.0.0.1", []string{"10.0.0.1"}},
+		{"", []string{"localhost", "127.0.0.1", "::1"}},
+	} {
+		options := toxics.CertificateOptions{ServerName: tc.serverName}
+		leaf, err := ca.sign(options)
+		if err != nil {
+			t.Fatal("Failed to sign certificate", err)
+		}
+		cached, _ := ca.sign(options)
+		if cached != leaf {
+			t.Errorf("Expected certificate for %q to be cached", tc.serverName)
+		}
+
+		cert, err := x509.ParseCertificate(leaf.Certificate[0])
+		if err != nil {
+			t.Fatal("Failed to parse certificate", err)
+		}
+		for _, name := range tc.verify {
+			_, err := cert.Verify(x509.VerifyOptions{DNSName: name, Roots: roots})
+			if err != nil {
+				t.Errorf("Expected certificate for %q to be valid for %s: %v", tc.serverName, name, err)
+			}
+		}
+	}
+}
+
+func TestCACertificatesCacheIsBounded(t *testing.T) {
+	ca := &certAuthority{}
+	first, err := ca.sign(toxics.CertificateOptions{ServerName: "first.internal"})
+	if err != nil {
+		t.Fatal("Failed to sign certificate", err)
+	}
+	for i := 0; i < maxCALeaves; i++ {
+		_, err = ca.sign(toxics.CertificateOptions{ServerName: fmt.Sprintf("%d.internal", i)})
+		if err != nil {
+			t.Fatal("Failed to sign certificate", err)
+		}
+	}
+
+	if len(ca.leaves) != maxCALeaves || ca.used.Len() != maxCALeaves {
+		t.Fatalf("Expected %d cached certificates, got %d", maxCALeaves, len(ca.leaves))
+	}
+	again, _ := ca.sign(toxics.CertificateOptions{ServerName: "first.internal"})
+	if again == first {
+		t.Fatal("Expected the least recently used certificate to be dropped")
+	}
+}
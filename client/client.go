@@ -11,7 +11,10 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Client holds information about where to connect to Toxiproxy.
@@ -27,18 +30,101 @@ type Toxic struct {
 	Stream     string     `json:"stream,omitempty"`
 	Toxicity   float32    `json:"toxicity"`
 	Attributes Attributes `json:"attributes"`
+	Schedule   *Schedule  `json:"schedule,omitempty"`
+	// When the toxicity is rolled: "connection", "chunk", or every time the toxic
+	// starts on a link when empty
+	ToxicityMode string `json:"toxicity_mode,omitempty"`
+	Match        *Match `json:"match,omitempty"`
+}
+
+// Match restricts a toxic to the connections of some clients, or starting with
+// some data. All the criteria set must match.
+type Match struct {
+	Client string `json:"client,omitempty"` // Client address or network in CIDR notation
+	Ports  string `json:"ports,omitempty"`  // Client port or range of ports, as "from-to"
+	Prefix string `json:"prefix,omitempty"` // Literal the data sent by the client starts with
+	Regex  string `json:"regex,omitempty"`  // Regular expression in the first bytes sent
+	Bytes  int    `json:"bytes,omitempty"`  // Number of bytes the regex is matched against
+
+	// Destination host requested from a forward proxy, or "*." and a domain
+	Destination string `json:"destination,omitempty"`
+	// Destination port or range of ports, as "from-to"
+	DestinationPorts string `json:"destination_ports,omitempty"`
+}
+
+// Schedule turns a toxic on after Start, for Duration, every Period. Times are
+// in milliseconds.
+type Schedule struct {
+	Start    int64  `json:"start"`
+	Duration int64  `json:"duration"`
+	Period   int64  `json:"period"`
+	Jitter   int64  `json:"jitter"`
+	Phase    string `json:"phase,omitempty"` // The current phase, set by the server
 }
 
 type Toxics []Toxic
 
+// Connection is an open client connection of a proxy.
+type Connection struct {
+	Client          string    `json:"client"`           // The address of the client
+	Destination     string    `json:"destination"`      // Requested from a forward proxy
+	Started         time.Time `json:"started"`          // When the client connected
+	UpstreamBytes   int64     `json:"upstream_bytes"`   // Bytes received from the client
+	DownstreamBytes int64     `json:"downstream_bytes"` // Bytes received from the upstream
+	Toxics          []string  `json:"toxics"`           // The toxics applying to it
+}
+
+// Resolver controls how a proxy resolves the hostnames of its upstreams.
+type Resolver struct {
+	TTL   int64             `json:"ttl"`   // Milliseconds addresses are cached for
+	Hosts map[string]string `json:"hosts"` // IP addresses of hostnames
+}
+
+// TLS terminates TLS on the listener of a proxy. Without a cert and key, the
+// certificates are signed by the internal CA of the server.
+type TLS struct {
+	Cert string `json:"cert,omitempty"` // File of the PEM certificate
+	Key  string `json:"key,omitempty"`  // File of the PEM key
+}
+
+// UpstreamTLS originates TLS to the upstreams of a proxy.
+type UpstreamTLS struct {
+	ServerName         string `json:"server_name,omitempty"`          // Defaults to the upstream host
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"` // Skip certificate checks
+	CA                 string `json:"ca,omitempty"`                   // File of the PEM CAs to trust
+}
+
+// Upstream is an upstream of a proxy, and whether it is marked down.
+type Upstream struct {
+	Address string `json:"address"` // The upstream address
+	Down    bool   `json:"down"`    // Whether new clients skip it
+}
+
 type Proxy struct {
-	Name     string `json:"name"`     // The name of the proxy
-	Listen   string `json:"listen"`   // The address the proxy listens on
-	Upstream string `json:"upstream"` // The upstream address to proxy to
-	Enabled  bool   `json:"enabled"`  // Whether the proxy is enabled
+	Name     string `json:"name"`               // The name of the proxy
+	Listen   string `json:"listen"`             // The address the proxy listens on
+	Upstream string `json:"upstream"`           // The upstream address to proxy to
+	Enabled  bool   `json:"enabled"`            // Whether the proxy is enabled
+	Protocol string `json:"protocol,omitempty"` // The protocol to proxy, tcp or udp (defaults to tcp)
+	Mode     string `json:"mode,omitempty"`     // socks5 or http_connect for forward proxies
+
+	Labels map[string]string `json:"labels"` // Free-form labels selecting the proxy
+
+	Upstreams []string `json:"upstreams,omitempty"` // Upstreams new clients are balanced between
+	Balance   string   `json:"balance,omitempty"`   // round_robin, random or failover
+
+	Resolver *Resolver `json:"resolver,omitempty"` // How upstream hostnames are resolved
+
+	TLS         *TLS         `json:"tls"`          // TLS terminated on the listener
+	UpstreamTLS *UpstreamTLS `json:"upstream_tls"` // TLS originated to the upstreams
+
+	IdleTimeout int64 `json:"idle_timeout,omitempty"` // UDP only: close idle sessions after ms
+	MaxSessions int   `json:"max_sessions,omitempty"` // UDP only: max concurrent client sessions
 
 	ActiveToxics Toxics `json:"toxics"` // The toxics active on this proxy
 
+	Draining bool `json:"draining,omitempty"` // Whether open connections are left to finish
+
 	client  *Client
 	created bool // True if this proxy exists on the server
 }
@@ -60,7 +146,28 @@ func (client *Client) Proxies() (map[string]*Proxy, error) {
 		return nil, err
 	}
 
-	err = checkError(resp, http.StatusOK, "Proxies")
+	return client.decodeProxies(resp, http.StatusOK, "Proxies")
+}
+
+// SelectProxies returns the proxies whose labels match the selector, like
+// team=payments,env!=production.
+func (client *Client) SelectProxies(selector string) (map[string]*Proxy, error) {
+	resp, err := http.Get(client.endpoint + "/proxies?selector=" + url.QueryEscape(selector))
+	if err != nil {
+		return nil, err
+	}
+
+	return client.decodeProxies(resp, http.StatusOK, "SelectProxies")
+}
+
+func (client *Client) decodeProxies(
+	resp *http.Response,
+	expectedCode int,
+	caller string,
+) (map[string]*Proxy, error) {
+	defer resp.Body.Close()
+
+	err := checkError(resp, expectedCode, caller)
 	if err != nil {
 		return nil, err
 	}
@@ -178,13 +285,16 @@ func (client *Client) AddToxic(options *ToxicOptions) (*Toxic, error) {
 		return nil, fmt.Errorf("failed to retrieve proxy with name `%s`: %v", options.ProxyName, err)
 	}
 
-	toxic, err := proxy.AddToxic(
-		options.ToxicName,
-		options.ToxicType,
-		options.Stream,
-		options.Toxicity,
-		options.Attributes,
-	)
+	toxic, err := proxy.CreateToxic(&Toxic{
+		Name:         options.ToxicName,
+		Type:         options.ToxicType,
+		Stream:       options.Stream,
+		Toxicity:     options.Toxicity,
+		Attributes:   options.Attributes,
+		Schedule:     options.Schedule,
+		ToxicityMode: options.ToxicityMode,
+		Match:        options.Match,
+	})
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to add toxic to proxy %s: %v", options.ProxyName, err)
@@ -285,6 +395,33 @@ func (proxy *Proxy) Disable() error {
 	return proxy.Save()
 }
 
+// Drain stops accepting new connections on a proxy, and lets the open connections
+// finish. Connections still open after the timeout are closed, and the proxy is then
+// disabled. Drain returns right away, while the proxy is draining.
+func (proxy *Proxy) Drain(timeout time.Duration) error {
+	request, err := json.Marshal(map[string]int64{"timeout": timeout.Milliseconds()})
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(
+		proxy.client.endpoint+"/proxies/"+proxy.Name+"/drain",
+		"application/json",
+		bytes.NewReader(request),
+	)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	err = checkError(resp, http.StatusAccepted, "Drain")
+	if err != nil {
+		return err
+	}
+
+	return json.NewDecoder(resp.Body).Decode(proxy)
+}
+
 // Delete a proxy complete and close all existing connections through it. All information about
 // the proxy such as listen port and active toxics will be deleted as well. If you just wish to
 // stop and later enable a proxy, use `Enable()` and `Disable()`.
@@ -333,12 +470,34 @@ func (proxy *Proxy) AddToxic(
 	toxicity float32,
 	attrs Attributes,
 ) (*Toxic, error) {
-	toxic := Toxic{name, typeName, stream, toxicity, attrs}
+	return proxy.AddScheduledToxic(name, typeName, stream, toxicity, attrs, nil)
+}
+
+// AddScheduledToxic adds a toxic which is turned on and off following the
+// schedule. It is always active when the schedule is nil.
+func (proxy *Proxy) AddScheduledToxic(
+	name, typeName, stream string,
+	toxicity float32,
+	attrs Attributes,
+	schedule *Schedule,
+) (*Toxic, error) {
+	return proxy.CreateToxic(&Toxic{
+		Name:       name,
+		Type:       typeName,
+		Stream:     stream,
+		Toxicity:   toxicity,
+		Attributes: attrs,
+		Schedule:   schedule,
+	})
+}
+
+// CreateToxic adds a toxic with all its fields, such as its toxicity mode.
+func (proxy *Proxy) CreateToxic(toxic *Toxic) (*Toxic, error) {
 	if toxic.Toxicity == -1 {
 		toxic.Toxicity = 1 // Just to be consistent with a toxicity of -1 using the default
 	}
 
-	request, err := json.Marshal(&toxic)
+	request, err := json.Marshal(toxic)
 	if err != nil {
 		return nil, err
 	}
@@ -375,6 +534,18 @@ func (proxy *Proxy) UpdateToxic(name string, toxicity float32, attrs Attributes)
 	if toxicity != -1 {
 		toxic["toxicity"] = toxicity
 	}
+	return proxy.updateToxic(name, toxic)
+}
+
+// ScheduleToxic replaces the schedule of an existing toxic with the given name.
+// A nil schedule makes the toxic always active.
+func (proxy *Proxy) ScheduleToxic(name string, schedule *Schedule) (*Toxic, error) {
+	return proxy.updateToxic(name, map[string]interface{}{
+		"schedule": schedule,
+	})
+}
+
+func (proxy *Proxy) updateToxic(name string, toxic map[string]interface{}) (*Toxic, error) {
 	request, err := json.Marshal(&toxic)
 	if err != nil {
 		return nil, err
@@ -423,6 +594,180 @@ func (proxy *Proxy) RemoveToxic(name string) error {
 	return checkError(resp, http.StatusNoContent, "RemoveToxic")
 }
 
+// Connections returns the open client connections of the proxy.
+func (proxy *Proxy) Connections() ([]Connection, error) {
+	resp, err := http.Get(proxy.client.endpoint + "/proxies/" + proxy.Name + "/connections")
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkError(resp, http.StatusOK, "Connections")
+	if err != nil {
+		return nil, err
+	}
+
+	connections := make([]Connection, 0)
+	err = json.NewDecoder(resp.Body).Decode(&connections)
+	if err != nil {
+		return nil, err
+	}
+
+	return connections, nil
+}
+
+// CloseConnection closes the connection of the client with the given address.
+// With reset, the connection is reset instead of being closed gracefully.
+func (proxy *Proxy) CloseConnection(client string, reset bool) error {
+	httpClient := &http.Client{}
+	req, err := http.NewRequest(
+		"DELETE",
+		proxy.client.endpoint+"/proxies/"+proxy.Name+"/connections/"+url.PathEscape(client)+
+			"?reset="+strconv.FormatBool(reset),
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return checkError(resp, http.StatusNoContent, "CloseConnection")
+}
+
+// UpstreamStates returns the upstreams of the proxy, and whether they are marked
+// down.
+func (proxy *Proxy) UpstreamStates() ([]Upstream, error) {
+	resp, err := http.Get(proxy.client.endpoint + "/proxies/" + proxy.Name + "/upstreams")
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkError(resp, http.StatusOK, "UpstreamStates")
+	if err != nil {
+		return nil, err
+	}
+
+	upstreams := make([]Upstream, 0)
+	err = json.NewDecoder(resp.Body).Decode(&upstreams)
+	if err != nil {
+		return nil, err
+	}
+
+	return upstreams, nil
+}
+
+// SetUpstreamDown marks an upstream of the proxy down, so new clients connect to
+// the other upstreams, or marks it up again. Open connections are left alone.
+func (proxy *Proxy) SetUpstreamDown(address string, down bool) error {
+	request, err := json.Marshal(Upstream{Address: address, Down: down})
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(
+		proxy.client.endpoint+"/proxies/"+proxy.Name+"/upstreams/"+url.PathEscape(address),
+		"application/json",
+		bytes.NewReader(request),
+	)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return checkError(resp, http.StatusOK, "SetUpstreamDown")
+}
+
+// BulkEnable enables all the proxies matching the selector, or none of them when
+// one fails to start. It returns the proxies matching the selector.
+func (client *Client) BulkEnable(selector string) (map[string]*Proxy, error) {
+	return client.bulk("POST", "/bulk/enable", selector, nil, "BulkEnable")
+}
+
+// BulkDisable disables all the proxies matching the selector.
+func (client *Client) BulkDisable(selector string) (map[string]*Proxy, error) {
+	return client.bulk("POST", "/bulk/disable", selector, nil, "BulkDisable")
+}
+
+// BulkCreateToxic adds the toxic to all the proxies matching the selector, or to
+// none of them when one of them can not take it.
+func (client *Client) BulkCreateToxic(selector string, toxic *Toxic) (map[string]*Proxy, error) {
+	if toxic.Toxicity == -1 {
+		toxic.Toxicity = 1 // Just to be consistent with a toxicity of -1 using the default
+	}
+	return client.bulk("POST", "/bulk/toxics", selector, toxic, "BulkCreateToxic")
+}
+
+// BulkUpdateToxic updates the toxic with the given name on all the proxies
+// matching the selector, or on none of them when one of them does not have it.
+func (client *Client) BulkUpdateToxic(
+	selector, name string,
+	toxicity float32,
+	attrs Attributes,
+) (map[string]*Proxy, error) {
+	toxic := map[string]interface{}{
+		"attributes": attrs,
+	}
+	if toxicity != -1 {
+		toxic["toxicity"] = toxicity
+	}
+	return client.bulk("POST", "/bulk/toxics/"+name, selector, toxic, "BulkUpdateToxic")
+}
+
+// BulkRemoveToxic removes the toxic with the given name from all the proxies
+// matching the selector, or from none of them when one of them does not have it.
+func (client *Client) BulkRemoveToxic(selector, name string) (map[string]*Proxy, error) {
+	return client.bulk("DELETE", "/bulk/toxics/"+name, selector, nil, "BulkRemoveToxic")
+}
+
+func (client *Client) bulk(
+	method, path, selector string,
+	body interface{},
+	caller string,
+) (map[string]*Proxy, error) {
+	request, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := &http.Client{}
+	req, err := http.NewRequest(
+		method,
+		client.endpoint+path+"?selector="+url.QueryEscape(selector),
+		bytes.NewReader(request),
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.decodeProxies(resp, http.StatusOK, caller)
+}
+
+// CACertificate returns the PEM certificate of the CA signing the certificates of
+// the proxies terminating TLS without a certificate of their own.
+func (client *Client) CACertificate() ([]byte, error) {
+	resp, err := http.Get(client.endpoint + "/tls/ca")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	err = checkError(resp, http.StatusOK, "CACertificate")
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
 // ResetState resets the state of all proxies and toxics in Toxiproxy.
 func (client *Client) ResetState() error {
 	resp, err := http.Post(client.endpoint+"/reset", "text/plain", bytes.NewReader([]byte{}))
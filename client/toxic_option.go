@@ -4,7 +4,10 @@ type ToxicOptions struct {
 	ProxyName,
 	ToxicName,
 	ToxicType,
-	Stream string
+	Stream,
+	ToxicityMode string
 	Toxicity   float32
 	Attributes Attributes
+	Schedule   *Schedule
+	Match      *Match
 }
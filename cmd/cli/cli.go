@@ -6,6 +6,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/urfave/cli/v2"
 	terminal "golang.org/x/term"
@@ -34,10 +35,13 @@ func color(color string) string {
 var toxicDescription = `
   Default Toxics:
   latency:    delay all data +/- jitter
-              latency=<ms>,jitter=<ms>
+              latency=<ms>,jitter=<ms>,distribution=<uniform|normal|pareto|
+              pareto_normal|log_normal|empirical>,correlation=<float>,
+              table=<float:float:...>
 
   bandwidth:  limit to max kb/s
-              rate=<KB/s>
+              rate=<KB/s>,burst=<KB>,shared=<link|stream|proxy>,
+              group=<name>
 
   slow_close: delay from closing
               delay=<ms>
@@ -52,10 +56,72 @@ var toxicDescription = `
   slicer:     slice data into bits with optional delay
               average_size=<bytes>,size_variation=<bytes>,delay=<microseconds>
 
+  packet_loss: drop packets with a probability, optionally in bursts
+              probability=<float>,good_to_bad=<float>,bad_to_good=<float>,
+              bad_probability=<float>
+
+  duplicate:  send a copy of packets with a probability, optionally delayed
+              probability=<float>,delay=<ms>
+
+  reorder:    hold packets back for a number of packets or a delay
+              probability=<float>,gap=<packets>,delay=<ms>
+
+  corrupt:    flip bits or overwrite bytes with a probability
+              probability=<float>,per=<byte|chunk>,mode=<flip|overwrite>,
+              seed=<int>,offsets=<stream|chunk>
+
+  rewrite:    replace a literal or regular expression in the data
+              search=<string>,replace=<string>,regex=<bool>,max_lookahead=<bytes>,
+              lookahead_timeout=<ms>
+
+  http:       replace HTTP responses with errors or delay them, for matching requests
+              method=<method>,path=<prefix>,header=<header>,probability=<float>,
+              status=<code>,body=<string>,latency=<ms>
+
+  refuse:     close new clients without dialing the upstream
+              reset=<bool>
+
+  accept_delay: delay accepting new clients
+              delay=<ms>
+
+  dial_delay: delay dialing the upstream, failing after a timeout
+              delay=<ms>,timeout=<ms>
+
+  max_connections: turn new clients away over a number of connections
+              limit=<int>,reset=<bool>
+
+  connection_rate: limit the rate of new connections
+              rate=<float>,burst=<int>,reset=<bool>
+
+  dns_nxdomain: fail resolving the upstream hostname, as if it did not exist
+              host=<hostname>
+
+  dns_delay:  delay resolving the upstream hostname, failing after a timeout
+              delay=<ms>,timeout=<ms>,host=<hostname>
+
+  dns_redirect: resolve the upstream hostname to another address
+              address=<ip>,host=<hostname>
+
+  tls_certificate: present TLS clients with an expired, self-signed or wrong-host certificate
+              host=<hostname>,expired=<bool>,self_signed=<bool>
+
+  tls_abort:  abort the TLS handshake after the ClientHello
+              reset=<bool>
+
+  tls_delay:  delay the TLS handshake
+              delay=<ms>
+
+  tls_version: restrict the TLS versions accepted by the proxy
+              min_version=<1.0-1.3>,max_version=<1.0-1.3>
+
   toxic add:
     usage: toxiproxy-cli toxic add --type <toxicType> [--downstream|--upstream] \
-            --toxicName <toxicName> [--toxicity <float>] \
-            --attribute <key=value> [--attribute <key2=value2>] <proxyName>
+            --toxicName <toxicName> [--toxicity <float>] [--toxicityMode <connection|chunk>] \
+            --attribute <key=value> [--attribute <key2=value2>] \
+            [--start <ms>] [--duration <ms>] [--period <ms>] [--periodJitter <ms>] \
+            [--matchClient <cidr>] [--matchPorts <from-to>] [--matchPrefix <string>] \
+            [--matchRegex <regex>] [--matchBytes <int>] [--matchDestination <host>] \
+            [--matchDestinationPorts <from-to>] <proxyName>
 
 
     example: toxiproxy-cli toxic add -t latency -n myToxic -a latency=100 -a jitter=50 myProxy
@@ -72,6 +138,35 @@ var toxicDescription = `
     example: toxiproxy-cli toxic delete -n myToxic myProxy
 `
 
+var connectionsDescription = `
+  connections list:
+    usage: toxiproxy-cli connections list <proxyName>
+
+    example: toxiproxy-cli connections list myProxy
+
+  connections close:
+    usage: toxiproxy-cli connections close [--reset] <proxyName> <client>
+
+    example: toxiproxy-cli connections close --reset myProxy 127.0.0.1:53412
+`
+
+var upstreamsDescription = `
+  upstreams list:
+    usage: toxiproxy-cli upstreams list <proxyName>
+
+    example: toxiproxy-cli upstreams list myProxy
+
+  upstreams down:
+    usage: toxiproxy-cli upstreams down <proxyName> <upstream>
+
+    example: toxiproxy-cli upstreams down myProxy localhost:6380
+
+  upstreams up:
+    usage: toxiproxy-cli upstreams up <proxyName> <upstream>
+
+    example: toxiproxy-cli upstreams up myProxy localhost:6380
+`
+
 var (
 	hostname string
 	isTTY    bool
@@ -107,9 +202,16 @@ func cliCommands() []*cli.Command {
 	return []*cli.Command{
 		{
 			Name:    "list",
-			Usage:   "list all proxies\n\tusage: 'toxiproxy-cli list'\n",
+			Usage:   "list all proxies\n\tusage: 'toxiproxy-cli list [--selector <selector>]'\n",
 			Aliases: []string{"l", "li", "ls"},
-			Action:  withToxi(list),
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "selector",
+					Aliases: []string{"s"},
+					Usage:   "only list the proxies with these labels, like team=payments,env!=prod",
+				},
+			},
+			Action: withToxi(list),
 		},
 		{
 			Name:    "inspect",
@@ -120,18 +222,76 @@ func cliCommands() []*cli.Command {
 		{
 			Name: "create",
 			Usage: "create a new proxy\n\t" +
-				"usage: 'toxiproxy-cli create --listen <addr> --upstream <addr> <proxyName>'\n",
+				"usage: 'toxiproxy-cli create --listen <addr> --upstream <addr>[,<addr>...] " +
+				"[--balance <round_robin|random|failover>] [--protocol <tcp|udp>] " +
+				"[--mode <socks5|http_connect>] " +
+				"[--resolverTtl <ms>] [--resolverHost <host=ip>] " +
+				"[--tls] [--tlsCert <file> --tlsKey <file>] [--upstreamTls] " +
+				"[--upstreamServerName <name>] [--upstreamInsecure] " +
+				"[--label <key=value>] <proxyName>'\n",
 			Aliases: []string{"c", "new"},
 			Flags: []cli.Flag{
 				&cli.StringFlag{
 					Name:    "listen",
 					Aliases: []string{"l"},
-					Usage:   "proxy will listen on this address",
+					Usage:   "proxy will listen on this address, or on a range of ports as host:from-to",
 				},
 				&cli.StringFlag{
 					Name:    "upstream",
 					Aliases: []string{"u"},
-					Usage:   "proxy will forward to this address",
+					Usage:   "proxy will forward to this address, or to comma-separated addresses",
+				},
+				&cli.StringFlag{
+					Name:    "balance",
+					Aliases: []string{"b"},
+					Usage:   "how upstreams are chosen: round_robin, random or failover",
+				},
+				&cli.StringFlag{
+					Name:    "protocol",
+					Aliases: []string{"p"},
+					Usage:   "protocol to proxy, tcp or udp",
+					Value:   "tcp",
+				},
+				&cli.StringFlag{
+					Name:    "mode",
+					Aliases: []string{"m"},
+					Usage:   "forward proxy mode without upstream, socks5 or http_connect",
+				},
+				&cli.Int64Flag{
+					Name:  "resolverTtl",
+					Usage: "milliseconds resolved upstream hostnames are cached for",
+				},
+				&cli.StringSliceFlag{
+					Name:  "resolverHost",
+					Usage: "IP address of an upstream hostname in host=ip format",
+				},
+				&cli.BoolFlag{
+					Name:  "tls",
+					Usage: "terminate TLS with a certificate signed by the server CA",
+				},
+				&cli.StringFlag{
+					Name:  "tlsCert",
+					Usage: "terminate TLS with the PEM certificate of this file",
+				},
+				&cli.StringFlag{
+					Name:  "tlsKey",
+					Usage: "PEM key file of the TLS certificate",
+				},
+				&cli.BoolFlag{
+					Name:  "upstreamTls",
+					Usage: "connect to the upstreams with TLS",
+				},
+				&cli.StringFlag{
+					Name:  "upstreamServerName",
+					Usage: "server name of the upstreams, defaults to their host",
+				},
+				&cli.BoolFlag{
+					Name:  "upstreamInsecure",
+					Usage: "skip the verification of the upstream certificates",
+				},
+				&cli.StringSliceFlag{
+					Name:  "label",
+					Usage: "label of the proxy in key=value format",
 				},
 			},
 			Action: withToxi(createProxy),
@@ -143,6 +303,46 @@ func cliCommands() []*cli.Command {
 			Aliases: []string{"tog"},
 			Action:  withToxi(toggleProxy),
 		},
+		{
+			Name: "enable",
+			Usage: "\tenable all the proxies with the labels of a selector\n" +
+				"\t\tusage: 'toxiproxy-cli enable --selector <selector>'\n",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "selector",
+					Aliases: []string{"s"},
+					Usage:   "labels of the proxies, like team=payments,env!=prod",
+				},
+			},
+			Action: withToxi(setEnabled(true)),
+		},
+		{
+			Name: "disable",
+			Usage: "\tdisable all the proxies with the labels of a selector\n" +
+				"\t\tusage: 'toxiproxy-cli disable --selector <selector>'\n",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "selector",
+					Aliases: []string{"s"},
+					Usage:   "labels of the proxies, like team=payments,env!=prod",
+				},
+			},
+			Action: withToxi(setEnabled(false)),
+		},
+		{
+			Name: "drain",
+			Usage: "\tstop accepting connections on a proxy, closing open ones after a timeout\n" +
+				"\t\tusage: 'toxiproxy-cli drain [--timeout <ms>] <proxyName>'\n",
+			Flags: []cli.Flag{
+				&cli.Int64Flag{
+					Name:    "timeout",
+					Aliases: []string{"t"},
+					Usage:   "milliseconds open connections have to finish",
+					Value:   toxiproxyServer.DefaultDrainTimeout.Milliseconds(),
+				},
+			},
+			Action: withToxi(drainProxy),
+		},
 		{
 			Name:    "delete",
 			Usage:   "\tdelete a proxy\n\t\tusage: 'toxiproxy-cli delete <proxyName>'\n",
@@ -156,6 +356,71 @@ func cliCommands() []*cli.Command {
 			Description: toxicDescription,
 			Subcommands: cliToxiSubCommands(),
 		},
+		{
+			Name:    "connections",
+			Aliases: []string{"conn"},
+			Usage: "\tlist or close the connections of a proxy\n" +
+				"\t\tusage: see 'toxiproxy-cli connections'\n",
+			Description: connectionsDescription,
+			Subcommands: cliConnectionsSubCommands(),
+		},
+		{
+			Name: "upstreams",
+			Usage: "\tlist the upstreams of a proxy, or mark them down\n" +
+				"\t\tusage: see 'toxiproxy-cli upstreams'\n",
+			Description: upstreamsDescription,
+			Subcommands: cliUpstreamsSubCommands(),
+		},
+	}
+}
+
+func cliUpstreamsSubCommands() []*cli.Command {
+	return []*cli.Command{
+		{
+			Name:      "list",
+			Aliases:   []string{"l", "ls"},
+			Usage:     "list the upstreams of a proxy",
+			ArgsUsage: "<proxyName>",
+			Action:    withToxi(listUpstreams),
+		},
+		{
+			Name:      "down",
+			Usage:     "mark an upstream down, new clients connect to the others",
+			ArgsUsage: "<proxyName> <upstream>",
+			Action:    withToxi(setUpstreamDown(true)),
+		},
+		{
+			Name:      "up",
+			Usage:     "mark an upstream up again",
+			ArgsUsage: "<proxyName> <upstream>",
+			Action:    withToxi(setUpstreamDown(false)),
+		},
+	}
+}
+
+func cliConnectionsSubCommands() []*cli.Command {
+	return []*cli.Command{
+		{
+			Name:      "list",
+			Aliases:   []string{"l", "ls"},
+			Usage:     "list the open connections of a proxy",
+			ArgsUsage: "<proxyName>",
+			Action:    withToxi(listConnections),
+		},
+		{
+			Name:      "close",
+			Aliases:   []string{"c", "kill"},
+			Usage:     "close a connection of a proxy",
+			ArgsUsage: "<proxyName> <client>",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "reset",
+					Aliases: []string{"r"},
+					Usage:   "reset the connection instead of closing it gracefully",
+				},
+			},
+			Action: withToxi(closeConnection),
+		},
 	}
 }
 
@@ -174,6 +439,10 @@ func cliToxiAddSubCommand() *cli.Command {
 		Usage:     "add a new toxic",
 		ArgsUsage: "<proxyName>",
 		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "selector",
+				Usage: "add the toxic to all the proxies with these labels instead of one",
+			},
 			&cli.StringFlag{
 				Name:    "toxicName",
 				Aliases: []string{"n"},
@@ -190,6 +459,10 @@ func cliToxiAddSubCommand() *cli.Command {
 				Usage:       "toxicity of toxic should be a float between 0 and 1",
 				DefaultText: "1.0",
 			},
+			&cli.StringFlag{
+				Name:  "toxicityMode",
+				Usage: "roll the toxicity once per connection or per chunk, connection or chunk",
+			},
 			&cli.StringSliceFlag{
 				Name:    "attribute",
 				Aliases: []string{"a"},
@@ -207,6 +480,50 @@ func cliToxiAddSubCommand() *cli.Command {
 				Usage:       "add toxic to downstream",
 				DefaultText: "true",
 			},
+			&cli.Int64Flag{
+				Name:  "start",
+				Usage: "activate the toxic after this many milliseconds",
+			},
+			&cli.Int64Flag{
+				Name:  "duration",
+				Usage: "deactivate the toxic after this many milliseconds",
+			},
+			&cli.Int64Flag{
+				Name:  "period",
+				Usage: "activate the toxic again every this many milliseconds",
+			},
+			&cli.Int64Flag{
+				Name:  "periodJitter",
+				Usage: "vary every period randomly by up to this many milliseconds",
+			},
+			&cli.StringFlag{
+				Name:  "matchClient",
+				Usage: "only affect clients with this address or in this CIDR network",
+			},
+			&cli.StringFlag{
+				Name:  "matchPorts",
+				Usage: "only affect clients with this port or in this from-to port range",
+			},
+			&cli.StringFlag{
+				Name:  "matchDestination",
+				Usage: "only affect clients of forward proxies requesting this host, or *.domain",
+			},
+			&cli.StringFlag{
+				Name:  "matchDestinationPorts",
+				Usage: "only affect clients of forward proxies requesting this port or port range",
+			},
+			&cli.StringFlag{
+				Name:  "matchPrefix",
+				Usage: "only affect connections where the client data starts with this prefix",
+			},
+			&cli.StringFlag{
+				Name:  "matchRegex",
+				Usage: "only affect connections where the client data matches this regex",
+			},
+			&cli.IntFlag{
+				Name:  "matchBytes",
+				Usage: "number of bytes of client data matched against the regex",
+			},
 		},
 		Action: withToxi(addToxic),
 	}
@@ -219,6 +536,10 @@ func cliToxiUpdateSubCommand() *cli.Command {
 		Usage:     "update an enabled toxic",
 		ArgsUsage: "<proxyName>",
 		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "selector",
+				Usage: "update the toxic on all the proxies with these labels instead of one",
+			},
 			&cli.StringFlag{
 				Name:    "toxicName",
 				Aliases: []string{"n"},
@@ -247,6 +568,10 @@ func cliToxiRemoveSubCommand() *cli.Command {
 		Usage:     "remove an enabled toxic",
 		ArgsUsage: "<proxyName>",
 		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "selector",
+				Usage: "remove the toxic from all the proxies with these labels instead of one",
+			},
 			&cli.StringFlag{
 				Name:    "toxicName",
 				Aliases: []string{"n"},
@@ -267,7 +592,7 @@ func withToxi(f toxiAction) func(*cli.Context) error {
 }
 
 func list(c *cli.Context, t *toxiproxy.Client) error {
-	proxies, err := t.Proxies()
+	proxies, err := t.SelectProxies(c.String("selector"))
 	if err != nil {
 		return errorf("Failed to retrieve proxies: %s", err)
 	}
@@ -331,7 +656,16 @@ func inspectProxy(c *cli.Context, t *toxiproxy.Client) error {
 	if isTTY {
 		fmt.Printf("%sName: %s%s\t", color(PURPLE), color(NONE), proxy.Name)
 		fmt.Printf("%sListen: %s%s\t", color(BLUE), color(NONE), proxy.Listen)
-		fmt.Printf("%sUpstream: %s%s\n", color(YELLOW), color(NONE), proxy.Upstream)
+		if len(proxy.Upstreams) > 1 {
+			fmt.Printf("%sUpstreams: %s%s (%s)\t", color(YELLOW), color(NONE),
+				strings.Join(proxy.Upstreams, ","), proxy.Balance)
+		} else {
+			fmt.Printf("%sUpstream: %s%s\t", color(YELLOW), color(NONE), proxy.Upstream)
+		}
+		fmt.Printf("%sProtocol: %s%s\n", color(GREEN), color(NONE), proxy.Protocol)
+		if len(proxy.Labels) > 0 {
+			fmt.Printf("%sLabels: %s%s\n", color(PURPLE), color(NONE), formatLabels(proxy.Labels))
+		}
 		fmt.Printf(
 			"%s======================================================================\n",
 			color(NONE),
@@ -407,18 +741,148 @@ func createProxy(c *cli.Context, t *toxiproxy.Client) error {
 	if err != nil {
 		return err
 	}
-	upstream, err := getArgOrFail(c, "upstream")
+	proxy := t.NewProxy()
+	proxy.Mode = c.String("mode")
+	if proxy.Mode == "" {
+		upstream, err := getArgOrFail(c, "upstream")
+		if err != nil {
+			return err
+		}
+		proxy.Upstreams = strings.Split(upstream, ",")
+	}
+	proxy.Name = proxyName
+	proxy.Listen = listen
+	proxy.Balance = c.String("balance")
+	proxy.Protocol = c.String("protocol")
+	proxy.Resolver, err = parseResolver(c)
 	if err != nil {
 		return err
 	}
-	_, err = t.CreateProxy(proxyName, listen, upstream)
+	proxy.TLS, proxy.UpstreamTLS = parseTLS(c)
+	proxy.Labels, err = parseLabels(c)
+	if err != nil {
+		return err
+	}
+	proxy.Enabled = true
+	err = proxy.Save()
 	if err != nil {
 		return errorf("Failed to create proxy: %s\n", err.Error())
 	}
+	if proxy.Listen != listen {
+		// The port was picked by the server.
+		fmt.Printf("Created new proxy %s listening on %s\n", proxyName, proxy.Listen)
+		return nil
+	}
 	fmt.Printf("Created new proxy %s\n", proxyName)
 	return nil
 }
 
+// parseResolver parses the resolver flags of a new proxy, it returns nil when none
+// is set.
+func parseResolver(c *cli.Context) (*toxiproxy.Resolver, error) {
+	if !c.IsSet("resolverTtl") && !c.IsSet("resolverHost") {
+		return nil, nil
+	}
+
+	resolver := &toxiproxy.Resolver{TTL: c.Int64("resolverTtl")}
+	for _, raw := range c.StringSlice("resolverHost") {
+		kv := strings.SplitN(raw, "=", 2)
+		if len(kv) < 2 {
+			return nil, errorf("resolverHost should be in host=ip format, got %s.\n", raw)
+		}
+		if resolver.Hosts == nil {
+			resolver.Hosts = make(map[string]string)
+		}
+		resolver.Hosts[kv[0]] = kv[1]
+	}
+	return resolver, nil
+}
+
+// parseLabels parses the label flags of a new proxy.
+func parseLabels(c *cli.Context) (map[string]string, error) {
+	var labels map[string]string
+	for _, raw := range c.StringSlice("label") {
+		kv := strings.SplitN(raw, "=", 2)
+		if len(kv) < 2 {
+			return nil, errorf("label should be in key=value format, got %s.\n", raw)
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[kv[0]] = kv[1]
+	}
+	return labels, nil
+}
+
+// formatLabels lists the labels in key=value format, ordered by key.
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// parseTLS parses the TLS flags of a new proxy, it returns nil for the sides of the
+// proxy without TLS.
+func parseTLS(c *cli.Context) (*toxiproxy.TLS, *toxiproxy.UpstreamTLS) {
+	var listener *toxiproxy.TLS
+	if c.Bool("tls") || c.IsSet("tlsCert") || c.IsSet("tlsKey") {
+		listener = &toxiproxy.TLS{Cert: c.String("tlsCert"), Key: c.String("tlsKey")}
+	}
+
+	var upstream *toxiproxy.UpstreamTLS
+	if c.Bool("upstreamTls") || c.IsSet("upstreamServerName") || c.Bool("upstreamInsecure") {
+		upstream = &toxiproxy.UpstreamTLS{
+			ServerName:         c.String("upstreamServerName"),
+			InsecureSkipVerify: c.Bool("upstreamInsecure"),
+		}
+	}
+	return listener, upstream
+}
+
+func setEnabled(enabled bool) func(*cli.Context, *toxiproxy.Client) error {
+	return func(c *cli.Context, t *toxiproxy.Client) error {
+		selector, err := getArgOrFail(c, "selector")
+		if err != nil {
+			return err
+		}
+
+		bulk, verb := t.BulkEnable, "Enabled"
+		if !enabled {
+			bulk, verb = t.BulkDisable, "Disabled"
+		}
+		proxies, err := bulk(selector)
+		if err != nil {
+			return errorf("Failed to %s proxies: %s\n", c.Command.Name, err.Error())
+		}
+
+		fmt.Printf("%s%s%s %s\n", colorEnabled(enabled), verb, color(NONE), describeProxies(proxies))
+		return nil
+	}
+}
+
+func drainProxy(c *cli.Context, t *toxiproxy.Client) error {
+	proxyName := c.Args().First()
+	if proxyName == "" {
+		cli.ShowSubcommandHelp(c)
+		return errorf("Proxy name is required as the first argument.\n")
+	}
+
+	proxy, err := t.Proxy(proxyName)
+	if err != nil {
+		return errorf("Failed to retrieve proxy %s: %s\n", proxyName, err.Error())
+	}
+
+	err = proxy.Drain(time.Duration(c.Int64("timeout")) * time.Millisecond)
+	if err != nil {
+		return errorf("Failed to drain proxy %s: %s\n", proxyName, err.Error())
+	}
+	fmt.Printf("Draining proxy %s\n", proxyName)
+	return nil
+}
+
 func deleteProxy(c *cli.Context, t *toxiproxy.Client) error {
 	proxyName := c.Args().First()
 	if proxyName == "" {
@@ -438,6 +902,140 @@ func deleteProxy(c *cli.Context, t *toxiproxy.Client) error {
 	return nil
 }
 
+func listConnections(c *cli.Context, t *toxiproxy.Client) error {
+	proxyName := c.Args().First()
+	if proxyName == "" {
+		cli.ShowSubcommandHelp(c)
+		return errorf("Proxy name is required as the first argument.\n")
+	}
+
+	proxy, err := t.Proxy(proxyName)
+	if err != nil {
+		return errorf("Failed to retrieve proxy %s: %s\n", proxyName, err.Error())
+	}
+
+	connections, err := proxy.Connections()
+	if err != nil {
+		return errorf("Failed to retrieve connections: %s\n", err.Error())
+	}
+
+	if isTTY {
+		fmt.Printf(
+			"%sClient\t\t\t%sStarted\t\t\t%sUpstream\t%sDownstream\t%sToxics\n%s",
+			color(GREEN),
+			color(BLUE),
+			color(YELLOW),
+			color(PURPLE),
+			color(RED),
+			color(NONE),
+		)
+		fmt.Printf(
+			"%s======================================================================================\n",
+			color(NONE),
+		)
+
+		if len(connections) == 0 {
+			fmt.Printf("%sno connections\n%s", color(RED), color(NONE))
+			return nil
+		}
+	}
+
+	for _, connection := range connections {
+		printWidth(GREEN, connection.Client, 3)
+		printWidth(BLUE, connection.Started.Format(time.RFC3339), 3)
+		printWidth(YELLOW, strconv.FormatInt(connection.UpstreamBytes, 10), 1)
+		printWidth(PURPLE, strconv.FormatInt(connection.DownstreamBytes, 10), 1)
+		fmt.Printf("%s%s%s\n", color(RED), strings.Join(connection.Toxics, ","), color(NONE))
+	}
+	hint("close a connection with `toxiproxy-cli connections close <proxyName> <client>`")
+	return nil
+}
+
+func closeConnection(c *cli.Context, t *toxiproxy.Client) error {
+	proxyName := c.Args().Get(0)
+	client := c.Args().Get(1)
+	if proxyName == "" || client == "" {
+		cli.ShowSubcommandHelp(c)
+		return errorf("Proxy name and client address are required as arguments.\n")
+	}
+
+	proxy, err := t.Proxy(proxyName)
+	if err != nil {
+		return errorf("Failed to retrieve proxy %s: %s\n", proxyName, err.Error())
+	}
+
+	err = proxy.CloseConnection(client, c.Bool("reset"))
+	if err != nil {
+		return errorf("Failed to close connection: %s\n", err.Error())
+	}
+	fmt.Printf("Closed connection %s on proxy %s\n", client, proxyName)
+	return nil
+}
+
+func listUpstreams(c *cli.Context, t *toxiproxy.Client) error {
+	proxyName := c.Args().First()
+	if proxyName == "" {
+		cli.ShowSubcommandHelp(c)
+		return errorf("Proxy name is required as the first argument.\n")
+	}
+
+	proxy, err := t.Proxy(proxyName)
+	if err != nil {
+		return errorf("Failed to retrieve proxy %s: %s\n", proxyName, err.Error())
+	}
+
+	upstreams, err := proxy.UpstreamStates()
+	if err != nil {
+		return errorf("Failed to retrieve upstreams: %s\n", err.Error())
+	}
+
+	if isTTY {
+		fmt.Printf("%sUpstream\t\t\t%sStatus\n%s", color(YELLOW), color(GREEN), color(NONE))
+		fmt.Printf(
+			"%s======================================================================\n",
+			color(NONE),
+		)
+	}
+
+	for _, upstream := range upstreams {
+		printWidth(YELLOW, upstream.Address, 3)
+		if upstream.Down {
+			fmt.Printf("%sdown%s\n", color(RED), color(NONE))
+		} else {
+			fmt.Printf("%sup%s\n", color(GREEN), color(NONE))
+		}
+	}
+	hint("mark an upstream down with `toxiproxy-cli upstreams down <proxyName> <upstream>`")
+	return nil
+}
+
+func setUpstreamDown(down bool) func(*cli.Context, *toxiproxy.Client) error {
+	return func(c *cli.Context, t *toxiproxy.Client) error {
+		proxyName := c.Args().Get(0)
+		upstream := c.Args().Get(1)
+		if proxyName == "" || upstream == "" {
+			cli.ShowSubcommandHelp(c)
+			return errorf("Proxy name and upstream address are required as arguments.\n")
+		}
+
+		proxy, err := t.Proxy(proxyName)
+		if err != nil {
+			return errorf("Failed to retrieve proxy %s: %s\n", proxyName, err.Error())
+		}
+
+		err = proxy.SetUpstreamDown(upstream, down)
+		if err != nil {
+			return errorf("Failed to mark upstream: %s\n", err.Error())
+		}
+		status := "up"
+		if down {
+			status = "down"
+		}
+		fmt.Printf("Marked upstream %s %s on proxy %s\n", upstream, status, proxyName)
+		return nil
+	}
+}
+
 func parseToxicity(c *cli.Context, defaultToxicity float32) (float32, error) {
 	toxicity := defaultToxicity
 	toxicityString := c.String("toxicity")
@@ -457,6 +1055,29 @@ func addToxic(c *cli.Context, t *toxiproxy.Client) error {
 		return err
 	}
 
+	if selector := c.String("selector"); selector != "" {
+		proxies, err := t.BulkCreateToxic(selector, &toxiproxy.Toxic{
+			Name:         toxicParams.ToxicName,
+			Type:         toxicParams.ToxicType,
+			Stream:       toxicParams.Stream,
+			Toxicity:     toxicParams.Toxicity,
+			Attributes:   toxicParams.Attributes,
+			Schedule:     toxicParams.Schedule,
+			ToxicityMode: toxicParams.ToxicityMode,
+			Match:        toxicParams.Match,
+		})
+		if err != nil {
+			return errorf("Failed to add toxic: %v\n", err)
+		}
+		fmt.Printf(
+			"Added %s %s toxic on %s\n",
+			toxicParams.Stream,
+			toxicParams.ToxicType,
+			describeProxies(proxies),
+		)
+		return nil
+	}
+
 	toxic, err := t.AddToxic(toxicParams)
 	if err != nil {
 		return errorf("Failed to add toxic: %v\n", err)
@@ -479,6 +1100,20 @@ func updateToxic(c *cli.Context, t *toxiproxy.Client) error {
 		return err
 	}
 
+	if selector := c.String("selector"); selector != "" {
+		proxies, err := t.BulkUpdateToxic(
+			selector,
+			toxicParams.ToxicName,
+			toxicParams.Toxicity,
+			toxicParams.Attributes,
+		)
+		if err != nil {
+			return errorf("Failed to update toxic: %v\n", err)
+		}
+		fmt.Printf("Updated toxic '%s' on %s\n", toxicParams.ToxicName, describeProxies(proxies))
+		return nil
+	}
+
 	toxic, err := t.UpdateToxic(toxicParams)
 	if err != nil {
 		return errorf("Failed to update toxic: %v\n", err)
@@ -498,6 +1133,15 @@ func removeToxic(c *cli.Context, t *toxiproxy.Client) error {
 		return err
 	}
 
+	if selector := c.String("selector"); selector != "" {
+		proxies, err := t.BulkRemoveToxic(selector, toxicParams.ToxicName)
+		if err != nil {
+			return errorf("Failed to remove toxic: %v\n", err)
+		}
+		fmt.Printf("Removed toxic '%s' on %s\n", toxicParams.ToxicName, describeProxies(proxies))
+		return nil
+	}
+
 	err = t.RemoveToxic(toxicParams)
 	if err != nil {
 		return errorf("Failed to remove toxic: %v\n", err)
@@ -509,10 +1153,14 @@ func removeToxic(c *cli.Context, t *toxiproxy.Client) error {
 
 func parseToxicCommonParams(context *cli.Context) (*toxiproxy.ToxicOptions, error) {
 	proxyName := context.Args().First()
-	if proxyName == "" {
+	selector := context.String("selector")
+	if proxyName == "" && selector == "" {
 		cli.ShowSubcommandHelp(context)
 		return nil, errorf("Proxy name is missing.\n")
 	}
+	if proxyName != "" && selector != "" {
+		return nil, errorf("Only one should be specified: proxy name or selector.\n")
+	}
 
 	toxicName := context.String("toxicName")
 
@@ -567,10 +1215,58 @@ func parseAddToxicParams(c *cli.Context) (*toxiproxy.ToxicOptions, error) {
 	}
 
 	result.Attributes = parseAttributes(c, "attribute")
+	result.ToxicityMode = c.String("toxicityMode")
+
+	if c.IsSet("start") || c.IsSet("duration") || c.IsSet("period") {
+		result.Schedule = &toxiproxy.Schedule{
+			Start:    c.Int64("start"),
+			Duration: c.Int64("duration"),
+			Period:   c.Int64("period"),
+			Jitter:   c.Int64("periodJitter"),
+		}
+	}
+
+	if c.IsSet("matchClient") || c.IsSet("matchPorts") ||
+		c.IsSet("matchPrefix") || c.IsSet("matchRegex") ||
+		c.IsSet("matchDestination") || c.IsSet("matchDestinationPorts") {
+		result.Match = &toxiproxy.Match{
+			Client:           c.String("matchClient"),
+			Ports:            c.String("matchPorts"),
+			Prefix:           c.String("matchPrefix"),
+			Regex:            c.String("matchRegex"),
+			Bytes:            c.Int("matchBytes"),
+			Destination:      c.String("matchDestination"),
+			DestinationPorts: c.String("matchDestinationPorts"),
+		}
+	}
 
 	return result, nil
 }
 
+// formatMatch lists the criteria set on a match.
+func formatMatch(match *toxiproxy.Match) string {
+	var criteria []string
+	if match.Client != "" {
+		criteria = append(criteria, "client="+match.Client)
+	}
+	if match.Ports != "" {
+		criteria = append(criteria, "ports="+match.Ports)
+	}
+	if match.Destination != "" {
+		criteria = append(criteria, "destination="+match.Destination)
+	}
+	if match.DestinationPorts != "" {
+		criteria = append(criteria, "destination_ports="+match.DestinationPorts)
+	}
+	if match.Prefix != "" {
+		criteria = append(criteria, "prefix="+strconv.Quote(match.Prefix))
+	}
+	if match.Regex != "" {
+		criteria = append(criteria, "regex="+strconv.Quote(match.Regex))
+	}
+	return strings.Join(criteria, ",")
+}
+
 func parseAttributes(c *cli.Context, name string) toxiproxy.Attributes {
 	parsed := map[string]interface{}{}
 	args := c.StringSlice(name)
@@ -582,6 +1278,10 @@ func parseAttributes(c *cli.Context, name string) toxiproxy.Attributes {
 		}
 		if float, err := strconv.ParseFloat(kv[1], 64); err == nil {
 			parsed[kv[0]] = float
+		} else if boolean, err := strconv.ParseBool(kv[1]); err == nil {
+			parsed[kv[0]] = boolean
+		} else if list, ok := parseFloats(kv[1]); ok {
+			parsed[kv[0]] = list
 		} else {
 			parsed[kv[0]] = kv[1]
 		}
@@ -589,6 +1289,37 @@ func parseAttributes(c *cli.Context, name string) toxiproxy.Attributes {
 	return parsed
 }
 
+// parseFloats parses a list of numbers separated by colons, as commas separate
+// attributes.
+func parseFloats(value string) ([]float64, bool) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 {
+		return nil, false
+	}
+	list := make([]float64, len(parts))
+	for i, part := range parts {
+		float, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return nil, false
+		}
+		list[i] = float
+	}
+	return list, true
+}
+
+// describeProxies lists the names of the proxies a bulk operation applied to.
+func describeProxies(proxies map[string]*toxiproxy.Proxy) string {
+	if len(proxies) == 0 {
+		return "no proxies"
+	}
+	names := make([]string, 0, len(proxies))
+	for name := range proxies {
+		names = append(names, "'"+name+"'")
+	}
+	sort.Strings(names)
+	return "proxies " + strings.Join(names, ", ")
+}
+
 func colorEnabled(enabled bool) string {
 	if enabled {
 		return color(GREEN)
@@ -619,7 +1350,7 @@ func (a attributeList) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func sortedAttributes(attrs toxiproxy.Attributes) attributeList {
 	li := make(attributeList, 0, len(attrs))
 	for k, v := range attrs {
-		li = append(li, attribute{k, v.(float64)})
+		li = append(li, attribute{k, v})
 	}
 	sort.Sort(li)
 	return li
@@ -642,6 +1373,15 @@ func listToxics(toxics toxiproxy.Toxics, stream string) {
 		fmt.Printf("type=%s\t", t.Type)
 		fmt.Printf("stream=%s\t", t.Stream)
 		fmt.Printf("toxicity=%.2f\t", t.Toxicity)
+		if t.ToxicityMode != "" {
+			fmt.Printf("toxicity_mode=%s\t", t.ToxicityMode)
+		}
+		if t.Schedule != nil {
+			fmt.Printf("schedule=%s\t", t.Schedule.Phase)
+		}
+		if t.Match != nil {
+			fmt.Printf("match=%s\t", formatMatch(t.Match))
+		}
 		fmt.Printf("attributes=[")
 		sorted := sortedAttributes(t.Attributes)
 		for _, a := range sorted {
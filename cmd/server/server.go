@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"math/rand"
@@ -26,6 +27,10 @@ type cliArguments struct {
 	printVersion   bool
 	proxyMetrics   bool
 	runtimeMetrics bool
+	drainTimeout   time.Duration
+	exitTimeout    time.Duration
+	caCert         string
+	caKey          string
 }
 
 func parseArguments() cliArguments {
@@ -44,20 +49,20 @@ func parseArguments() cliArguments {
 		`enable toxiproxy-specific prometheus metrics (default "false")`)
 	flag.BoolVar(&result.printVersion, "version", false,
 		`print the version (default "false")`)
+	flag.DurationVar(&result.drainTimeout, "drain-timeout", 0,
+		"Time open connections have to finish on shutdown before being closed")
+	flag.DurationVar(&result.exitTimeout, "exit-timeout", 10*time.Second,
+		"Time the shutdown can take besides draining, before exiting anyway")
+	flag.StringVar(&result.caCert, "ca-cert", "",
+		"PEM certificate of the CA signing the certificates of TLS proxies")
+	flag.StringVar(&result.caKey, "ca-key", "",
+		"PEM key of the CA signing the certificates of TLS proxies")
 	flag.Parse()
 
 	return result
 }
 
 func main() {
-	// Handle SIGTERM to exit cleanly
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGTERM)
-	go func() {
-		<-signals
-		os.Exit(0)
-	}()
-
 	cli := parseArguments()
 	run(cli)
 }
@@ -73,6 +78,12 @@ func run(cli cliArguments) {
 
 	rand.Seed(cli.seed)
 
+	if len(cli.caCert) > 0 {
+		if err := toxiproxy.LoadCA(cli.caCert, cli.caKey); err != nil {
+			logger.Fatal().Err(err).Str("ca_cert", cli.caCert).Msg("Failed to load CA")
+		}
+	}
+
 	metrics := toxiproxy.NewMetricsContainer(prometheus.NewRegistry())
 	server := toxiproxy.NewServer(metrics, logger)
 	if cli.proxyMetrics {
@@ -81,11 +92,55 @@ func run(cli cliArguments) {
 	if cli.runtimeMetrics {
 		server.Metrics.RuntimeMetrics = collectors.NewRuntimeMetricCollectors()
 	}
+
+	// Handle SIGINT and SIGTERM to shut down the server and its proxies cleanly
+	shutdown := make(chan error, 1)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		logger.Info().
+			Str("signal", sig.String()).
+			Dur("drain_timeout", cli.drainTimeout).
+			Dur("exit_timeout", cli.exitTimeout).
+			Msg("Shutting down")
+
+		// Exit right away on a second signal, or if the shutdown outlasts the
+		// timeouts.
+		timeout := cli.drainTimeout + cli.exitTimeout
+		go func() {
+			select {
+			case sig := <-signals:
+				logger.Warn().Str("signal", sig.String()).Msg("Exiting without a clean shutdown")
+			case <-time.After(timeout):
+				logger.Warn().Dur("timeout", timeout).Msg("Shutdown timed out, exiting")
+			}
+			_ = os.Stdout.Sync()
+			os.Exit(1)
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		shutdown <- server.Shutdown(ctx, cli.drainTimeout)
+	}()
+
 	if len(cli.config) > 0 {
 		server.PopulateConfig(cli.config)
 	}
 
 	server.Listen(cli.host, cli.port)
+
+	err := <-shutdown
+	if err != nil {
+		logger.Err(err).Msg("Failed to shut down cleanly")
+	} else {
+		logger.Info().Msg("Shutdown complete")
+	}
+	// Flush the logs before exiting
+	_ = os.Stdout.Sync()
+	if err != nil {
+		os.Exit(1)
+	}
 }
 
 func setupLogger() zerolog.Logger {
@@ -5,11 +5,13 @@ import (
 )
 
 type ProxyMetricCollectors struct {
-	collectors  []prometheus.Collector
-	proxyLabels []string
+	collectors       []prometheus.Collector
+	proxyLabels      []string
+	udpSessionLabels []string
 
-	ReceivedBytesTotal *prometheus.CounterVec
-	SentBytesTotal     *prometheus.CounterVec
+	ReceivedBytesTotal       *prometheus.CounterVec
+	SentBytesTotal           *prometheus.CounterVec
+	UDPSessionEvictionsTotal *prometheus.CounterVec
 }
 
 func (c *ProxyMetricCollectors) Collectors() []prometheus.Collector {
@@ -42,5 +44,20 @@ func NewProxyMetricCollectors() *ProxyMetricCollectors {
 		m.proxyLabels)
 	m.collectors = append(m.collectors, m.SentBytesTotal)
 
+	m.udpSessionLabels = []string{
+		"reason",
+		"proxy",
+		"listener",
+		"upstream",
+	}
+	m.UDPSessionEvictionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "udp_session_evictions_total",
+		},
+		m.udpSessionLabels)
+	m.collectors = append(m.collectors, m.UDPSessionEvictionsTotal)
+
 	return &m
 }
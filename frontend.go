@@ -0,0 +1,240 @@
+package toxiproxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Modes of TCP proxies acting as forward proxies, which connect each client to
+// the destination it requests instead of to an upstream.
+const (
+	// ModeSOCKS5 reads the destination from a SOCKS5 CONNECT request.
+	ModeSOCKS5 = "socks5"
+	// ModeHTTPConnect reads the destination from an HTTP CONNECT request.
+	ModeHTTPConnect = "http_connect"
+)
+
+// Time a client of a forward proxy has to send its request.
+const frontendTimeout = 10 * time.Second
+
+var ErrUnsupportedRequest = errors.New("Unsupported forward proxy request")
+
+// errTurnedAway is the reason given to clients of forward proxies turned away by
+// the connection toxics.
+var errTurnedAway = errors.New("Client turned away by connection toxics")
+
+func parseMode(value string) (string, error) {
+	switch strings.ToLower(value) {
+	case "":
+		return "", nil
+	case ModeSOCKS5:
+		return ModeSOCKS5, nil
+	case ModeHTTPConnect:
+		return ModeHTTPConnect, nil
+	}
+	return "", ErrInvalidMode
+}
+
+// bufferedConn is a connection which was read from through a buffer, the data
+// left in the buffer is read first.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (conn *bufferedConn) Read(p []byte) (int, error) {
+	return conn.reader.Read(p)
+}
+
+func (conn *bufferedConn) unwrap() net.Conn {
+	return conn.Conn
+}
+
+// readDestination reads the request of a client of a forward proxy, and returns
+// the destination it requests. The connection returned replaces the client, it
+// keeps the data the client sent after its request.
+func readDestination(mode string, client net.Conn) (net.Conn, string, error) {
+	_ = client.SetDeadline(time.Now().Add(frontendTimeout))
+	defer func() { _ = client.SetDeadline(time.Time{}) }()
+
+	reader := bufio.NewReader(client)
+	var destination string
+	var err error
+	if mode == ModeSOCKS5 {
+		destination, err = readSOCKS5Request(client, reader)
+	} else {
+		destination, err = readConnectRequest(client, reader)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return &bufferedConn{Conn: client, reader: reader}, destination, nil
+}
+
+// replyDestination tells the client of a forward proxy whether its destination
+// could be reached, given the error connecting to it.
+func replyDestination(mode string, client net.Conn, err error) error {
+	if mode == ModeSOCKS5 {
+		return writeSOCKS5Reply(client, socks5ReplyCode(err))
+	}
+
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusBadGateway
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			status = http.StatusGatewayTimeout
+		}
+	}
+	_, err = fmt.Fprintf(client, "HTTP/1.1 %d %s\r\n\r\n", status, connectStatusText(status))
+	return err
+}
+
+func connectStatusText(status int) string {
+	if status == http.StatusOK {
+		return "Connection established"
+	}
+	return http.StatusText(status)
+}
+
+// readConnectRequest reads an HTTP CONNECT request, and answers other methods with
+// an error.
+func readConnectRequest(client net.Conn, reader *bufio.Reader) (string, error) {
+	request, err := http.ReadRequest(reader)
+	if err != nil {
+		return "", err
+	}
+	if request.Method != http.MethodConnect {
+		_, _ = io.WriteString(client, "HTTP/1.1 405 Method Not Allowed\r\nAllow: CONNECT\r\n\r\n")
+		return "", ErrUnsupportedRequest
+	}
+	if _, _, err := net.SplitHostPort(request.Host); err != nil {
+		_, _ = io.WriteString(client, "HTTP/1.1 400 Bad Request\r\n\r\n")
+		return "", err
+	}
+	return request.Host, nil
+}
+
+// SOCKS5 protocol values, see RFC 1928.
+const (
+	socks5Version    = 0x05
+	socks5NoAuth     = 0x00
+	socks5NoMethod   = 0xff
+	socks5Connect    = 0x01
+	socks5IPv4       = 0x01
+	socks5DomainName = 0x03
+	socks5IPv6       = 0x04
+
+	socks5Succeeded          = 0x00
+	socks5GeneralFailure     = 0x01
+	socks5HostUnreachable    = 0x04
+	socks5ConnectionRefused  = 0x05
+	socks5TTLExpired         = 0x06
+	socks5CommandUnsupported = 0x07
+	socks5AddressUnsupported = 0x08
+)
+
+// readSOCKS5Request negotiates a SOCKS5 session without authentication, and reads
+// its CONNECT request.
+func readSOCKS5Request(client net.Conn, reader *bufio.Reader) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return "", err
+	}
+	if header[0] != socks5Version {
+		return "", ErrUnsupportedRequest
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return "", err
+	}
+	method := byte(socks5NoMethod)
+	for _, offered := range methods {
+		if offered == socks5NoAuth {
+			method = socks5NoAuth
+		}
+	}
+	if _, err := client.Write([]byte{socks5Version, method}); err != nil {
+		return "", err
+	}
+	if method == socks5NoMethod {
+		return "", ErrUnsupportedRequest
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(reader, request); err != nil {
+		return "", err
+	}
+	if request[0] != socks5Version {
+		return "", ErrUnsupportedRequest
+	}
+
+	var host string
+	switch request[3] {
+	case socks5IPv4, socks5IPv6:
+		ip := make(net.IP, net.IPv4len)
+		if request[3] == socks5IPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(reader, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socks5DomainName:
+		length, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		name := make([]byte, length)
+		if _, err := io.ReadFull(reader, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		_ = writeSOCKS5Reply(client, socks5AddressUnsupported)
+		return "", ErrUnsupportedRequest
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(reader, port); err != nil {
+		return "", err
+	}
+	if request[1] != socks5Connect {
+		_ = writeSOCKS5Reply(client, socks5CommandUnsupported)
+		return "", ErrUnsupportedRequest
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// writeSOCKS5Reply answers the CONNECT request, without a bound address.
+func writeSOCKS5Reply(client net.Conn, code byte) error {
+	_, err := client.Write([]byte{socks5Version, code, 0, socks5IPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// socks5ReplyCode returns the reply code telling why the destination could not be
+// reached.
+func socks5ReplyCode(err error) byte {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return socks5Succeeded
+	case errors.Is(err, errTurnedAway), errors.Is(err, syscall.ECONNREFUSED):
+		return socks5ConnectionRefused
+	case errors.As(err, &dnsErr), errors.Is(err, syscall.EHOSTUNREACH):
+		return socks5HostUnreachable
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return socks5TTLExpired
+	}
+	return socks5GeneralFailure
+}
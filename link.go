@@ -2,6 +2,7 @@ package toxiproxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -21,14 +22,22 @@ import (
 // |         NoopToxic  LatencyToxic
 // |             v           v
 // | Input > ToxicStub > ToxicStub > Output.
+//
+// In datagram mode every read from the input is passed through the chain as a
+// single StreamChunk, and every chunk is written to the output with a single
+// write, so packet boundaries are preserved end to end.
 type ToxicLink struct {
 	stubs     []*toxics.ToxicStub
 	proxy     Proxy
 	toxics    *ToxicCollection
 	input     *stream.ChanWriter
 	output    *stream.ChanReader
+	chunks    <-chan *stream.StreamChunk
 	direction stream.Direction
-	Logger    *zerolog.Logger
+	datagram  bool
+	// Shared with the link in the other direction of the same connection
+	connection *toxics.Connection
+	Logger     *zerolog.Logger
 }
 
 func NewToxicLink(
@@ -63,6 +72,7 @@ func NewToxicLink(
 		last = next
 	}
 	link.output = stream.NewChanReader(last)
+	link.chunks = last
 	return link
 }
 
@@ -85,21 +95,17 @@ func (link *ToxicLink) Start(
 		link.proxy.Listen(),
 		link.proxy.Upstream()}
 
-	go link.read(labels, server, source)
-
 	for i, toxic := range link.toxics.chain[link.direction] {
-		if stateful, ok := toxic.Toxic.(toxics.StatefulToxic); ok {
-			link.stubs[i].State = stateful.NewState()
-		}
+		link.prepareStub(link.stubs[i], toxic)
 
 		if _, ok := toxic.Toxic.(*toxics.ResetToxic); ok {
-			if err := source.(*net.TCPConn).SetLinger(0); err != nil {
+			if err := resetOnClose(source); err != nil {
 				logger.Err(err).
 					Str("toxic", toxic.Type).
 					Msg("source: Unable to setLinger(ms)")
 			}
 
-			if err := dest.(*net.TCPConn).SetLinger(0); err != nil {
+			if err := resetOnClose(dest); err != nil {
 				logger.Err(err).
 					Str("toxic", toxic.Type).
 					Msg("dest: Unable to setLinger(ms)")
@@ -109,9 +115,27 @@ func (link *ToxicLink) Start(
 		go link.stubs[i].Run(toxic)
 	}
 
+	// Read once the stubs are prepared, so their toxics see the first byte.
+	go link.read(labels, server, source)
 	go link.write(labels, name, server, dest)
 }
 
+// prepareStub creates the state of the toxic before it runs on the stub.
+func (link *ToxicLink) prepareStub(stub *toxics.ToxicStub, toxic *toxics.ToxicWrapper) {
+	if stateful, ok := toxic.Toxic.(toxics.StatefulToxic); ok {
+		stub.State = stateful.NewState()
+	}
+	if attached, ok := toxic.Toxic.(toxics.ConnectionToxic); ok && link.connection != nil {
+		attached.Attach(stub, link.connection, link.direction)
+	}
+	if shared, ok := toxic.Toxic.(toxics.SharedToxic); ok {
+		if key := shared.SharedKey(toxic.Name); key != "" {
+			shared.Share(stub, link.toxics.shared, key)
+		}
+	}
+	stub.Filter(toxic.Match, link.connection)
+}
+
 // read copies bytes from a source to the link's input channel.
 func (link *ToxicLink) read(
 	metricLabels []string,
@@ -119,7 +143,17 @@ func (link *ToxicLink) read(
 	source io.Reader,
 ) {
 	logger := link.Logger
-	bytes, err := io.Copy(link.input, source)
+	if link.connection != nil {
+		source = &connectionReader{source, link.connection, link.direction}
+	}
+
+	var bytes int64
+	var err error
+	if link.datagram {
+		bytes, err = link.readDatagrams(source)
+	} else {
+		bytes, err = io.Copy(link.input, source)
+	}
 	if err != nil {
 		logger.Warn().
 			Int64("bytes", bytes).
@@ -149,7 +183,13 @@ func (link *ToxicLink) write(
 		Str("link_addr", fmt.Sprintf("%p", link)).
 		Logger()
 
-	bytes, err := io.Copy(dest, link.output)
+	var bytes int64
+	var err error
+	if link.datagram {
+		bytes, err = link.writeDatagrams(dest)
+	} else {
+		bytes, err = io.Copy(dest, link.output)
+	}
 	if err != nil {
 		logger.Warn().
 			Int64("bytes", bytes).
@@ -167,6 +207,48 @@ func (link *ToxicLink) write(
 	link.proxy.RemoveConnection(name)
 }
 
+// readDatagrams passes every packet read from the source as a single chunk.
+func (link *ToxicLink) readDatagrams(source io.Reader) (int64, error) {
+	var bytes int64
+	buffer := make([]byte, UDPBufferSize)
+	for {
+		n, err := source.Read(buffer)
+		if err == io.EOF || errors.Is(err, net.ErrClosed) {
+			return bytes, nil
+		}
+		if err != nil {
+			return bytes, err
+		}
+		bytes += int64(n)
+		link.input.Write(buffer[:n])
+	}
+}
+
+// writeDatagrams writes every chunk to the destination as a single packet.
+// Packets which can not be delivered are dropped, like on a real network.
+func (link *ToxicLink) writeDatagrams(dest io.WriteCloser) (int64, error) {
+	var bytes int64
+	for chunk := range link.chunks {
+		n, err := dest.Write(chunk.Data)
+		bytes += int64(n)
+		if errors.Is(err, net.ErrClosed) {
+			// Closing the destination ends the session, drain the chain
+			// until the source is closed so toxics do not block on output.
+			dest.Close()
+			for range link.chunks {
+			}
+			return bytes, err
+		}
+		if err != nil {
+			link.Logger.Debug().
+				Err(err).
+				Str("direction", link.Direction()).
+				Msg("Dropped packet that could not be written to destination")
+		}
+	}
+	return bytes, nil
+}
+
 // Add a toxic to the end of the chain.
 func (link *ToxicLink) AddToxic(toxic *toxics.ToxicWrapper) {
 	i := len(link.stubs)
@@ -177,10 +259,7 @@ func (link *ToxicLink) AddToxic(toxic *toxics.ToxicWrapper) {
 	// Interrupt the last toxic so that we don't have a race when moving channels
 	if link.stubs[i-1].InterruptToxic() {
 		link.stubs[i-1].Output = newin
-
-		if stateful, ok := toxic.Toxic.(toxics.StatefulToxic); ok {
-			link.stubs[i].State = stateful.NewState()
-		}
+		link.prepareStub(link.stubs[i], toxic)
 
 		go link.stubs[i].Run(toxic)
 		go link.stubs[i-1].Run(link.toxics.chain[link.direction][i-1])
@@ -281,3 +360,22 @@ func (link *ToxicLink) RemoveToxic(ctx context.Context, toxic *toxics.ToxicWrapp
 func (link *ToxicLink) Direction() string {
 	return link.direction.String()
 }
+
+// connectionReader passes all data read from a link's source to the observers
+// of its connection, and tells them when the source ends.
+type connectionReader struct {
+	io.Reader
+	connection *toxics.Connection
+	direction  stream.Direction
+}
+
+func (r *connectionReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.connection.Read(r.direction, p[:n])
+	}
+	if err != nil {
+		r.connection.End(r.direction)
+	}
+	return n, err
+}
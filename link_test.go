@@ -1,6 +1,7 @@
 package toxiproxy
 
 import (
+	"bufio"
 	"context"
 	"encoding/binary"
 	"flag"
@@ -323,3 +324,90 @@ func TestRemoveToxicWithBrokenConnection(t *testing.T) {
 	collection.chainRemoveToxic(ctx, toxics[0])
 	collection.chainRemoveToxic(ctx, toxics[1])
 }
+
+func TestLinksShareConnection(t *testing.T) {
+	srv := NewServer(NewMetricsContainer(nil), zerolog.Nop())
+	proxy := NewProxyTCP(srv, "test", "localhost:0", "upstream")
+	collection := proxy.Toxics()
+
+	upstreamReader, upstreamWriter := io.Pipe()
+	downstreamReader, downstreamWriter := io.Pipe()
+	collection.StartLink(srv, "clientdownstream", downstreamReader,
+		&testWriteCloser{bufio.NewWriter(io.Discard)}, stream.Downstream)
+	collection.StartLink(srv, "clientupstream", upstreamReader,
+		&testWriteCloser{bufio.NewWriter(io.Discard)}, stream.Upstream)
+
+	collection.Lock()
+	upstream := collection.links["clientupstream"].connection
+	downstream := collection.links["clientdownstream"].connection
+	collection.Unlock()
+	if upstream == nil || upstream != downstream {
+		t.Fatal("Expected both links of a connection to share it")
+	}
+
+	upstreamWriter.Close()
+	downstreamWriter.Close()
+
+	err := testhelper.TimeoutAfter(time.Second, func() {
+		for {
+			collection.Lock()
+			remaining := len(collection.connections)
+			collection.Unlock()
+			if remaining == 0 {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+	if err != nil {
+		t.Fatal("Expected connection to be forgotten once both links are closed")
+	}
+}
+
+func TestSharedStateSpansDirections(t *testing.T) {
+	collection := NewToxicCollection(nil)
+	shared := func(name, group string, direction stream.Direction) *toxics.ToxicWrapper {
+		return &toxics.ToxicWrapper{
+			Toxic: &toxics.BandwidthToxic{
+				Rate:   100,
+				Shared: toxics.ShareProxy,
+				Group:  group,
+			},
+			Name:      name,
+			Type:      "bandwidth",
+			Direction: direction,
+			Toxicity:  1,
+		}
+	}
+	// stored reports whether the state of the key is kept, as links would load it.
+	stored := func(key string) bool {
+		created := false
+		collection.shared.Load(key, func() interface{} {
+			created = true
+			return nil
+		})
+		return !created
+	}
+
+	upstream := shared("upstream", "both", stream.Upstream)
+	downstream := shared("downstream", "both", stream.Downstream)
+	unrelated := shared("unrelated", "", stream.Downstream)
+	collection.chainAddToxic(upstream)
+	collection.chainAddToxic(downstream)
+	collection.chainAddToxic(unrelated)
+	stored("bandwidth:both")
+	stored("bandwidth:unrelated")
+
+	collection.chainRemoveToxic(context.Background(), unrelated)
+	if stored("bandwidth:unrelated") {
+		t.Fatal("Expected the state of a toxic to be dropped with it")
+	}
+	collection.chainRemoveToxic(context.Background(), upstream)
+	if !stored("bandwidth:both") {
+		t.Fatal("Expected the shared state to be kept while a toxic of the group remains")
+	}
+	collection.chainRemoveToxic(context.Background(), downstream)
+	if stored("bandwidth:both") {
+		t.Fatal("Expected the shared state to be dropped with the last toxic of the group")
+	}
+}
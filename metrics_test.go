@@ -3,18 +3,21 @@ package toxiproxy
 import (
 	"bufio"
 	"bytes"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 
 	"github.com/Shopify/toxiproxy/v2/collectors"
 	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/testhelper"
 )
 
 func TestProxyMetricsReceivedSentBytes(t *testing.T) {
@@ -54,6 +57,69 @@ func TestProxyMetricsReceivedSentBytes(t *testing.T) {
 	}
 }
 
+func TestProxyMetricsUDPSessionEvictions(t *testing.T) {
+	srv := NewServer(NewMetricsContainer(prometheus.NewRegistry()), zerolog.Nop())
+	srv.Metrics.ProxyMetrics = collectors.NewProxyMetricCollectors()
+
+	testhelper.WithUDPServer(t, func(upstream string) {
+		proxy, err := NewProxy(srv, ProxyConfig{
+			Name:        "test_proxy_metrics_udp_session_evictions",
+			Listen:      "127.0.0.1:0",
+			Upstream:    upstream,
+			Protocol:    ProtocolUDP,
+			MaxSessions: 1,
+		})
+		if err != nil {
+			t.Fatal("Failed to create proxy:", err)
+		}
+		err = proxy.Start()
+		if err != nil {
+			t.Fatal("Failed to start proxy:", err)
+		}
+		defer proxy.Stop()
+
+		raddr, err := net.ResolveUDPAddr("udp", proxy.Listen())
+		if err != nil {
+			t.Fatal("Failed to resolve proxy listen udp addr:", err)
+		}
+		for i := 0; i < 2; i++ {
+			conn, err := net.DialUDP("udp", nil, raddr)
+			if err != nil {
+				t.Fatal("Failed to dial udp proxy:", err)
+			}
+			defer conn.Close()
+
+			_, err = conn.Write([]byte("hello"))
+			if err != nil {
+				t.Fatal("Failed writing to UDP proxy", err)
+			}
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = conn.Read(make([]byte, 1024))
+			if err != nil {
+				t.Fatal("Failed reading from UDP proxy", err)
+			}
+		}
+
+		actual := prometheusOutput(t, srv, "toxiproxy_proxy_udp_session_evictions_total")
+
+		expected := []string{
+			`toxiproxy_proxy_udp_session_evictions_total{` +
+				`listener="` + proxy.Listen() + `",` +
+				`proxy="test_proxy_metrics_udp_session_evictions",reason="limit",` +
+				`upstream="` + upstream + `"` +
+				`} 1`,
+		}
+
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf(
+				"\nexpected:\n  [%v]\ngot:\n  [%v]",
+				strings.Join(expected, "\n  "),
+				strings.Join(actual, "\n  "),
+			)
+		}
+	})
+}
+
 func TestRuntimeMetricsBuildInfo(t *testing.T) {
 	srv := NewServer(NewMetricsContainer(prometheus.NewRegistry()), zerolog.Nop())
 	srv.Metrics.RuntimeMetrics = collectors.NewRuntimeMetricCollectors()
@@ -0,0 +1,91 @@
+package toxiproxy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// portRange is the range of ports of an address like localhost:30000-30010. A TCP
+// proxy listening on a range of ports maps each of them to the port at the same
+// offset in the ranges of its upstreams.
+type portRange struct {
+	host  string
+	first int
+	last  int
+}
+
+// parsePortRange returns the range of ports of the address, or nil when the address
+// has a single port.
+func parsePortRange(address string) (*portRange, error) {
+	if network, _ := splitNetwork(address); network == "unix" {
+		return nil, nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || !strings.Contains(port, "-") {
+		return nil, nil
+	}
+
+	bounds := strings.SplitN(port, "-", 2)
+	first, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return nil, ErrInvalidPortRange
+	}
+	last, err := strconv.Atoi(bounds[1])
+	if err != nil {
+		return nil, ErrInvalidPortRange
+	}
+	if first < 1 || last > 65535 || first > last {
+		return nil, ErrInvalidPortRange
+	}
+	return &portRange{host: host, first: first, last: last}, nil
+}
+
+func (ports *portRange) size() int {
+	return ports.last - ports.first + 1
+}
+
+// address returns the address of the port at the offset in the range.
+func (ports *portRange) address(offset int) string {
+	return net.JoinHostPort(ports.host, strconv.Itoa(ports.first+offset))
+}
+
+// validatePortRanges checks that the upstreams only have ranges of ports when the
+// proxy listens on a range, of the same size. Upstreams with a single port are
+// shared by all the listen ports.
+func validatePortRanges(listen string, upstreams []string) error {
+	listenPorts, err := parsePortRange(listen)
+	if err != nil {
+		return err
+	}
+	for _, upstream := range upstreams {
+		ports, err := parsePortRange(upstream)
+		if err != nil {
+			return err
+		}
+		if ports != nil && (listenPorts == nil || ports.size() != listenPorts.size()) {
+			return ErrInvalidPortRange
+		}
+	}
+	return nil
+}
+
+// usesPortRanges reports whether the proxy listens on or dials ranges of ports.
+func (config *ProxyConfig) usesPortRanges() bool {
+	for _, address := range append([]string{config.Listen, config.Upstream}, config.Upstreams...) {
+		if ports, err := parsePortRange(address); ports != nil || err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// upstreamAddress returns the address a client accepted on the listen port at the
+// offset connects to, for an upstream which can have a range of ports.
+func upstreamAddress(upstream string, offset int) string {
+	ports, err := parsePortRange(upstream)
+	if ports == nil || err != nil {
+		return upstream
+	}
+	return ports.address(offset)
+}
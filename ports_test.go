@@ -0,0 +1,55 @@
+package toxiproxy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePortRanges(t *testing.T) {
+	testCases := []struct {
+		name      string
+		listen    string
+		upstreams string
+		valid     bool
+	}{
+		{"single ports", "localhost:0", "db:5432", true},
+		{"ranges", "localhost:30000-30010", "db:5432-5442,[::1]:6000-6010", true},
+		{"shared upstream", "localhost:30000-30010", "db:5432", true},
+		{"unix upstream", "localhost:30000-30010", "unix:///tmp/db-1.sock", true},
+		{"range to single port", "localhost:30000", "db:5432-5442", false},
+		{"different sizes", "localhost:30000-30010", "db:5432-5440", false},
+		{"reversed", "localhost:30010-30000", "db:5432", false},
+		{"port 0", "localhost:0-10", "db:5432", false},
+		{"not a port", "localhost:30000-http", "db:5432", false},
+		{"above 65535", "localhost:65530-65540", "db:5432", false},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			err := validatePortRanges(tc.listen, strings.Split(tc.upstreams, ","))
+			if (err == nil) != tc.valid {
+				t.Fatalf("Expected %s to %s to be valid: %v, got %v",
+					tc.listen, tc.upstreams, tc.valid, err)
+			}
+		})
+	}
+}
+
+func TestUpstreamAddress(t *testing.T) {
+	for _, tc := range []struct {
+		upstream string
+		offset   int
+		expected string
+	}{
+		{"db:5432-5442", 0, "db:5432"},
+		{"db:5432-5442", 10, "db:5442"},
+		{"[::1]:6000-6010", 3, "[::1]:6003"},
+		{"db:5432", 3, "db:5432"},
+	} {
+		if address := upstreamAddress(tc.upstream, tc.offset); address != tc.expected {
+			t.Errorf("Expected %s at offset %d to be %s, got %s",
+				tc.upstream, tc.offset, tc.expected, address)
+		}
+	}
+}
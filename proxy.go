@@ -1,12 +1,19 @@
 package toxiproxy
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	tomb "gopkg.in/tomb.v1"
 
 	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
 	"github.com/rs/zerolog"
 )
 
@@ -18,7 +25,10 @@ import (
 type ProxyTCP struct {
 	proxyBase
 
-	listener net.Listener
+	// Listeners of the ports of the listen address, in the order of its range
+	listeners []net.Listener
+	// Counts the clients of unix sockets, which have no address to name them by.
+	unixClients uint32
 }
 
 func NewProxyTCP(server *ApiServer, name, listen, upstream string) Proxy {
@@ -37,6 +47,10 @@ func NewProxyTCP(server *ApiServer, name, listen, upstream string) Proxy {
 			name:        name,
 			listen:      listen,
 			upstream:    upstream,
+			upstreams:   newUpstreamList([]string{upstream}, BalanceRoundRobin),
+			resolver:    newResolver(),
+			tls:         &tlsSettings{},
+			protocol:    ProtocolTCP,
 			started:     make(chan error),
 			connections: ConnectionList{list: make(map[string]io.Closer)},
 			apiServer:   server,
@@ -58,6 +72,10 @@ func (proxy *ProxyTCP) Update(input ProxyConfig) error {
 	return proxy.proxyBase.Update(input, proxy)
 }
 
+func (proxy *ProxyTCP) Drain(timeout time.Duration) <-chan struct{} {
+	return proxy.proxyBase.Drain(timeout, proxy)
+}
+
 func (proxy *ProxyTCP) Stop() {
 	proxy.Lock()
 	defer proxy.Unlock()
@@ -66,13 +84,37 @@ func (proxy *ProxyTCP) Stop() {
 }
 
 func (proxy *ProxyTCP) startListening() error {
-	var err error
-	proxy.listener, err = net.Listen("tcp", proxy.listen)
+	network, address := splitNetwork(proxy.listen)
+	if network == "unix" {
+		removeStaleSocket(address)
+	}
+
+	addresses := []string{address}
+	ports, err := parsePortRange(proxy.listen)
 	if err != nil {
 		proxy.started <- err
 		return err
 	}
-	proxy.listen = proxy.listener.Addr().String()
+	if ports != nil {
+		addresses = make([]string, ports.size())
+		for offset := range addresses {
+			addresses[offset] = ports.address(offset)
+		}
+	}
+
+	proxy.listeners = make([]net.Listener, 0, len(addresses))
+	for _, address := range addresses {
+		listener, err := net.Listen(network, address)
+		if err != nil {
+			proxy.close()
+			proxy.started <- err
+			return err
+		}
+		proxy.listeners = append(proxy.listeners, listener)
+	}
+	if network == "tcp" && ports == nil {
+		proxy.listen = proxy.listeners[0].Addr().String()
+	}
 	proxy.started <- nil
 
 	proxy.Logger().
@@ -83,13 +125,15 @@ func (proxy *ProxyTCP) startListening() error {
 }
 
 func (proxy *ProxyTCP) close() {
-	// Unblock proxy.listener.Accept()
-	err := proxy.listener.Close()
-	if err != nil {
-		proxy.logger.
-			Warn().
-			Err(err).
-			Msg("Attempted to close an already closed proxy server")
+	// Unblock listener.Accept()
+	for _, listener := range proxy.listeners {
+		err := listener.Close()
+		if err != nil {
+			proxy.logger.
+				Warn().
+				Err(err).
+				Msg("Attempted to close an already closed proxy server")
+		}
 	}
 }
 
@@ -119,12 +163,41 @@ func (proxy *ProxyTCP) server() {
 	acceptTomb := &tomb.Tomb{}
 	defer acceptTomb.Done()
 
+	// Clients still connecting once the proxy stops must not see an update.
+	upstreams := proxy.upstreams
+	mode := proxy.mode
+
+	// Cancels connection toxics still delaying clients once the proxy stops.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	// This channel is to kill the blocking Accept() call below by closing the
 	// net.Listener.
 	go proxy.freeBlocker(acceptTomb)
 
+	var accepting sync.WaitGroup
+	for offset, listener := range proxy.listeners {
+		accepting.Add(1)
+		go func(listener net.Listener, offset int) {
+			defer accepting.Done()
+			proxy.accept(ctx, acceptTomb, listener, offset, upstreams, mode)
+		}(listener, offset)
+	}
+	accepting.Wait()
+}
+
+// accept accepts the clients of one of the listen ports, which connect to the
+// upstream ports at the same offset.
+func (proxy *ProxyTCP) accept(
+	ctx context.Context,
+	acceptTomb *tomb.Tomb,
+	listener net.Listener,
+	offset int,
+	upstreams *upstreamList,
+	mode string,
+) {
 	for {
-		client, err := proxy.listener.Accept()
+		client, err := listener.Accept()
 		if err != nil {
 			// This is to confirm we're being shut down in a legit way. Unfortunately,
 			// Go doesn't export the error when it's closed from Close() so we have to
@@ -142,27 +215,211 @@ func (proxy *ProxyTCP) server() {
 			return
 		}
 
+		name := proxy.clientName(client)
 		proxy.logger.
 			Info().
-			Str("client", client.RemoteAddr().String()).
+			Str("client", name).
 			Msg("Accepted client")
 
-		upstream, err := net.Dial("tcp", proxy.upstream)
+		proxy.connections.reserve()
+		go proxy.connect(ctx, name, client, offset, upstreams, mode)
+	}
+}
+
+// connect dials the upstream for a new client and starts its links, unless the
+// connection toxics turn the client away. Clients of forward proxies are
+// connected to the destination they request instead.
+func (proxy *ProxyTCP) connect(
+	ctx context.Context,
+	name string,
+	client net.Conn,
+	offset int,
+	upstreams *upstreamList,
+	mode string,
+) {
+	defer proxy.connections.release()
+
+	var destination string
+	if mode != "" {
+		forwarded, requested, err := readDestination(mode, client)
 		if err != nil {
 			proxy.logger.
 				Err(err).
-				Str("client", client.RemoteAddr().String()).
-				Msg("Unable to open connection to upstream")
+				Str("client", name).
+				Msg("Unable to read the destination requested by client")
 			client.Close()
-			continue
+			return
+		}
+		client, destination = forwarded, requested
+	}
+
+	action, others, done := proxy.toxics.Connect(ctx, name, destination)
+	defer done()
+
+	if action != toxics.ConnectAllow {
+		proxy.logger.
+			Info().
+			Str("client", name).
+			Msg("Client turned away by connection toxics")
+		if action == toxics.ConnectReset {
+			if err := resetOnClose(client); err != nil {
+				proxy.logger.Err(err).
+					Str("client", name).
+					Msg("Unable to setLinger(ms)")
+			}
+		} else if mode != "" {
+			_ = replyDestination(mode, client, errTurnedAway)
+		}
+		client.Close()
+		return
+	}
+
+	settings := proxy.getTLS()
+	var upstream net.Conn
+	var err error
+	if mode == "" {
+		client, err = settings.accept(ctx, client, others.Handshakes)
+		if err != nil {
+			proxy.logger.
+				Err(err).
+				Str("client", name).
+				Msg("TLS handshake with client failed")
+			return
 		}
+		upstream, err = proxy.dialUpstream(ctx, name, offset, upstreams, settings, others.Resolves)
+	} else {
+		upstream, err = proxy.dialDestination(ctx, destination, settings, others.Resolves)
+		if replyErr := replyDestination(mode, client, err); err == nil {
+			err = replyErr
+		}
+	}
+	if err != nil {
+		proxy.logger.
+			Err(err).
+			Str("client", name).
+			Str("destination", destination).
+			Msg("Unable to open connection to upstream")
+		if upstream != nil {
+			upstream.Close()
+		}
+		client.Close()
+		return
+	}
+
+	if mode != "" {
+		// TLS is terminated within the tunnel, once the destination is reached.
+		client, err = settings.accept(ctx, client, others.Handshakes)
+		if err != nil {
+			proxy.logger.
+				Err(err).
+				Str("client", name).
+				Msg("TLS handshake with client failed")
+			upstream.Close()
+			return
+		}
+	}
+
+	if !proxy.connections.add(name, upstream, client) {
+		// The proxy was stopped while connecting.
+		upstream.Close()
+		client.Close()
+		return
+	}
+
+	// Start downstream first, so its toxics can observe the connection's
+	// upstream data from the first byte.
+	proxy.toxics.StartLink(proxy.apiServer, name+"downstream", upstream, client, stream.Downstream)
+	proxy.toxics.StartLink(proxy.apiServer, name+"upstream", client, upstream, stream.Upstream)
+}
+
+// clientName returns the name of a new client, its address for TCP clients. The
+// clients of unix sockets have no address, they are numbered instead.
+func (proxy *ProxyTCP) clientName(client net.Conn) string {
+	if _, ok := client.(*net.UnixConn); ok {
+		return fmt.Sprintf("unix-%d", atomic.AddUint32(&proxy.unixClients, 1))
+	}
+	return client.RemoteAddr().String()
+}
 
-		name := client.RemoteAddr().String()
-		proxy.connections.Lock()
-		proxy.connections.list[name+"upstream"] = upstream
-		proxy.connections.list[name+"downstream"] = client
-		proxy.connections.Unlock()
-		proxy.toxics.StartLink(proxy.apiServer, name+"upstream", client, upstream, stream.Upstream)
-		proxy.toxics.StartLink(proxy.apiServer, name+"downstream", upstream, client, stream.Downstream)
+// dialUpstream dials the upstreams in the order chosen for the client, until one
+// of them accepts the connection. Upstreams with a range of ports are dialed on the
+// port at the offset of the listen port of the client.
+func (proxy *ProxyTCP) dialUpstream(
+	ctx context.Context,
+	client string,
+	offset int,
+	upstreams *upstreamList,
+	settings *tlsSettings,
+	resolves []toxics.ResolveToxic,
+) (net.Conn, error) {
+	addresses := upstreams.order()
+	if len(addresses) == 0 {
+		return nil, ErrNoUpstreamAvailable
+	}
+
+	var err error
+	for i, address := range addresses {
+		address = upstreamAddress(address, offset)
+		var upstream net.Conn
+		upstream, err = proxy.dial(ctx, address, resolves)
+		if err == nil {
+			upstream, err = settings.dial(upstream, address)
+		}
+		if err == nil {
+			return upstream, nil
+		}
+		if i < len(addresses)-1 {
+			proxy.logger.
+				Warn().
+				Err(err).
+				Str("client", client).
+				Str("upstream", address).
+				Msg("Unable to open connection to upstream, trying the next one")
+		}
+	}
+	return nil, err
+}
+
+// dialDestination dials the destination requested by the client of a forward
+// proxy. Clients can not request unix sockets.
+func (proxy *ProxyTCP) dialDestination(
+	ctx context.Context,
+	destination string,
+	settings *tlsSettings,
+	resolves []toxics.ResolveToxic,
+) (net.Conn, error) {
+	if strings.HasPrefix(destination, unixScheme) {
+		return nil, ErrUnsupportedRequest
+	}
+	upstream, err := proxy.dial(ctx, destination, resolves)
+	if err != nil {
+		return nil, err
+	}
+	return settings.dial(upstream, destination)
+}
+
+// dial resolves the hostname of an upstream, and dials its addresses in turn.
+// Unix sockets are dialed directly, there is nothing to resolve.
+func (proxy *ProxyTCP) dial(
+	ctx context.Context,
+	address string,
+	resolves []toxics.ResolveToxic,
+) (net.Conn, error) {
+	if network, path := splitNetwork(address); network == "unix" {
+		return net.Dial(network, path)
+	}
+
+	addrs, err := proxy.resolver.resolve(ctx, address, resolves)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		var upstream net.Conn
+		upstream, err = net.Dial("tcp", addr)
+		if err == nil {
+			return upstream, nil
+		}
 	}
+	return nil, err
 }
@@ -1,10 +1,16 @@
 package toxiproxy
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"reflect"
 	"sync"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
 )
 
 // ProxyCollection is a collection of proxies. It's the interface for anything
@@ -48,10 +54,29 @@ func (collection *ProxyCollection) AddOrReplace(proxy Proxy, start bool) error {
 	defer collection.Unlock()
 
 	if existing, exists := collection.proxies[proxy.Name()]; exists {
-		if existing.Listen() == proxy.Listen() && existing.Upstream() == proxy.Upstream() {
+		existingConfig, config := existing.Config(), proxy.Config()
+		if existing.Listen() == proxy.Listen() &&
+			sameUpstreams(existingConfig.Upstreams, config.Upstreams) &&
+			existingConfig.Balance == config.Balance &&
+			existingConfig.Mode == config.Mode &&
+			reflect.DeepEqual(existingConfig.TLS, config.TLS) &&
+			reflect.DeepEqual(existingConfig.UpstreamTLS, config.UpstreamTLS) &&
+			existing.Protocol() == proxy.Protocol() {
+			// Labels, session limits and the resolver are applied without restarting
+			// the proxy.
+			existing.(proxyInternal).setLabels(config.Labels)
+			existing.(proxyInternal).setSessionLimits(config.IdleTimeout, config.MaxSessions)
+			if !reflect.DeepEqual(existingConfig.Resolver, config.Resolver) {
+				resolver := ResolverConfig{}
+				if config.Resolver != nil {
+					resolver = *config.Resolver
+				}
+				existing.(proxyInternal).getResolver().setConfig(resolver)
+			}
 			return nil
 		}
 		existing.Stop()
+		existing.Toxics().StopSchedules()
 	}
 
 	if start {
@@ -86,9 +111,34 @@ func (collection *ProxyCollection) PopulateJson(
 		if len(input[i].Name) < 1 {
 			return nil, joinError(fmt.Errorf("name at proxy %d", i+1), ErrMissingField)
 		}
-		if len(input[i].Upstream) < 1 {
+		if _, err := parseMode(input[i].Mode); err != nil {
+			return nil, joinError(fmt.Errorf("mode at proxy %d", i+1), ErrInvalidMode)
+		}
+		if len(input[i].Upstream) < 1 && len(input[i].Upstreams) < 1 && input[i].Mode == "" {
 			return nil, joinError(fmt.Errorf("upstream at proxy %d", i+1), ErrMissingField)
 		}
+		upstreams, err := input[i].upstreamAddresses(nil)
+		if err != nil {
+			return nil, joinError(fmt.Errorf("upstreams at proxy %d", i+1), ErrInvalidUpstreams)
+		}
+		if validatePortRanges(input[i].Listen, upstreams) != nil {
+			return nil, joinError(fmt.Errorf("ports at proxy %d", i+1), ErrInvalidPortRange)
+		}
+		if validateLabels(input[i].Labels) != nil {
+			return nil, joinError(fmt.Errorf("labels at proxy %d", i+1), ErrInvalidLabels)
+		}
+		if _, err := parseBalance(input[i].Balance); err != nil {
+			return nil, joinError(fmt.Errorf("balance at proxy %d", i+1), ErrInvalidBalance)
+		}
+		if resolver := input[i].Resolver; resolver != nil && resolver.validate() != nil {
+			return nil, joinError(fmt.Errorf("resolver at proxy %d", i+1), ErrInvalidResolver)
+		}
+		if _, err := newTLSSettings(input[i].TLS, input[i].UpstreamTLS); err != nil {
+			return nil, joinError(fmt.Errorf("tls at proxy %d", i+1), ErrInvalidTLS)
+		}
+		if _, err := parseProtocol(input[i].Protocol); err != nil {
+			return nil, joinError(fmt.Errorf("protocol at proxy %d", i+1), ErrInvalidProtocol)
+		}
 		if input[i].Enabled == nil {
 			input[i].Enabled = &t
 		}
@@ -97,7 +147,11 @@ func (collection *ProxyCollection) PopulateJson(
 	proxies := make([]Proxy, 0, len(input))
 
 	for i := range input {
-		proxy := NewProxyTCP(server, input[i].Name, input[i].Listen, input[i].Upstream)
+		proxy, err := NewProxy(server, input[i].ProxyConfig)
+		if err != nil {
+			return proxies, err
+		}
+
 		err = collection.AddOrReplace(proxy, *input[i].Enabled)
 		if err != nil {
 			return proxies, err
@@ -136,17 +190,33 @@ func (collection *ProxyCollection) Remove(name string) error {
 		return err
 	}
 	proxy.Stop()
+	proxy.Toxics().StopSchedules()
 
 	delete(collection.proxies, proxy.Name())
 	return nil
 }
 
+// DrainAll drains all the proxies at once, and waits until they are drained.
+func (collection *ProxyCollection) DrainAll(timeout time.Duration) {
+	collection.RLock()
+	var drained []<-chan struct{}
+	for _, proxy := range collection.proxies {
+		drained = append(drained, proxy.Drain(timeout))
+	}
+	collection.RUnlock()
+
+	for _, done := range drained {
+		<-done
+	}
+}
+
 func (collection *ProxyCollection) Clear() error {
 	collection.Lock()
 	defer collection.Unlock()
 
 	for _, proxy := range collection.proxies {
 		proxy.Stop()
+		proxy.Toxics().StopSchedules()
 
 		delete(collection.proxies, proxy.Name())
 	}
@@ -154,6 +224,170 @@ func (collection *ProxyCollection) Clear() error {
 	return nil
 }
 
+// Select returns the proxies whose labels match the selector, ordered by name.
+func (collection *ProxyCollection) Select(selector Selector) []Proxy {
+	collection.RLock()
+	defer collection.RUnlock()
+
+	return collection.selectProxies(selector)
+}
+
+// AddToxicJson adds the toxic to all the proxies matching the selector at once, or
+// to none of them when one of them can not take it.
+func (collection *ProxyCollection) AddToxicJson(
+	selector Selector,
+	data io.Reader,
+) ([]Proxy, error) {
+	body, err := io.ReadAll(data)
+	if err != nil {
+		return nil, joinError(err, ErrBadRequestBody)
+	}
+
+	collection.Lock()
+	defer collection.Unlock()
+
+	proxies := collection.selectProxies(selector)
+	defer lockToxics(proxies)()
+
+	// Every proxy gets a toxic of its own, as toxics keep state.
+	wrappers := make([]*toxics.ToxicWrapper, len(proxies))
+	for i, proxy := range proxies {
+		wrappers[i], err = proxy.Toxics().decodeToxic(bytes.NewReader(body))
+		if err != nil {
+			return nil, proxyError(proxy, err)
+		}
+	}
+	for i, proxy := range proxies {
+		proxy.Toxics().addToxic(wrappers[i])
+	}
+	return proxies, nil
+}
+
+// UpdateToxicJson updates the toxic of all the proxies matching the selector at
+// once, or of none of them when the update does not apply to one of them.
+func (collection *ProxyCollection) UpdateToxicJson(
+	selector Selector,
+	name string,
+	data io.Reader,
+) ([]Proxy, error) {
+	body, err := io.ReadAll(data)
+	if err != nil {
+		return nil, joinError(err, ErrBadRequestBody)
+	}
+
+	collection.Lock()
+	defer collection.Unlock()
+
+	proxies := collection.selectProxies(selector)
+	defer lockToxics(proxies)()
+
+	updates := make([]*toxicUpdate, len(proxies))
+	for i, proxy := range proxies {
+		toxic := proxy.Toxics().findToxicByName(name)
+		if toxic == nil {
+			return nil, proxyError(proxy, ErrToxicNotFound)
+		}
+		updates[i], err = decodeToxicUpdate(toxic, bytes.NewReader(body))
+		if err != nil {
+			return nil, proxyError(proxy, err)
+		}
+	}
+	for i, proxy := range proxies {
+		toxic := proxy.Toxics().findToxicByName(name)
+		proxy.Toxics().applyToxicUpdate(toxic, updates[i])
+	}
+	return proxies, nil
+}
+
+// RemoveToxic removes the toxic of all the proxies matching the selector at once,
+// or of none of them when one of them does not have it.
+func (collection *ProxyCollection) RemoveToxic(
+	ctx context.Context,
+	selector Selector,
+	name string,
+) ([]Proxy, error) {
+	collection.Lock()
+	defer collection.Unlock()
+
+	proxies := collection.selectProxies(selector)
+	defer lockToxics(proxies)()
+
+	for _, proxy := range proxies {
+		if proxy.Toxics().findToxicByName(name) == nil {
+			return nil, proxyError(proxy, ErrToxicNotFound)
+		}
+	}
+	for _, proxy := range proxies {
+		proxyToxics := proxy.Toxics()
+		proxyToxics.removeToxic(ctx, proxyToxics.findToxicByName(name))
+	}
+	return proxies, nil
+}
+
+// SetEnabled enables or disables all the proxies matching the selector at once.
+// When one of them fails to start, the proxies it enabled are stopped again.
+func (collection *ProxyCollection) SetEnabled(selector Selector, enabled bool) ([]Proxy, error) {
+	collection.Lock()
+	defer collection.Unlock()
+
+	proxies := collection.selectProxies(selector)
+	var started []Proxy
+	for _, proxy := range proxies {
+		if proxy.Enabled() == enabled {
+			continue
+		}
+		if !enabled {
+			proxy.Stop()
+			continue
+		}
+
+		if err := proxy.Start(); err != nil {
+			for _, proxy := range started {
+				proxy.Stop()
+			}
+			return nil, proxyError(proxy, err)
+		}
+		started = append(started, proxy)
+	}
+	return proxies, nil
+}
+
+// selectProxies returns the proxies whose labels match the selector, ordered by
+// name. Assumes the lock has already been taken.
+func (collection *ProxyCollection) selectProxies(selector Selector) []Proxy {
+	proxies := make([]Proxy, 0)
+	for _, proxy := range sortedProxies(collection.proxies) {
+		if selector.Matches(proxy.Labels()) {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// lockToxics locks the toxic collections of the proxies, so a bulk operation
+// applies to all of them at once. It returns the function unlocking them.
+func lockToxics(proxies []Proxy) func() {
+	for _, proxy := range proxies {
+		proxy.Toxics().Lock()
+	}
+	return func() {
+		for _, proxy := range proxies {
+			proxy.Toxics().Unlock()
+		}
+	}
+}
+
+// proxyError names the proxy a bulk operation failed on in its error.
+func proxyError(proxy Proxy, err error) error {
+	if apiErr, ok := err.(*ApiError); ok {
+		return &ApiError{
+			Message:    fmt.Sprintf("%s at proxy %s", apiErr.Message, proxy.Name()),
+			StatusCode: apiErr.StatusCode,
+		}
+	}
+	return fmt.Errorf("%s at proxy %s", err, proxy.Name())
+}
+
 // getByName returns a proxy by its name. Its used from #remove and #get.
 // It assumes the lock has already been acquired.
 func (collection *ProxyCollection) getByName(name string) (Proxy, error) {
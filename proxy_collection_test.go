@@ -3,6 +3,7 @@ package toxiproxy_test
 import (
 	"bytes"
 	"net"
+	"reflect"
 	"testing"
 
 	"github.com/Shopify/toxiproxy/v2"
@@ -118,3 +119,82 @@ func TestAddAndRemoveProxyFromCollection(t *testing.T) {
 		}
 	})
 }
+
+func TestAddOrReplaceAppliesSessionLimits(t *testing.T) {
+	collection := toxiproxy.NewProxyCollection()
+	config := toxiproxy.ProxyConfig{
+		Name:     "udp",
+		Listen:   "localhost:0",
+		Upstream: "localhost:20000",
+		Protocol: "udp",
+	}
+	proxy, err := toxiproxy.NewProxy(nil, config)
+	if err != nil {
+		t.Fatal("Unable to create proxy:", err)
+	}
+	if err := collection.AddOrReplace(proxy, false); err != nil {
+		t.Fatal("Unable to add proxy:", err)
+	}
+
+	config.IdleTimeout = 1000
+	config.MaxSessions = 10
+	replacement, err := toxiproxy.NewProxy(nil, config)
+	if err != nil {
+		t.Fatal("Unable to create proxy:", err)
+	}
+	if err := collection.AddOrReplace(replacement, false); err != nil {
+		t.Fatal("Unable to replace proxy:", err)
+	}
+
+	existing, err := collection.Get("udp")
+	if err != nil {
+		t.Fatal("Expected proxy to be kept:", err)
+	}
+	if existing != proxy {
+		t.Fatal("Expected the proxy to be updated rather than replaced")
+	}
+	if existing.Config().IdleTimeout != 1000 || existing.Config().MaxSessions != 10 {
+		t.Fatal("Expected the session limits to be applied, got", existing.Config())
+	}
+}
+
+func TestAddOrReplaceAppliesResolver(t *testing.T) {
+	collection := toxiproxy.NewProxyCollection()
+	config := toxiproxy.ProxyConfig{
+		Name:     "test",
+		Listen:   "localhost:0",
+		Upstream: "upstream.test:20000",
+	}
+	proxy, err := toxiproxy.NewProxy(nil, config)
+	if err != nil {
+		t.Fatal("Unable to create proxy:", err)
+	}
+	if err := collection.AddOrReplace(proxy, false); err != nil {
+		t.Fatal("Unable to add proxy:", err)
+	}
+
+	for _, resolver := range []*toxiproxy.ResolverConfig{
+		{TTL: 1000, Hosts: map[string]string{"upstream.test": "127.0.0.1"}},
+		nil,
+	} {
+		config.Resolver = resolver
+		replacement, err := toxiproxy.NewProxy(nil, config)
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+		if err := collection.AddOrReplace(replacement, false); err != nil {
+			t.Fatal("Unable to replace proxy:", err)
+		}
+
+		existing, err := collection.Get("test")
+		if err != nil {
+			t.Fatal("Expected proxy to be kept:", err)
+		}
+		if existing != proxy {
+			t.Fatal("Expected the proxy to be updated rather than replaced")
+		}
+		if !reflect.DeepEqual(existing.Config().Resolver, resolver) {
+			t.Fatalf("Expected the resolver %v to be applied, got %v", resolver, existing.Config().Resolver)
+		}
+	}
+}
@@ -3,10 +3,21 @@ package toxiproxy
 import (
 	"errors"
 	"io"
+	"net"
+	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 	tomb "gopkg.in/tomb.v1"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+)
+
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
 )
 
 type ProxyConfig struct {
@@ -14,6 +25,165 @@ type ProxyConfig struct {
 	Listen   string `json:"listen"`
 	Upstream string `json:"upstream"`
 	Enabled  bool   `json:"enabled"`
+	Protocol string `json:"protocol"`
+
+	// Free-form labels proxies are selected by, for bulk operations.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Forward proxy mode connecting clients to the destination they request,
+	// socks5 or http_connect, instead of to the upstreams.
+	Mode string `json:"mode,omitempty"`
+
+	// Upstreams new clients are balanced between, the first one is the upstream.
+	Upstreams []string `json:"upstreams,omitempty"`
+	Balance   string   `json:"balance,omitempty"`
+
+	Resolver *ResolverConfig `json:"resolver,omitempty"`
+
+	// TLS terminated on the listener, and originated to the upstreams.
+	TLS         *TLSConfig         `json:"tls,omitempty"`
+	UpstreamTLS *UpstreamTLSConfig `json:"upstream_tls,omitempty"`
+
+	// UDP only: milliseconds of inactivity after which a client session is
+	// closed and the maximum number of concurrent client sessions.
+	IdleTimeout int64 `json:"idle_timeout,omitempty"`
+	MaxSessions int   `json:"max_sessions,omitempty"`
+}
+
+// NewProxy creates a TCP or UDP proxy depending on the protocol of the config.
+// An empty protocol defaults to TCP.
+func NewProxy(server *ApiServer, config ProxyConfig) (Proxy, error) {
+	protocol, err := parseProtocol(config.Protocol)
+	if err != nil {
+		return nil, err
+	}
+	if protocol == ProtocolUDP && config.usesUnixSockets() {
+		return nil, ErrUnixSocketProtocol
+	}
+	if protocol == ProtocolUDP && config.usesPortRanges() {
+		return nil, ErrPortRangeProtocol
+	}
+	mode, err := parseMode(config.Mode)
+	if err != nil {
+		return nil, err
+	}
+	if protocol == ProtocolUDP && mode != "" {
+		return nil, ErrModeProtocol
+	}
+	config.Mode = mode
+	if err := validateLabels(config.Labels); err != nil {
+		return nil, err
+	}
+	if protocol == ProtocolUDP && (config.TLS != nil || config.UpstreamTLS != nil) {
+		return nil, ErrTLSProtocol
+	}
+	upstreams, err := config.upstreamAddresses(nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := validatePortRanges(config.Listen, upstreams); err != nil {
+		return nil, err
+	}
+	balance, err := parseBalance(config.Balance)
+	if err != nil {
+		return nil, err
+	}
+	if config.Resolver != nil {
+		if err := config.Resolver.validate(); err != nil {
+			return nil, err
+		}
+	}
+	tls, err := newTLSSettings(config.TLS, config.UpstreamTLS)
+	if err != nil {
+		return nil, err
+	}
+
+	var proxy Proxy
+	if protocol == ProtocolUDP {
+		proxy = NewProxyUdp(server, config.Name, config.Listen, upstreams[0])
+	} else {
+		proxy = NewProxyTCP(server, config.Name, config.Listen, firstUpstream(upstreams))
+		proxy.(*ProxyTCP).mode = mode
+	}
+	proxy.(proxyInternal).setUpstreams(newUpstreamList(upstreams, balance))
+	if config.Resolver != nil {
+		proxy.(proxyInternal).getResolver().setConfig(*config.Resolver)
+	}
+	proxy.(proxyInternal).setTLS(tls)
+	proxy.(proxyInternal).setLabels(config.Labels)
+	proxy.(proxyInternal).setSessionLimits(config.IdleTimeout, config.MaxSessions)
+	return proxy, nil
+}
+
+// Prefix of the listen and upstream addresses of unix sockets, followed by the path
+const unixScheme = "unix://"
+
+// splitNetwork returns the network and the address to listen on or to dial, for
+// TCP addresses and unix socket paths.
+func splitNetwork(address string) (string, string) {
+	if strings.HasPrefix(address, unixScheme) {
+		return "unix", strings.TrimPrefix(address, unixScheme)
+	}
+	return "tcp", address
+}
+
+// usesUnixSockets reports whether the proxy listens on or dials unix sockets.
+func (config *ProxyConfig) usesUnixSockets() bool {
+	for _, address := range append([]string{config.Listen, config.Upstream}, config.Upstreams...) {
+		if network, _ := splitNetwork(address); network == "unix" {
+			return true
+		}
+	}
+	return false
+}
+
+// removeStaleSocket removes the socket file left at the path by a process which
+// stopped without removing it, so the path can be listened on again.
+func removeStaleSocket(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		// The socket is still in use.
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
+
+// wrappedConn is a connection running over another one, like a TLS connection.
+type wrappedConn interface {
+	unwrap() net.Conn
+}
+
+// resetOnClose makes closing a TCP connection reset it, discarding unsent data.
+// Other connections, like unix sockets, can not be reset and are closed
+// gracefully instead.
+func resetOnClose(conn interface{}) error {
+	for {
+		wrapped, ok := conn.(wrappedConn)
+		if !ok {
+			break
+		}
+		conn = wrapped.unwrap()
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		return tcp.SetLinger(0)
+	}
+	return nil
+}
+
+func parseProtocol(value string) (string, error) {
+	switch strings.ToLower(value) {
+	case "", ProtocolTCP:
+		return ProtocolTCP, nil
+	case ProtocolUDP:
+		return ProtocolUDP, nil
+	}
+	return "", ErrInvalidProtocol
 }
 
 // Public interface common to TCP and UDP proxies
@@ -22,6 +192,8 @@ type Proxy interface {
 	Listen() string
 	Upstream() string
 	Enabled() bool
+	Protocol() string
+	Labels() map[string]string
 	Toxics() *ToxicCollection
 	Logger() *zerolog.Logger
 	Config() ProxyConfig
@@ -29,7 +201,12 @@ type Proxy interface {
 	Start() error
 	Stop()
 	Update(config ProxyConfig) error
+	Drain(timeout time.Duration) <-chan struct{}
+	Draining() bool
+	Upstreams() []UpstreamState
+	SetUpstreamDown(address string, down bool) (UpstreamState, error)
 	RemoveConnection(name string)
+	CloseConnection(name string, reset bool) error
 }
 
 // Private interface common to TCP and UDP proxies
@@ -41,11 +218,23 @@ type proxyInternal interface {
 	server()
 	startedCh() chan error
 	getConnections() *ConnectionList
+	stopDraining() bool
+	setUpstreams(upstreams *upstreamList)
+	getResolver() *resolver
+	setTLS(tls *tlsSettings)
+	setLabels(labels map[string]string)
+	setSessionLimits(idleTimeout int64, maxSessions int)
 }
 
 type ConnectionList struct {
 	list map[string]io.Closer
 	lock sync.RWMutex
+	// Clients accepted which are not done connecting yet
+	connecting int
+	// Set once the connections are closed, until the proxy starts again
+	closed bool
+	// Closed once the list is empty, while a proxy is drained
+	empty chan struct{}
 }
 
 func (c *ConnectionList) Lock() {
@@ -64,6 +253,63 @@ func (c *ConnectionList) Unlock() {
 	c.lock.Unlock()
 }
 
+// reserve counts an accepted client until release is called, once it is done
+// connecting.
+func (c *ConnectionList) reserve() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.connecting++
+}
+
+func (c *ConnectionList) release() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.connecting--
+	c.notifyEmpty()
+}
+
+// add adds both sides of the connection of a client. It returns false if the
+// connections were closed while the client was connecting.
+func (c *ConnectionList) add(name string, upstream, downstream io.Closer) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return false
+	}
+	c.list[name+"upstream"] = upstream
+	c.list[name+"downstream"] = downstream
+	return true
+}
+
+// emptied returns a channel closed once the list is empty, and no client is
+// connecting.
+func (c *ConnectionList) emptied() <-chan struct{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.empty == nil {
+		c.empty = make(chan struct{})
+	}
+	empty := c.empty
+	c.notifyEmpty()
+	return empty
+}
+
+// notifyEmpty closes the channel returned by emptied if the list is empty,
+// assumes the lock has already been taken.
+func (c *ConnectionList) notifyEmpty() {
+	if c.empty != nil && len(c.list) == 0 && c.connecting == 0 {
+		close(c.empty)
+		c.empty = nil
+	}
+}
+
+// Time the connections of a drained proxy have to finish, unless given.
+const DefaultDrainTimeout = 30 * time.Second
+
 var ErrProxyAlreadyStarted = errors.New("Proxy already started")
 
 type proxyBase struct {
@@ -73,14 +319,24 @@ type proxyBase struct {
 	listen   string
 	upstream string
 	enabled  bool
+	protocol string
+	// Forward proxy mode, empty for proxies connecting clients to their upstreams
+	mode   string
+	labels map[string]string
+	// All the upstreams, replaced when their addresses change
+	upstreams *upstreamList
+	resolver  *resolver
+	tls       *tlsSettings
 
 	started chan error
 
 	tomb        *tomb.Tomb
 	connections ConnectionList
-	toxics      *ToxicCollection
-	apiServer   *ApiServer
-	logger      *zerolog.Logger
+	// Set while the proxy is drained, until its connections are closed
+	draining  *struct{}
+	toxics    *ToxicCollection
+	apiServer *ApiServer
+	logger    *zerolog.Logger
 }
 
 func (proxy *proxyBase) Name() string {
@@ -99,6 +355,10 @@ func (proxy *proxyBase) Enabled() bool {
 	return proxy.enabled
 }
 
+func (proxy *proxyBase) Protocol() string {
+	return proxy.protocol
+}
+
 func (proxy *proxyBase) Logger() *zerolog.Logger {
 	return proxy.logger
 }
@@ -128,22 +388,145 @@ func (proxy *proxyBase) toggle(enable bool) {
 }
 
 func (proxy *proxyBase) Config() ProxyConfig {
+	upstreams := proxy.getUpstreams()
+	tls, upstreamTLS := proxy.getTLS().configs()
 	return ProxyConfig{
-		Enabled:  proxy.Enabled(),
-		Name:     proxy.Name(),
-		Listen:   proxy.Listen(),
-		Upstream: proxy.Upstream(),
+		Enabled:     proxy.Enabled(),
+		Name:        proxy.Name(),
+		Listen:      proxy.Listen(),
+		Upstream:    proxy.Upstream(),
+		Protocol:    proxy.Protocol(),
+		Labels:      proxy.Labels(),
+		Mode:        proxy.mode,
+		Upstreams:   upstreams.getAddresses(),
+		Balance:     upstreams.getBalance(),
+		Resolver:    proxy.resolver.getConfig(),
+		TLS:         tls,
+		UpstreamTLS: upstreamTLS,
 	}
 }
 
+func (proxy *proxyBase) getResolver() *resolver {
+	return proxy.resolver
+}
+
+func (base *proxyBase) getUpstreams() *upstreamList {
+	base.Lock()
+	defer base.Unlock()
+
+	return base.upstreams
+}
+
+func (base *proxyBase) getTLS() *tlsSettings {
+	base.Lock()
+	defer base.Unlock()
+
+	return base.tls
+}
+
+func (base *proxyBase) setTLS(tls *tlsSettings) {
+	base.Lock()
+	defer base.Unlock()
+
+	base.tls = tls
+}
+
+// Labels returns a copy of the labels of the proxy.
+func (base *proxyBase) Labels() map[string]string {
+	base.Lock()
+	defer base.Unlock()
+
+	return copyLabels(base.labels)
+}
+
+func (base *proxyBase) setLabels(labels map[string]string) {
+	base.Lock()
+	defer base.Unlock()
+
+	base.labels = copyLabels(labels)
+}
+
+// setSessionLimits only applies to UDP proxies, which override it.
+func (base *proxyBase) setSessionLimits(idleTimeout int64, maxSessions int) {}
+
+// setUpstreams replaces the upstreams of a proxy which is not started yet.
+func (base *proxyBase) setUpstreams(upstreams *upstreamList) {
+	base.Lock()
+	defer base.Unlock()
+
+	base.upstreams = upstreams
+	base.upstream = firstUpstream(upstreams.addresses)
+}
+
+// Upstreams returns the upstreams of the proxy, and whether they are marked down.
+func (base *proxyBase) Upstreams() []UpstreamState {
+	return base.getUpstreams().states()
+}
+
+// SetUpstreamDown marks an upstream down, or up again. New clients only connect to
+// the upstreams which are up, while the connections already open are left alone.
+func (base *proxyBase) SetUpstreamDown(address string, down bool) (UpstreamState, error) {
+	state, err := base.getUpstreams().setDown(address, down)
+	if err == nil {
+		base.logger.
+			Info().
+			Str("upstream", address).
+			Bool("down", down).
+			Msg("Marked upstream")
+	}
+	return state, err
+}
+
 func (base *proxyBase) Update(input ProxyConfig, proxy proxyInternal) error {
 	base.Lock()
 	defer base.Unlock()
 
-	if input.Listen != base.listen || input.Upstream != base.upstream {
+	mode, err := parseMode(input.Mode)
+	if err != nil {
+		return err
+	}
+	if mode != base.mode {
+		return ErrModeChanged
+	}
+	input.Mode = mode
+
+	upstreams, err := input.upstreamAddresses(base.upstreams.getAddresses())
+	if err != nil {
+		return err
+	}
+	if err := validatePortRanges(input.Listen, upstreams); err != nil {
+		return err
+	}
+	balance, err := parseBalance(input.Balance)
+	if err != nil {
+		return err
+	}
+	resolver := ResolverConfig{}
+	if input.Resolver != nil {
+		resolver = *input.Resolver
+		if err := resolver.validate(); err != nil {
+			return err
+		}
+	}
+	tls, err := newTLSSettings(input.TLS, input.UpstreamTLS)
+	if err != nil {
+		return err
+	}
+	if err := validateLabels(input.Labels); err != nil {
+		return err
+	}
+	base.labels = copyLabels(input.Labels)
+	base.upstreams.setBalance(balance)
+	base.resolver.setConfig(resolver)
+	base.tls = tls
+	proxy.setSessionLimits(input.IdleTimeout, input.MaxSessions)
+
+	if input.Listen != base.listen ||
+		!sameUpstreams(upstreams, base.upstreams.getAddresses()) {
 		stop(proxy)
 		base.listen = input.Listen
-		base.upstream = input.Upstream
+		base.upstream = firstUpstream(upstreams)
+		base.upstreams = base.upstreams.withAddresses(upstreams)
 	}
 
 	if input.Enabled != base.enabled {
@@ -159,6 +542,98 @@ func (proxy *proxyBase) RemoveConnection(name string) {
 	proxy.connections.Lock()
 	defer proxy.connections.Unlock()
 	delete(proxy.connections.list, name)
+	proxy.connections.notifyEmpty()
+}
+
+// Drain stops accepting new clients right away, and lets the open connections
+// finish. The connections still open after the timeout are closed. The returned
+// channel is closed once the proxy is drained.
+func (base *proxyBase) Drain(timeout time.Duration, proxy proxyInternal) <-chan struct{} {
+	base.Lock()
+	defer base.Unlock()
+
+	done := make(chan struct{})
+	if !base.enabled {
+		close(done)
+		return done
+	}
+	stopAccepting(proxy)
+	draining := &struct{}{}
+	base.draining = draining
+	empty := base.connections.emptied()
+
+	base.logger.
+		Info().
+		Dur("timeout", timeout).
+		Msg("Draining proxy")
+
+	go func() {
+		defer close(done)
+
+		select {
+		case <-empty:
+		case <-time.After(timeout):
+		}
+
+		base.Lock()
+		defer base.Unlock()
+
+		// The proxy was stopped, restarted or drained again in the meantime.
+		if base.draining != draining {
+			return
+		}
+		base.draining = nil
+		closeConnections(proxy)
+	}()
+	return done
+}
+
+// stopDraining ends the draining of the proxy, and reports whether it was drained.
+// Assumes the lock has already been taken.
+func (base *proxyBase) stopDraining() bool {
+	draining := base.draining != nil
+	base.draining = nil
+	return draining
+}
+
+// Draining reports whether the proxy waits for its connections to finish.
+func (base *proxyBase) Draining() bool {
+	base.Lock()
+	defer base.Unlock()
+
+	return base.draining != nil
+}
+
+// CloseConnection closes both sides of the connection of a client. With reset,
+// TCP connections are reset instead of being closed gracefully.
+func (proxy *proxyBase) CloseConnection(name string, reset bool) error {
+	proxy.connections.RLock()
+	var conns []io.Closer
+	for dir := stream.Direction(0); dir < stream.NumDirections; dir++ {
+		if conn, ok := proxy.connections.list[name+dir.String()]; ok {
+			conns = append(conns, conn)
+		}
+	}
+	proxy.connections.RUnlock()
+
+	if len(conns) == 0 {
+		return ErrConnectionNotFound
+	}
+	// Both sides are set to reset first, as the links close the other side as
+	// soon as one is closed.
+	for _, conn := range conns {
+		if reset {
+			if err := resetOnClose(conn); err != nil {
+				proxy.logger.Err(err).
+					Str("client", name).
+					Msg("Unable to setLinger(ms)")
+			}
+		}
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return nil
 }
 
 // Starts a proxy, assumes the lock has already been taken.
@@ -166,6 +641,11 @@ func start(proxy proxyInternal) error {
 	if proxy.Enabled() {
 		return ErrProxyAlreadyStarted
 	}
+	// Connections left from draining stay open.
+	proxy.stopDraining()
+	proxy.getConnections().Lock()
+	proxy.getConnections().closed = false
+	proxy.getConnections().Unlock()
 
 	proxy.setTomb(&tomb.Tomb{}) // Reset tomb, from previous starts/stops
 	go proxy.server()
@@ -177,16 +657,28 @@ func start(proxy proxyInternal) error {
 
 // Stops a proxy, assumes the lock has already been taken.
 func stop(proxy proxyInternal) {
-	if !proxy.Enabled() {
+	if proxy.Enabled() {
+		stopAccepting(proxy)
+	} else if !proxy.stopDraining() {
 		return
 	}
+	closeConnections(proxy)
+}
+
+// Stops accepting new clients, assumes the lock has already been taken.
+func stopAccepting(proxy proxyInternal) {
 	proxy.toggle(false)
 
 	proxy.getTomb().Killf("Shutting down from stop()")
 	proxy.getTomb().Wait() // Wait until we stop accepting new connections
+}
 
+// Closes the open connections of a stopped proxy, assumes the lock has already
+// been taken.
+func closeConnections(proxy proxyInternal) {
 	proxy.getConnections().Lock()
 	defer proxy.getConnections().Unlock()
+	proxy.getConnections().closed = true
 	for _, conn := range proxy.getConnections().list {
 		conn.Close()
 	}
@@ -5,6 +5,8 @@ import (
 	"encoding/hex"
 	"io"
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -211,3 +213,176 @@ func TestRestartFailedToStartProxy(t *testing.T) {
 		AssertProxyUp(t, proxy.Listen(), false)
 	})
 }
+
+func TestProxyDrain(t *testing.T) {
+	WithTCPProxy(t, func(conn net.Conn, response chan []byte, proxy toxiproxy.Proxy) {
+		_, err := conn.Write([]byte("hello "))
+		if err != nil {
+			t.Error("Failed writing to TCP server", err)
+		}
+
+		drained := proxy.Drain(time.Second)
+		AssertProxyUp(t, proxy.Listen(), false)
+		if !proxy.Draining() {
+			t.Fatal("Expected proxy to be draining")
+		}
+
+		// Open connections still go through.
+		_, err = conn.Write([]byte("world"))
+		if err != nil {
+			t.Error("Failed writing to TCP server", err)
+		}
+		conn.Close()
+
+		resp := <-response
+		if !bytes.Equal(resp, []byte("hello world")) {
+			t.Error("Server didn't read correct bytes from client", string(resp))
+		}
+
+		select {
+		case <-drained:
+		case <-time.After(500 * time.Millisecond):
+			t.Fatal("Expected proxy to be drained once its connections are closed")
+		}
+		if proxy.Draining() {
+			t.Fatal("Expected proxy to be drained")
+		}
+	})
+}
+
+func TestProxyDrainTimeout(t *testing.T) {
+	WithTCPProxy(t, func(conn net.Conn, response chan []byte, proxy toxiproxy.Proxy) {
+		start := time.Now()
+		<-proxy.Drain(50 * time.Millisecond)
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Fatal("Expected proxy to wait for the timeout, drained after", elapsed)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err := conn.Read(make([]byte, 1))
+		if err != io.EOF {
+			t.Fatal("Expected connection to be closed after the timeout, got", err)
+		}
+	})
+}
+
+func TestProxyStopWhileDraining(t *testing.T) {
+	WithTCPProxy(t, func(conn net.Conn, response chan []byte, proxy toxiproxy.Proxy) {
+		drained := proxy.Drain(time.Minute)
+		proxy.Stop()
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err := conn.Read(make([]byte, 1))
+		if err != io.EOF {
+			t.Fatal("Expected connection to be closed when stopped, got", err)
+		}
+		if proxy.Draining() {
+			t.Fatal("Expected a stopped proxy to not be draining")
+		}
+
+		// The drain gives up once the connections are closed.
+		select {
+		case <-drained:
+		case <-time.After(500 * time.Millisecond):
+			t.Fatal("Expected drain to end once the proxy is stopped")
+		}
+	})
+}
+
+func TestProxyUnixSockets(t *testing.T) {
+	dir := t.TempDir()
+	upstreamPath := filepath.Join(dir, "upstream.sock")
+	proxyPath := filepath.Join(dir, "proxy.sock")
+
+	ln, err := net.Listen("unix", upstreamPath)
+	if err != nil {
+		t.Fatal("Failed to create unix socket server", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	// A socket file left behind by a previous process is replaced.
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: proxyPath, Net: "unix"})
+	if err != nil {
+		t.Fatal("Failed to create stale unix socket", err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	proxy := NewTestProxy("test", "unix://"+upstreamPath)
+	err = proxy.Update(toxiproxy.ProxyConfig{
+		Name:     "test",
+		Listen:   "unix://" + proxyPath,
+		Upstream: "unix://" + upstreamPath,
+		Enabled:  true,
+	})
+	if err != nil {
+		t.Fatal("Failed to start proxy on a unix socket", err)
+	}
+	if proxy.Listen() != "unix://"+proxyPath {
+		t.Fatal("Expected proxy to listen on the unix socket, got", proxy.Listen())
+	}
+
+	conn, err := net.Dial("unix", proxyPath)
+	if err != nil {
+		t.Fatal("Unable to dial proxy", err)
+	}
+	msg := []byte("hello world")
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal("Failed writing to proxy", err)
+	}
+	resp := make([]byte, len(msg))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(conn, resp); err != nil || !bytes.Equal(resp, msg) {
+		t.Fatalf("Expected echo through the proxy, got %q: %v", resp, err)
+	}
+
+	// Unix sockets can not be reset, the connection is closed instead.
+	_, err = proxy.Toxics().AddToxicJson(
+		bytes.NewBufferString(`{"type": "reset_peer", "attributes": {"timeout": 0}}`),
+	)
+	if err != nil {
+		t.Fatal("Failed to add toxic", err)
+	}
+	conn.Close()
+	conn, err = net.Dial("unix", proxyPath)
+	if err != nil {
+		t.Fatal("Unable to dial proxy", err)
+	}
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal("Failed writing to proxy", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(resp); err != io.EOF {
+		t.Fatal("Expected connection to be closed, got", err)
+	}
+	conn.Close()
+
+	proxy.Stop()
+	if _, err := os.Stat(proxyPath); !os.IsNotExist(err) {
+		t.Fatal("Expected socket file to be removed once the proxy stops, got", err)
+	}
+}
+
+func TestUDPProxyRejectsUnixSockets(t *testing.T) {
+	_, err := toxiproxy.NewProxy(nil, toxiproxy.ProxyConfig{
+		Name:     "test",
+		Listen:   "unix:///tmp/toxiproxy.sock",
+		Upstream: "localhost:53",
+		Protocol: toxiproxy.ProtocolUDP,
+	})
+	if err != toxiproxy.ErrUnixSocketProtocol {
+		t.Fatal("Expected unix sockets to be rejected for UDP proxies, got", err)
+	}
+}
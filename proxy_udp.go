@@ -1,10 +1,10 @@
 package toxiproxy
 
 import (
-	"bufio"
-	"fmt"
+	"context"
 	"io"
 	"net"
+	"time"
 
 	tomb "gopkg.in/tomb.v1"
 
@@ -21,6 +21,7 @@ type ProxyUDP struct {
 	proxyBase
 
 	listener *net.UDPConn
+	sessions *udpSessionTable
 }
 
 const UDPBufferSize = 64 * 1024
@@ -42,11 +43,16 @@ func NewProxyUdp(server *ApiServer, name, listen, upstream string) Proxy {
 			name:        name,
 			listen:      listen,
 			upstream:    upstream,
+			upstreams:   newUpstreamList([]string{upstream}, BalanceRoundRobin),
+			resolver:    newResolver(),
+			tls:         &tlsSettings{},
+			protocol:    ProtocolUDP,
 			started:     make(chan error),
 			connections: ConnectionList{list: make(map[string]io.Closer)},
 			apiServer:   server,
 			logger:      &l,
 		},
+		sessions: newUDPSessionTable(),
 	}
 	proxy.toxics = NewToxicCollection(proxy)
 	return proxy
@@ -60,9 +66,38 @@ func (proxy *ProxyUDP) Start() error {
 }
 
 func (proxy *ProxyUDP) Update(input ProxyConfig) error {
+	if input.usesUnixSockets() {
+		return ErrUnixSocketProtocol
+	}
+	if input.usesPortRanges() {
+		return ErrPortRangeProtocol
+	}
+	if input.TLS != nil || input.UpstreamTLS != nil {
+		return ErrTLSProtocol
+	}
 	return proxy.proxyBase.Update(input, proxy)
 }
 
+func (proxy *ProxyUDP) Config() ProxyConfig {
+	config := proxy.proxyBase.Config()
+	config.IdleTimeout, config.MaxSessions = proxy.sessions.limits()
+	return config
+}
+
+func (proxy *ProxyUDP) setSessionLimits(idleTimeout int64, maxSessions int) {
+	proxy.sessions.setLimits(idleTimeout, maxSessions)
+}
+
+// Drain stops the proxy right away. Packets to the clients are sent from the
+// listening socket, so sessions can not outlive it.
+func (proxy *ProxyUDP) Drain(timeout time.Duration) <-chan struct{} {
+	proxy.Stop()
+
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
 func (proxy *ProxyUDP) Stop() {
 	proxy.Lock()
 	defer proxy.Unlock()
@@ -133,6 +168,9 @@ func (proxy *ProxyUDP) server() {
 	// net.Listener.
 	go proxy.freeBlocker(acceptTomb)
 
+	go proxy.expireSessions(acceptTomb)
+	defer proxy.closeSessions()
+
 	buffer := make([]byte, UDPBufferSize)
 
 	for {
@@ -156,145 +194,146 @@ func (proxy *ProxyUDP) server() {
 			return
 		}
 
-		name := remoteAddr.String()
-		proxy.connections.RLock()
-		v, ok := proxy.connections.list[name+"downstream"]
-		proxy.connections.RUnlock()
-		if ok {
-			if writer, ok := v.(io.Writer); ok {
-				writer.Write(buffer[:msglen])
-			} else {
-				panic("downstream writer")
+		session := proxy.sessions.get(remoteAddr.String())
+		if session == nil {
+			session, err = proxy.newSession(remoteAddr)
+			if err != nil {
+				continue
 			}
-			continue
 		}
 
-		proxy.logger.
-			Info().
-			Str("protocol", "udp").
-			Str("client", remoteAddr.String()).
-			Msg("Accepted client")
-
-		upstreamAddr, err := net.ResolveUDPAddr("udp", proxy.upstream)
-		if err != nil {
-			proxy.logger.
-				Err(err).
-				Str("protocol", "udp").
-				Str("client", remoteAddr.String()).
-				Msg("Unable to resolve upstream address")
-			continue
-		}
-
-		upstream, err := net.DialUDP("udp", nil, upstreamAddr)
-		if err != nil {
+		if !session.push(buffer[:msglen]) {
 			proxy.logger.
-				Err(err).
+				Debug().
 				Str("protocol", "udp").
-				Str("client", remoteAddr.String()).
-				Msg("Unable to open connection to upstream")
-			continue
+				Str("client", session.name).
+				Msg("Dropped packet from client")
 		}
+	}
+}
 
-		// It is not possible to pass downstream or upstream UDPConn as Reader or Writer,
-		// so we use two Pipes for client downstream "connection" in two directions
-		clientUpPipeReader, clientUpPipeWriter := makeBufferedPipe(UDPBufferSize)
-		clientDownPipeReader, clientDownPipeWriter := makeBufferedPipe(UDPBufferSize)
-
-		// Buffered read writer is used to ensure whole UDP packet always fits
-		// in read write buffers, futher calls to io.Copy in Links will use bufferedUpstream
-		// buffers, so UDP packet will always be written to upstream with one Write call
-		bufferedUpstreamReader := bufio.NewReaderSize(upstream, UDPBufferSize)
-		// It is impossible to use bufio.Writer here, see makeBufferedPipe(int)
-		bufferedUpstreamWriter := NewBufferedWriter(upstream, UDPBufferSize)
+// newSession dials the upstream for a new client and starts the links between
+// them. Sessions over the configured limit are evicted.
+func (proxy *ProxyUDP) newSession(remoteAddr *net.UDPAddr) (*udpSession, error) {
+	proxy.logger.
+		Info().
+		Str("protocol", "udp").
+		Str("client", remoteAddr.String()).
+		Msg("Accepted client")
 
-		go proxy.clientWrite(remoteAddr, clientDownPipeReader)
+	upstreamAddr, err := proxy.resolveUpstream()
+	if err != nil {
+		proxy.logger.
+			Err(err).
+			Str("protocol", "udp").
+			Str("client", remoteAddr.String()).
+			Msg("Unable to resolve upstream address")
+		return nil, err
+	}
 
-		proxy.connections.Lock()
-		proxy.connections.list[name+"upstream"] = upstream
-		proxy.connections.list[name+"downstream"] = clientUpPipeWriter
-		proxy.connections.Unlock()
+	upstream, err := net.DialUDP("udp", nil, upstreamAddr)
+	if err != nil {
+		proxy.logger.
+			Err(err).
+			Str("protocol", "udp").
+			Str("client", remoteAddr.String()).
+			Msg("Unable to open connection to upstream")
+		return nil, err
+	}
 
-		// Links will never be closed themselves, because UDP has no living "connection"
-		// the only way is to close unused links after some time of inactivity
-		// TODO make some timeout for unused connections
-		proxy.toxics.StartLink(proxy.apiServer, name+"upstream", clientUpPipeReader, bufferedUpstreamWriter, stream.Upstream)
-		proxy.toxics.StartLink(proxy.apiServer, name+"downstream", bufferedUpstreamReader, clientDownPipeWriter, stream.Downstream)
+	session := newUDPSession(remoteAddr, upstream)
+	for _, evicted := range proxy.sessions.add(session) {
+		proxy.evictSession(evicted, udpSessionEvictedLimit)
+	}
 
-		clientUpPipeWriter.Write(buffer[:msglen])
+	client := &udpClientWriter{
+		listener: proxy.listener,
+		session:  session,
+		onClose:  proxy.endSession,
 	}
+
+	name := session.name
+	proxy.connections.Lock()
+	proxy.connections.list[name+"upstream"] = upstream
+	proxy.connections.list[name+"downstream"] = session
+	proxy.connections.Unlock()
+
+	// Both links preserve packet boundaries: the session returns one client packet
+	// per read, and a connected UDP socket returns one upstream packet per read.
+	// Links are closed when the session is idle for too long or evicted.
+	proxy.toxics.StartDatagramLink(
+		proxy.apiServer, name+"upstream", session, upstream, stream.Upstream)
+	proxy.toxics.StartDatagramLink(
+		proxy.apiServer, name+"downstream", upstream, client, stream.Downstream)
+
+	return session, nil
 }
 
-func (proxy *ProxyUDP) clientWrite(clientAddr *net.UDPAddr, reader io.Reader) {
+// expireSessions periodically evicts sessions which are idle for longer than the
+// idle timeout, until the proxy is stopped.
+func (proxy *ProxyUDP) expireSessions(acceptTomb *tomb.Tomb) {
 	for {
-		buffer := make([]byte, UDPBufferSize)
-		msglen, err := reader.Read(buffer)
-		if err == io.EOF {
+		select {
+		case <-acceptTomb.Dying():
 			return
+		case <-time.After(proxy.sessions.checkInterval()):
 		}
-		if err != nil {
-			panic(err)
-		}
-		_, err = proxy.listener.WriteToUDP(buffer[:msglen], clientAddr)
-		if err != nil {
-			panic(err)
+
+		for _, session := range proxy.sessions.expired(time.Now()) {
+			proxy.evictSession(session, udpSessionEvictedIdle)
 		}
 	}
 }
 
-func makeBufferedPipe(size int) (io.Reader, io.WriteCloser) {
-	reader, writer := io.Pipe()
-	bufferedWriter := NewBufferedWriter(writer, size)
-	// It is impossible to use bufio.Writer here to make use of ReadFrom,
-	// beacuse bufio.Writer writes data to the wrapped io.Writer only
-	// when its internal buffer is full
-	bufferedReader := bufio.NewReaderSize(reader, size)
-	return bufferedReader, bufferedWriter
-}
+func (proxy *ProxyUDP) evictSession(session *udpSession, reason string) {
+	proxy.logger.
+		Info().
+		Str("protocol", "udp").
+		Str("client", session.name).
+		Str("reason", reason).
+		Msg("Evicted client session")
+
+	session.Close()
 
-func NewBufferedWriter(w io.WriteCloser, size int) io.WriteCloser {
-	return &WriteBufferCloser{
-		wr:   w,
-		size: size,
+	server := proxy.apiServer
+	if server != nil && server.Metrics.proxyMetricsEnabled() {
+		server.Metrics.ProxyMetrics.UDPSessionEvictionsTotal.
+			WithLabelValues(reason, proxy.Name(), proxy.Listen(), proxy.Upstream()).Inc()
 	}
 }
 
-// Implements ReaderFrom interface with custom sized buffer,
-// but writes data after each Read call, opposed to bufio.Writer,
-// which waits till buffer is full
-type WriteBufferCloser struct {
-	wr   io.WriteCloser
-	size int
+// endSession is called when the downstream link of a session is closed.
+func (proxy *ProxyUDP) endSession(session *udpSession) {
+	proxy.sessions.remove(session)
+	session.Close()
 }
 
-func (wb *WriteBufferCloser) Write(p []byte) (int, error) {
-	return wb.wr.Write(p)
+func (proxy *ProxyUDP) closeSessions() {
+	for _, session := range proxy.sessions.clear() {
+		session.Close()
+	}
 }
 
-func (wb *WriteBufferCloser) ReadFrom(r io.Reader) (n int64, err error) {
-	buffer := make([]byte, wb.size)
-	for {
-		nr, err := r.Read(buffer)
+// resolveUpstream resolves the upstreams in the order chosen for a new client,
+// until one of them resolves.
+func (proxy *ProxyUDP) resolveUpstream() (*net.UDPAddr, error) {
+	addresses := proxy.upstreams.order()
+	if len(addresses) == 0 {
+		return nil, ErrNoUpstreamAvailable
+	}
+
+	var err error
+	for _, address := range addresses {
+		var addrs []string
+		addrs, err = proxy.resolver.resolve(context.Background(), address, nil)
 		if err != nil {
-			break
-		}
-		if nr == 0 {
 			continue
 		}
-		nw, err := wb.wr.Write(buffer[:nr])
-		n += int64(nw)
-		if err != nil {
-			return n, err
-		}
-		if nw != nr {
-			return n, fmt.Errorf("Failed to write all read buffer")
+		var upstreamAddr *net.UDPAddr
+		upstreamAddr, err = net.ResolveUDPAddr("udp", addrs[0])
+		if err == nil {
+			return upstreamAddr, nil
 		}
 	}
-	if err == io.EOF {
-		err = nil
-	}
-	return n, err
-}
-
-func (wb *WriteBufferCloser) Close() error {
-	return wb.wr.Close()
+	return nil, err
 }
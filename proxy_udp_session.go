@@ -0,0 +1,255 @@
+package toxiproxy
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	udpSessionEvictedIdle  = "idle"
+	udpSessionEvictedLimit = "limit"
+)
+
+// Number of packets from a client which can wait for the upstream link to read
+// them. Like a socket receive buffer, packets over the limit are dropped.
+const udpSessionQueueSize = 64
+
+// udpSession holds the state of a single client of a UDP proxy. UDP has no
+// notion of a connection, so a session is identified by the client address and
+// lives until it is idle for too long, gets evicted or one of its links closes.
+//
+// The session is the source of the upstream link: every Read returns exactly one
+// packet received from the client.
+type udpSession struct {
+	// UnixNano of the last packet seen in either direction.
+	// Kept first in the struct for 64-bit alignment of atomic operations.
+	lastSeen int64
+
+	name     string
+	created  time.Time
+	client   *net.UDPAddr
+	upstream *net.UDPConn
+	packets  chan []byte
+	done     chan struct{}
+
+	closeOnce sync.Once
+}
+
+func newUDPSession(client *net.UDPAddr, upstream *net.UDPConn) *udpSession {
+	session := &udpSession{
+		name:     client.String(),
+		created:  time.Now(),
+		client:   client,
+		upstream: upstream,
+		packets:  make(chan []byte, udpSessionQueueSize),
+		done:     make(chan struct{}),
+	}
+	session.touch()
+	return session
+}
+
+func (s *udpSession) touch() {
+	atomic.StoreInt64(&s.lastSeen, time.Now().UnixNano())
+}
+
+func (s *udpSession) idle(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&s.lastSeen)))
+}
+
+// push queues a copy of a packet received from the client. Returns false if the
+// packet was dropped because the queue is full or the session is closed.
+func (s *udpSession) push(packet []byte) bool {
+	data := make([]byte, len(packet))
+	copy(data, packet)
+
+	select {
+	case s.packets <- data:
+		s.touch()
+		return true
+	case <-s.done:
+		return false
+	default:
+		return false
+	}
+}
+
+// Read returns the next packet received from the client, or io.EOF once the
+// session is closed.
+func (s *udpSession) Read(p []byte) (int, error) {
+	select {
+	case packet := <-s.packets:
+		return copy(p, packet), nil
+	case <-s.done:
+		return 0, io.EOF
+	}
+}
+
+// Close tears down both links of the session. Closing the session ends the
+// upstream link, closing the upstream socket ends the downstream link.
+func (s *udpSession) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.upstream.Close()
+	})
+	return nil
+}
+
+// udpClientWriter sends the packets of the downstream link back to the client.
+type udpClientWriter struct {
+	listener *net.UDPConn
+	session  *udpSession
+	onClose  func(*udpSession)
+}
+
+func (w *udpClientWriter) Write(p []byte) (int, error) {
+	w.session.touch()
+	return w.listener.WriteToUDP(p, w.session.client)
+}
+
+func (w *udpClientWriter) Close() error {
+	w.onClose(w.session)
+	return nil
+}
+
+// udpSessionTable tracks the active sessions of a UDP proxy by client address.
+type udpSessionTable struct {
+	sync.Mutex
+
+	sessions    map[string]*udpSession
+	idleTimeout time.Duration
+	maxSessions int
+}
+
+func newUDPSessionTable() *udpSessionTable {
+	return &udpSessionTable{
+		sessions: make(map[string]*udpSession),
+	}
+}
+
+// setLimits updates the idle timeout (in milliseconds) and the maximum number of
+// concurrent sessions. Zero or negative values disable the limit.
+func (t *udpSessionTable) setLimits(idleTimeout int64, maxSessions int) {
+	t.Lock()
+	defer t.Unlock()
+
+	if idleTimeout < 0 {
+		idleTimeout = 0
+	}
+	if maxSessions < 0 {
+		maxSessions = 0
+	}
+	t.idleTimeout = time.Duration(idleTimeout) * time.Millisecond
+	t.maxSessions = maxSessions
+}
+
+func (t *udpSessionTable) limits() (int64, int) {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.idleTimeout.Milliseconds(), t.maxSessions
+}
+
+func (t *udpSessionTable) get(name string) *udpSession {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.sessions[name]
+}
+
+func (t *udpSessionTable) count() int {
+	t.Lock()
+	defer t.Unlock()
+
+	return len(t.sessions)
+}
+
+// add stores a new session. If the table is full, the oldest sessions are removed
+// from the table and returned to be closed.
+func (t *udpSessionTable) add(session *udpSession) []*udpSession {
+	t.Lock()
+	defer t.Unlock()
+
+	var evicted []*udpSession
+	for t.maxSessions > 0 && len(t.sessions) >= t.maxSessions {
+		oldest := t.oldest()
+		delete(t.sessions, oldest.name)
+		evicted = append(evicted, oldest)
+	}
+	t.sessions[session.name] = session
+	return evicted
+}
+
+// remove deletes the session from the table, unless it has already been
+// replaced by a newer session for the same client.
+func (t *udpSessionTable) remove(session *udpSession) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.sessions[session.name] != session {
+		return false
+	}
+	delete(t.sessions, session.name)
+	return true
+}
+
+// expired removes and returns every session idle for longer than the idle timeout.
+func (t *udpSessionTable) expired(now time.Time) []*udpSession {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.idleTimeout <= 0 {
+		return nil
+	}
+
+	var expired []*udpSession
+	for name, session := range t.sessions {
+		if session.idle(now) >= t.idleTimeout {
+			delete(t.sessions, name)
+			expired = append(expired, session)
+		}
+	}
+	return expired
+}
+
+// clear removes and returns all the sessions.
+func (t *udpSessionTable) clear() []*udpSession {
+	t.Lock()
+	defer t.Unlock()
+
+	sessions := make([]*udpSession, 0, len(t.sessions))
+	for _, session := range t.sessions {
+		sessions = append(sessions, session)
+	}
+	t.sessions = make(map[string]*udpSession)
+	return sessions
+}
+
+// checkInterval returns how often idle sessions should be looked for.
+func (t *udpSessionTable) checkInterval() time.Duration {
+	t.Lock()
+	defer t.Unlock()
+
+	interval := t.idleTimeout / 2
+	if interval <= 0 || interval > time.Second {
+		return time.Second
+	}
+	if interval < 10*time.Millisecond {
+		return 10 * time.Millisecond
+	}
+	return interval
+}
+
+// oldest returns the session created first, assumes the lock has already been
+// taken and the table is not empty.
+func (t *udpSessionTable) oldest() *udpSession {
+	var oldest *udpSession
+	for _, session := range t.sessions {
+		if oldest == nil || session.created.Before(oldest.created) {
+			oldest = session
+		}
+	}
+	return oldest
+}
@@ -0,0 +1,155 @@
+package toxiproxy
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/testhelper"
+)
+
+func newTestUDPSession(t *testing.T, port int) *udpSession {
+	t.Helper()
+
+	upstream, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9})
+	if err != nil {
+		t.Fatal("Failed to dial udp:", err)
+	}
+	client := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+	return newUDPSession(client, upstream)
+}
+
+func TestUDPSessionTableEvictsOldest(t *testing.T) {
+	table := newUDPSessionTable()
+	table.setLimits(0, 2)
+
+	first := newTestUDPSession(t, 10001)
+	second := newTestUDPSession(t, 10002)
+	third := newTestUDPSession(t, 10003)
+
+	table.add(first)
+	time.Sleep(5 * time.Millisecond)
+	table.add(second)
+	time.Sleep(5 * time.Millisecond)
+	first.touch()
+
+	// The oldest session is evicted, even though it was active last.
+	evicted := table.add(third)
+	if len(evicted) != 1 || evicted[0] != first {
+		t.Fatalf("Expected the oldest session to be evicted, got %v", evicted)
+	}
+	if table.count() != 2 {
+		t.Fatalf("Expected 2 sessions in the table, got %d", table.count())
+	}
+	if table.get(second.name) != second || table.get(third.name) != third {
+		t.Fatal("Expected the most recent sessions to stay in the table")
+	}
+}
+
+func TestUDPSessionTableExpired(t *testing.T) {
+	table := newUDPSessionTable()
+	session := newTestUDPSession(t, 10001)
+	table.add(session)
+
+	if expired := table.expired(time.Now().Add(time.Hour)); len(expired) != 0 {
+		t.Fatal("Expected sessions to never expire without an idle timeout")
+	}
+
+	table.setLimits(100, 0)
+	if expired := table.expired(time.Now()); len(expired) != 0 {
+		t.Fatal("Expected active session not to expire")
+	}
+
+	expired := table.expired(time.Now().Add(100 * time.Millisecond))
+	if len(expired) != 1 || expired[0] != session {
+		t.Fatalf("Expected idle session to expire, got %v", expired)
+	}
+	if table.count() != 0 {
+		t.Fatal("Expected expired session to be removed from the table")
+	}
+}
+
+func TestUDPSessionTableRemoveReplaced(t *testing.T) {
+	table := newUDPSessionTable()
+	old := newTestUDPSession(t, 10001)
+	replacement := newTestUDPSession(t, 10001)
+
+	table.add(old)
+	table.add(replacement)
+
+	if table.remove(old) {
+		t.Fatal("Expected replaced session not to be removed")
+	}
+	if table.get(replacement.name) != replacement {
+		t.Fatal("Expected replacement session to stay in the table")
+	}
+	if !table.remove(replacement) {
+		t.Fatal("Expected session to be removed")
+	}
+}
+
+func TestUDPProxyIdleSessionExpires(t *testing.T) {
+	testhelper.WithUDPServer(t, func(upstream string) {
+		proxy, err := NewProxy(nil, ProxyConfig{
+			Name:        "test",
+			Listen:      "localhost:0",
+			Upstream:    upstream,
+			Protocol:    ProtocolUDP,
+			IdleTimeout: 50,
+		})
+		if err != nil {
+			t.Fatal("Failed to create proxy:", err)
+		}
+		err = proxy.Start()
+		if err != nil {
+			t.Fatal("Failed to start proxy:", err)
+		}
+		defer proxy.Stop()
+
+		raddr, err := net.ResolveUDPAddr("udp", proxy.Listen())
+		if err != nil {
+			t.Fatal("Failed to resolve proxy listen udp addr:", err)
+		}
+		conn, err := net.DialUDP("udp", nil, raddr)
+		if err != nil {
+			t.Fatal("Failed to dial udp proxy:", err)
+		}
+		defer conn.Close()
+
+		sessions := proxy.(*ProxyUDP).sessions
+		for i := 0; i < 2; i++ {
+			msg := []byte("hello world")
+			_, err = conn.Write(msg)
+			if err != nil {
+				t.Fatal("Failed writing to UDP proxy", err)
+			}
+
+			buf := make([]byte, 1024)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, err := conn.Read(buf)
+			if err != nil || !bytes.Equal(buf[:n], msg) {
+				t.Fatalf("Proxy didn't response same bytes; err: %v, response: %s", err, buf[:n])
+			}
+			if sessions.count() != 1 {
+				t.Fatalf("Expected 1 session, got %d", sessions.count())
+			}
+
+			err = testhelper.TimeoutAfter(time.Second, func() {
+				connections := proxy.(*ProxyUDP).getConnections()
+				for {
+					connections.RLock()
+					open := len(connections.list)
+					connections.RUnlock()
+					if open == 0 && sessions.count() == 0 {
+						return
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			})
+			if err != nil {
+				t.Fatal("Expected idle session and its links to be closed:", err)
+			}
+		}
+	})
+}
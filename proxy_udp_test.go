@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/Shopify/toxiproxy/v2"
 )
@@ -50,3 +51,114 @@ func TestUDPProxyBigMessage(t *testing.T) {
 		}
 	})
 }
+
+func TestUDPProxyPreservesDatagrams(t *testing.T) {
+	WithUDPProxy(t, func(conn *net.UDPConn, proxy toxiproxy.Proxy) {
+		_, err := proxy.Toxics().AddToxicJson(
+			bytes.NewBufferString(`{"type": "latency", "attributes": {"latency": 10}}`),
+		)
+		if err != nil {
+			t.Fatal("Failed to add latency toxic to UDP proxy", err)
+		}
+
+		msgs := [][]byte{
+			[]byte("first"),
+			bytes.Repeat([]byte("second"), 1000),
+			[]byte("third"),
+		}
+		for _, msg := range msgs {
+			_, err := conn.Write(msg)
+			if err != nil {
+				t.Fatal("Failed writing to UDP proxy", err)
+			}
+		}
+
+		buf := make([]byte, 64*1024)
+		for _, msg := range msgs {
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatal("Unexpected error on read from UDP proxy", err)
+			}
+			if !bytes.Equal(buf[:n], msg) {
+				t.Fatalf("Proxy didn't preserve datagram; expected %d bytes, got %d", len(msg), n)
+			}
+		}
+	})
+}
+
+func TestUDPProxyRejectsStreamToxics(t *testing.T) {
+	WithUDPProxy(t, func(conn *net.UDPConn, proxy toxiproxy.Proxy) {
+		streamToxics := []string{"slicer", "bandwidth", "limit_data", "reset_peer", "rewrite"}
+		for _, toxicType := range streamToxics {
+			_, err := proxy.Toxics().AddToxicJson(
+				bytes.NewBufferString(`{"type": "` + toxicType + `"}`),
+			)
+			if err == nil {
+				t.Fatalf("Expected %s toxic to be rejected on UDP proxy", toxicType)
+			}
+
+			expected := "toxic type does not preserve packet boundaries and can not be used " +
+				"on udp proxies: " + toxicType
+			if err.Error() != expected {
+				t.Fatalf("Unexpected error adding %s toxic: %v", toxicType, err)
+			}
+		}
+
+		if len(proxy.Toxics().GetToxicArray()) != 0 {
+			t.Fatal("Expected no toxics to be added to UDP proxy")
+		}
+	})
+}
+
+func TestUDPProxyCorruptToxic(t *testing.T) {
+	WithUDPProxy(t, func(conn *net.UDPConn, proxy toxiproxy.Proxy) {
+		_, err := proxy.Toxics().AddToxicJson(bytes.NewBufferString(`{"type": "corrupt",` +
+			`"attributes": {"probability": 1, "per": "chunk", "offsets": "chunk",` +
+			`"windows": [{"start": 0, "end": 1}]}}`))
+		if err != nil {
+			t.Fatal("Failed to add corrupt toxic to UDP proxy", err)
+		}
+
+		msg := []byte("hello world")
+		for i := 0; i < 3; i++ {
+			_, err := conn.Write(msg)
+			if err != nil {
+				t.Fatal("Failed writing to UDP proxy", err)
+			}
+
+			buf := make([]byte, 1024)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatal("Unexpected error on read from UDP proxy", err)
+			}
+			if n != len(msg) || buf[0] == msg[0] || !bytes.Equal(buf[1:n], msg[1:]) {
+				t.Fatalf("Expected only the first byte of every datagram to be corrupted, got %q", buf[:n])
+			}
+		}
+	})
+}
+
+func TestUDPProxyInvalidUpdateKeepsSessionLimits(t *testing.T) {
+	proxy := NewTestUDPProxy("test", "localhost:20001")
+
+	config := proxy.Config()
+	config.IdleTimeout = 1000
+	config.MaxSessions = 10
+	config.Balance = "fastest"
+	if proxy.Update(config) == nil {
+		t.Fatal("Expected an invalid balance to be rejected")
+	}
+	if config := proxy.Config(); config.IdleTimeout != 0 || config.MaxSessions != 0 {
+		t.Fatalf("Expected an invalid update to leave the session limits alone, got %+v", config)
+	}
+
+	config.Balance = ""
+	if err := proxy.Update(config); err != nil {
+		t.Fatal("Failed to update proxy", err)
+	}
+	if config := proxy.Config(); config.IdleTimeout != 1000 || config.MaxSessions != 10 {
+		t.Fatalf("Expected the session limits to be updated, got %+v", config)
+	}
+}
@@ -0,0 +1,149 @@
+package toxiproxy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// ResolverConfig controls how a proxy resolves the hostnames of its upstreams.
+type ResolverConfig struct {
+	// Milliseconds resolved addresses are cached for, 0 resolves them for every
+	// new client
+	TTL int64 `json:"ttl"`
+	// IP addresses hostnames resolve to, without asking the system resolver
+	Hosts map[string]string `json:"hosts,omitempty"`
+}
+
+func (config *ResolverConfig) validate() error {
+	if config.TTL < 0 {
+		return ErrInvalidResolver
+	}
+	for _, address := range config.Hosts {
+		if net.ParseIP(address) == nil {
+			return ErrInvalidResolver
+		}
+	}
+	return nil
+}
+
+type resolvedHost struct {
+	addrs   []string
+	expires time.Time
+}
+
+// resolver resolves the upstream hostnames of a proxy, and caches the addresses
+// for the TTL of its config.
+type resolver struct {
+	sync.Mutex
+
+	config ResolverConfig
+	cache  map[string]resolvedHost
+	// Incremented with every new config, so lookups started before do not fill
+	// the cache of the new one.
+	generation int
+}
+
+func newResolver() *resolver {
+	return &resolver{cache: make(map[string]resolvedHost)}
+}
+
+// setConfig replaces the config of the resolver, forgetting the cached addresses.
+func (r *resolver) setConfig(config ResolverConfig) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.config = config
+	r.cache = make(map[string]resolvedHost)
+	r.generation++
+}
+
+// getConfig returns the config of the resolver, or nil when it is the default one.
+func (r *resolver) getConfig() *ResolverConfig {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.config.TTL == 0 && len(r.config.Hosts) == 0 {
+		return nil
+	}
+	config := ResolverConfig{TTL: r.config.TTL}
+	if r.config.Hosts != nil {
+		// Copied, so decoding an update into the config leaves the resolver alone.
+		config.Hosts = make(map[string]string, len(r.config.Hosts))
+		for host, address := range r.config.Hosts {
+			config.Hosts[host] = address
+		}
+	}
+	return &config
+}
+
+// lookup returns the addresses of the host from the static hosts, from the cache,
+// or from the system resolver, in that order.
+func (r *resolver) lookup(ctx context.Context, host string) ([]string, error) {
+	r.Lock()
+	if address, ok := r.config.Hosts[host]; ok {
+		r.Unlock()
+		return []string{address}, nil
+	}
+	if cached, ok := r.cache[host]; ok && time.Now().Before(cached.expires) {
+		r.Unlock()
+		return cached.addrs, nil
+	}
+	ttl := time.Duration(r.config.TTL) * time.Millisecond
+	generation := r.generation
+	r.Unlock()
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl > 0 {
+		r.Lock()
+		if generation == r.generation {
+			r.cache[host] = resolvedHost{addrs: addrs, expires: time.Now().Add(ttl)}
+		}
+		r.Unlock()
+	}
+	return addrs, nil
+}
+
+// resolve returns the addresses to dial for an upstream. The resolve toxics of the
+// client run first, the hostname is only looked up when none of them resolved it,
+// so hostnames the system cannot resolve can be redirected.
+func (r *resolver) resolve(
+	ctx context.Context,
+	address string,
+	resolves []toxics.ResolveToxic,
+) ([]string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" || net.ParseIP(host) != nil {
+		return []string{address}, nil
+	}
+
+	var addrs []string
+	for _, toxic := range resolves {
+		addrs, err = toxic.Resolve(ctx, host, addrs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if addrs == nil {
+		addrs, err = r.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dial := make([]string, len(addrs))
+	for i, addr := range addrs {
+		dial[i] = net.JoinHostPort(addr, port)
+	}
+	return dial, nil
+}
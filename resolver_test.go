@@ -0,0 +1,92 @@
+package toxiproxy
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+func TestResolverAddresses(t *testing.T) {
+	r := newResolver()
+	r.setConfig(ResolverConfig{Hosts: map[string]string{"db.internal": "10.0.0.1"}})
+
+	testCases := []struct {
+		address  string
+		resolves []toxics.ResolveToxic
+		expected string
+	}{
+		{"db.internal:5432", nil, "10.0.0.1:5432"},
+		{"127.0.0.1:5432", []toxics.ResolveToxic{&toxics.DNSNXDomainToxic{}}, "127.0.0.1:5432"},
+		{"[::1]:5432", nil, "[::1]:5432"},
+		{
+			"db.internal:5432",
+			[]toxics.ResolveToxic{&toxics.DNSRedirectToxic{Address: "::2"}},
+			"[::2]:5432",
+		},
+		// Redirected hostnames are not looked up, they do not need to exist.
+		{
+			"missing.invalid:5432",
+			[]toxics.ResolveToxic{&toxics.DNSRedirectToxic{Address: "10.0.0.2"}},
+			"10.0.0.2:5432",
+		},
+	}
+
+	for _, tc := range testCases {
+		addrs, err := r.resolve(context.Background(), tc.address, tc.resolves)
+		if err != nil {
+			t.Fatalf("Failed to resolve %s: %v", tc.address, err)
+		}
+		if strings.Join(addrs, ",") != tc.expected {
+			t.Fatalf("Expected %s to resolve to %s, got %v", tc.address, tc.expected, addrs)
+		}
+	}
+
+	_, err := r.resolve(
+		context.Background(),
+		"db.internal:5432",
+		[]toxics.ResolveToxic{&toxics.DNSNXDomainToxic{}},
+	)
+	if err == nil {
+		t.Fatal("Expected resolve toxics to fail the resolution")
+	}
+}
+
+func TestResolverCache(t *testing.T) {
+	r := newResolver()
+	r.setConfig(ResolverConfig{TTL: 50})
+
+	_, err := r.lookup(context.Background(), "localhost")
+	if err != nil {
+		t.Fatal("Failed to resolve localhost", err)
+	}
+	cached, ok := r.cache["localhost"]
+	if !ok || time.Until(cached.expires) > 50*time.Millisecond {
+		t.Fatalf("Expected localhost to be cached for the TTL, got %+v", cached)
+	}
+
+	r.setConfig(ResolverConfig{})
+	if len(r.cache) != 0 {
+		t.Fatal("Expected a new config to clear the cache")
+	}
+	_, err = r.lookup(context.Background(), "localhost")
+	if err != nil {
+		t.Fatal("Failed to resolve localhost", err)
+	}
+	if len(r.cache) != 0 {
+		t.Fatal("Expected addresses to not be cached without a TTL")
+	}
+}
+
+func TestResolverConfigValidation(t *testing.T) {
+	for _, config := range []ResolverConfig{
+		{TTL: -1},
+		{Hosts: map[string]string{"db.internal": "db.other"}},
+	} {
+		if config.validate() == nil {
+			t.Errorf("Expected resolver config %+v to be rejected", config)
+		}
+	}
+}
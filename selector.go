@@ -0,0 +1,131 @@
+package toxiproxy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Selector selects proxies by their labels. It is parsed from comma-separated
+// requirements which must all be met: key=value, key!=value, key to require the
+// label and !key to exclude it. An empty selector selects all the proxies.
+type Selector []labelRequirement
+
+type labelRequirement struct {
+	key      string
+	operator string
+	value    string
+}
+
+// Operators of the label requirements of selectors.
+const (
+	selectEquals    = "="
+	selectNotEquals = "!="
+	selectExists    = ""
+	selectNotExists = "!"
+)
+
+// ParseSelector parses a selector, like team=payments,env!=production.
+func ParseSelector(value string) (Selector, error) {
+	selector := Selector{}
+	if strings.TrimSpace(value) == "" {
+		return selector, nil
+	}
+	for _, raw := range strings.Split(value, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			return nil, joinError(fmt.Errorf("empty requirement"), ErrInvalidSelector)
+		}
+
+		requirement := labelRequirement{key: raw, operator: selectExists}
+		switch {
+		case strings.Contains(raw, selectNotEquals):
+			parts := strings.SplitN(raw, selectNotEquals, 2)
+			requirement = labelRequirement{parts[0], selectNotEquals, parts[1]}
+		case strings.Contains(raw, selectEquals):
+			parts := strings.SplitN(strings.Replace(raw, "==", "=", 1), selectEquals, 2)
+			requirement = labelRequirement{parts[0], selectEquals, parts[1]}
+		case strings.HasPrefix(raw, selectNotExists):
+			requirement = labelRequirement{raw[1:], selectNotExists, ""}
+		}
+		requirement.key = strings.TrimSpace(requirement.key)
+		requirement.value = strings.TrimSpace(requirement.value)
+		if !validLabel(requirement.key, false) || !validLabel(requirement.value, true) {
+			return nil, joinError(fmt.Errorf("%q", raw), ErrInvalidSelector)
+		}
+		selector = append(selector, requirement)
+	}
+	return selector, nil
+}
+
+// Matches reports whether the labels meet all the requirements of the selector.
+func (selector Selector) Matches(labels map[string]string) bool {
+	for _, requirement := range selector {
+		value, ok := labels[requirement.key]
+		var matched bool
+		switch requirement.operator {
+		case selectEquals:
+			matched = ok && value == requirement.value
+		case selectNotEquals:
+			matched = !ok || value != requirement.value
+		case selectExists:
+			matched = ok
+		case selectNotExists:
+			matched = !ok
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// validLabel reports whether a label key or value only has letters, digits, and
+// the characters -_./ so it can be written in a selector. Keys can not be empty.
+func validLabel(label string, value bool) bool {
+	if label == "" {
+		return value
+	}
+	for _, char := range label {
+		switch {
+		case char >= 'a' && char <= 'z', char >= 'A' && char <= 'Z', char >= '0' && char <= '9':
+		case strings.ContainsRune("-_./", char):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func validateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if !validLabel(key, false) || !validLabel(value, true) {
+			return joinError(fmt.Errorf("%s=%s", key, value), ErrInvalidLabels)
+		}
+	}
+	return nil
+}
+
+// copyLabels returns a copy of the labels, or nil when there are none.
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(labels))
+	for key, value := range labels {
+		copied[key] = value
+	}
+	return copied
+}
+
+// sortedProxies returns the proxies ordered by name.
+func sortedProxies(proxies map[string]Proxy) []Proxy {
+	sorted := make([]Proxy, 0, len(proxies))
+	for _, proxy := range proxies {
+		sorted = append(sorted, proxy)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name() < sorted[j].Name()
+	})
+	return sorted
+}
@@ -0,0 +1,49 @@
+package toxiproxy
+
+import "testing"
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{"team": "payments", "env": "prod", "canary": ""}
+
+	testCases := []struct {
+		selector string
+		matched  bool
+	}{
+		{"", true},
+		{"team=payments", true},
+		{"team == payments", true},
+		{"team=search", false},
+		{"team!=search", true},
+		{"owner!=search", true},
+		{"canary", true},
+		{"canary=", true},
+		{"!canary", false},
+		{"!owner", true},
+		{"team=payments,env=prod", true},
+		{"team=payments,env!=prod", false},
+	}
+
+	for _, tc := range testCases {
+		selector, err := ParseSelector(tc.selector)
+		if err != nil {
+			t.Fatalf("Failed to parse selector %q: %v", tc.selector, err)
+		}
+		if matched := selector.Matches(labels); matched != tc.matched {
+			t.Errorf("Expected selector %q to match %v, got %v", tc.selector, tc.matched, matched)
+		}
+	}
+}
+
+func TestInvalidSelector(t *testing.T) {
+	for _, selector := range []string{
+		"team=payments,",
+		"=payments",
+		"!",
+		"team=a=b",
+		"team name=payments",
+	} {
+		if _, err := ParseSelector(selector); err == nil {
+			t.Errorf("Expected selector %q to be rejected", selector)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package toxiproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// Time the TLS handshake of a client or an upstream has to complete.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// TLSConfig terminates TLS on the listener of a proxy, so its toxics see the
+// plaintext sent by the clients.
+type TLSConfig struct {
+	// Files of the PEM certificate and key of the proxy. When they are empty, the
+	// internal CA signs a certificate for the server name sent by each client.
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+}
+
+// UpstreamTLSConfig originates TLS to the upstreams of a proxy.
+type UpstreamTLSConfig struct {
+	// Server name sent to and verified against the upstreams, defaults to the host
+	// of each upstream
+	ServerName string `json:"server_name,omitempty"`
+	// Skips the verification of the certificates of the upstreams
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+	// File of the PEM certificates verifying the upstreams, instead of the system
+	// ones
+	CA string `json:"ca,omitempty"`
+}
+
+func (config *TLSConfig) serverConfig() (*tls.Config, error) {
+	if (config.Cert == "") != (config.Key == "") {
+		return nil, errors.New("cert and key must be set together")
+	}
+	if config.Cert == "" {
+		return &tls.Config{
+			GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return internalCA.sign(toxics.CertificateOptions{ServerName: hello.ServerName})
+			},
+			MinVersion: tls.VersionTLS12,
+		}, nil
+	}
+
+	pair, err := tls.LoadX509KeyPair(config.Cert, config.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func (config *UpstreamTLSConfig) clientConfig() (*tls.Config, error) {
+	client := &tls.Config{
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if config.CA != "" {
+		data, err := os.ReadFile(config.CA)
+		if err != nil {
+			return nil, err
+		}
+		client.RootCAs = x509.NewCertPool()
+		if !client.RootCAs.AppendCertsFromPEM(data) {
+			return nil, errors.New("no certificate found in ca")
+		}
+	}
+	return client, nil
+}
+
+// tlsSettings are the TLS configs of a proxy, with the certificates they load.
+// They are replaced as a whole when the proxy is updated, the clients connecting
+// after that use the new ones.
+type tlsSettings struct {
+	config   *TLSConfig
+	upstream *UpstreamTLSConfig
+	// Nil when the proxy does not terminate TLS
+	server *tls.Config
+	// Nil when the proxy does not originate TLS
+	client *tls.Config
+}
+
+func newTLSSettings(config *TLSConfig, upstream *UpstreamTLSConfig) (*tlsSettings, error) {
+	settings := &tlsSettings{}
+
+	var err error
+	if config != nil {
+		settings.config = &TLSConfig{Cert: config.Cert, Key: config.Key}
+		settings.server, err = config.serverConfig()
+		if err != nil {
+			return nil, joinError(err, ErrInvalidTLS)
+		}
+	}
+	if upstream != nil {
+		copied := *upstream
+		settings.upstream = &copied
+		settings.client, err = upstream.clientConfig()
+		if err != nil {
+			return nil, joinError(err, ErrInvalidTLS)
+		}
+	}
+	return settings, nil
+}
+
+// configs returns copies of the configs, so decoding an update into them leaves
+// the settings alone.
+func (settings *tlsSettings) configs() (*TLSConfig, *UpstreamTLSConfig) {
+	var config *TLSConfig
+	if settings.config != nil {
+		copied := *settings.config
+		config = &copied
+	}
+	var upstream *UpstreamTLSConfig
+	if settings.upstream != nil {
+		copied := *settings.upstream
+		upstream = &copied
+	}
+	return config, upstream
+}
+
+// accept runs the TLS handshake of a client, changed by the handshake toxics, when
+// the proxy terminates TLS.
+func (settings *tlsSettings) accept(
+	ctx context.Context,
+	client net.Conn,
+	handshakes []toxics.HandshakeToxic,
+) (net.Conn, error) {
+	if settings.server == nil {
+		return client, nil
+	}
+
+	config := settings.server
+	if len(handshakes) > 0 {
+		changed := &toxics.Handshake{
+			Config: config.Clone(),
+			Sign:   internalCA.sign,
+			Reset:  func(conn net.Conn) error { return resetOnClose(conn) },
+		}
+		for _, toxic := range handshakes {
+			toxic.Handshake(ctx, changed)
+		}
+		config = changed.Config
+	}
+	return handshake(tls.Server(client, config), client)
+}
+
+// dial runs the TLS handshake with an upstream, when the proxy originates TLS.
+// Without a server name in the config, the host of the upstream address is sent.
+func (settings *tlsSettings) dial(upstream net.Conn, address string) (net.Conn, error) {
+	if settings.client == nil {
+		return upstream, nil
+	}
+
+	config := settings.client
+	if config.ServerName == "" {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			config = config.Clone()
+			config.ServerName = host
+		}
+	}
+	return handshake(tls.Client(upstream, config), upstream)
+}
+
+// tlsConn is a TLS connection, which keeps the connection it runs over to be able
+// to reset it.
+type tlsConn struct {
+	*tls.Conn
+	raw net.Conn
+}
+
+func (conn *tlsConn) unwrap() net.Conn {
+	return conn.raw
+}
+
+func handshake(conn *tls.Conn, raw net.Conn) (net.Conn, error) {
+	_ = raw.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	_ = raw.SetDeadline(time.Time{})
+	return &tlsConn{Conn: conn, raw: raw}, nil
+}
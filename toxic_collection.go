@@ -6,7 +6,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/rand"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 
@@ -25,6 +32,18 @@ type ToxicCollection struct {
 	proxy Proxy
 	chain [][]*toxics.ToxicWrapper
 	links map[string]*ToxicLink
+	// Connections shared by the links in both directions, by connection name
+	connections map[string]*toxics.Connection
+	// Number of new clients going through the connection toxics
+	connecting int
+	// Destinations requested by the clients of forward proxies which are
+	// connecting, by connection name
+	destinations map[string]string
+	// State shared by the toxics of all links, by key, dropped with the last
+	// toxic sharing it
+	shared *toxics.Shared
+	// Scheduled toxics, with the timer of their next transition
+	scheduled map[*toxics.ToxicWrapper]*time.Timer
 }
 
 func NewToxicCollection(proxy Proxy) *ToxicCollection {
@@ -33,9 +52,13 @@ func NewToxicCollection(proxy Proxy) *ToxicCollection {
 			Toxic: new(toxics.NoopToxic),
 			Type:  "noop",
 		},
-		proxy: proxy,
-		chain: make([][]*toxics.ToxicWrapper, stream.NumDirections),
-		links: make(map[string]*ToxicLink),
+		proxy:        proxy,
+		chain:        make([][]*toxics.ToxicWrapper, stream.NumDirections),
+		links:        make(map[string]*ToxicLink),
+		connections:  make(map[string]*toxics.Connection),
+		destinations: make(map[string]string),
+		shared:       toxics.NewShared(),
+		scheduled:    make(map[*toxics.ToxicWrapper]*time.Timer),
 	}
 	for dir := range collection.chain {
 		collection.chain[dir] = make([]*toxics.ToxicWrapper, 1, toxics.Count()+1)
@@ -48,6 +71,10 @@ func (c *ToxicCollection) ResetToxics(ctx context.Context) {
 	c.Lock()
 	defer c.Unlock()
 
+	for toxic := range c.scheduled {
+		c.unscheduleToxic(ctx, toxic)
+	}
+
 	// Remove all but the first noop toxic
 	for dir := range c.chain {
 		for len(c.chain[dir]) > 1 {
@@ -77,6 +104,9 @@ func (c *ToxicCollection) GetToxicArray() []toxics.Toxic {
 			result = append(result, toxic)
 		}
 	}
+	for _, toxic := range c.inactiveToxics() {
+		result = append(result, toxic)
+	}
 	return result
 }
 
@@ -84,6 +114,17 @@ func (c *ToxicCollection) AddToxicJson(data io.Reader) (*toxics.ToxicWrapper, er
 	c.Lock()
 	defer c.Unlock()
 
+	wrapper, err := c.decodeToxic(data)
+	if err != nil {
+		return nil, err
+	}
+	c.addToxic(wrapper)
+	return wrapper, nil
+}
+
+// decodeToxic decodes a new toxic, and checks that it can be added to the
+// collection. Assumes the lock has already been taken.
+func (c *ToxicCollection) decodeToxic(data io.Reader) (*toxics.ToxicWrapper, error) {
 	var buffer bytes.Buffer
 
 	// Default to a downstream toxic with a toxicity of 1.
@@ -111,6 +152,10 @@ func (c *ToxicCollection) AddToxicJson(data io.Reader) (*toxics.ToxicWrapper, er
 		return nil, ErrInvalidToxicType
 	}
 
+	if !c.supportsToxic(wrapper.Toxic) {
+		return nil, joinError(fmt.Errorf("%s", wrapper.Type), ErrToxicNotDatagram)
+	}
+
 	found := c.findToxicByName(wrapper.Name)
 	if found != nil {
 		return nil, ErrToxicAlreadyExists
@@ -127,10 +172,22 @@ func (c *ToxicCollection) AddToxicJson(data io.Reader) (*toxics.ToxicWrapper, er
 		return nil, joinError(err, ErrBadRequestBody)
 	}
 
-	c.chainAddToxic(wrapper)
+	err = wrapper.Validate()
+	if err != nil {
+		return nil, joinError(err, ErrBadRequestBody)
+	}
 	return wrapper, nil
 }
 
+// addToxic adds a decoded toxic, assumes the lock has already been taken.
+func (c *ToxicCollection) addToxic(wrapper *toxics.ToxicWrapper) {
+	if wrapper.Schedule != nil {
+		c.scheduleToxic(wrapper)
+	} else {
+		c.chainAddToxic(wrapper)
+	}
+}
+
 func (c *ToxicCollection) AddToxic(
 	name string,
 	direction string,
@@ -145,6 +202,10 @@ func (c *ToxicCollection) AddToxic(
 		return nil, ErrToxicAlreadyExists
 	}
 
+	if !c.supportsToxic(toxic) {
+		return nil, ErrToxicNotDatagram
+	}
+
 	wrapper := &toxics.ToxicWrapper{
 		Name:     name,
 		Stream:   direction,
@@ -168,24 +229,91 @@ func (c *ToxicCollection) UpdateToxicJson(
 	defer c.Unlock()
 
 	toxic := c.findToxicByName(name)
-	if toxic != nil {
-		attrs := &struct {
-			Attributes interface{} `json:"attributes"`
-			Toxicity   float32     `json:"toxicity"`
-		}{
-			toxic.Toxic,
-			toxic.Toxicity,
-		}
-		err := json.NewDecoder(data).Decode(attrs)
+	if toxic == nil {
+		return nil, ErrToxicNotFound
+	}
+	update, err := decodeToxicUpdate(toxic, data)
+	if err != nil {
+		return nil, err
+	}
+	c.applyToxicUpdate(toxic, update)
+	return toxic, nil
+}
+
+// toxicUpdate is an update of a toxic, decoded over a copy of its current values.
+type toxicUpdate struct {
+	Attributes   interface{}         `json:"attributes"`
+	Toxicity     float32             `json:"toxicity"`
+	ToxicityMode toxics.ToxicityMode `json:"toxicity_mode"`
+	Schedule     json.RawMessage     `json:"schedule"`
+
+	toxic    toxics.Toxic
+	schedule *toxics.Schedule
+}
+
+// decodeToxicUpdate decodes and validates the update of the toxic. The toxic is
+// left unchanged until the update is applied.
+func decodeToxicUpdate(toxic *toxics.ToxicWrapper, data io.Reader) (*toxicUpdate, error) {
+	attributes, err := copyToxic(toxic.Toxic)
+	if err != nil {
+		return nil, err
+	}
+	update := &toxicUpdate{
+		Attributes:   attributes,
+		Toxicity:     toxic.Toxicity,
+		ToxicityMode: toxic.ToxicityMode,
+		toxic:        attributes,
+	}
+	err = json.NewDecoder(data).Decode(update)
+	if err != nil {
+		return nil, joinError(err, ErrBadRequestBody)
+	}
+
+	updated := *toxic
+	updated.Toxic = attributes
+	updated.ToxicityMode = update.ToxicityMode
+	err = updated.Validate()
+	if err != nil {
+		return nil, joinError(err, ErrBadRequestBody)
+	}
+
+	if len(update.Schedule) > 0 {
+		err = json.Unmarshal(update.Schedule, &update.schedule)
 		if err != nil {
 			return nil, joinError(err, ErrBadRequestBody)
 		}
-		toxic.Toxicity = attrs.Toxicity
+	}
+	return update, nil
+}
 
+// copyToxic returns a copy of the attributes of the toxic, through their JSON
+// encoding so the copy shares nothing with the toxic.
+func copyToxic(toxic toxics.Toxic) (toxics.Toxic, error) {
+	current, err := json.Marshal(toxic)
+	if err != nil {
+		return nil, err
+	}
+	copied := reflect.New(reflect.TypeOf(toxic).Elem()).Interface().(toxics.Toxic)
+	err = json.Unmarshal(current, copied)
+	if err != nil {
+		return nil, err
+	}
+	return copied, nil
+}
+
+// applyToxicUpdate applies a decoded update, assumes the lock has already been
+// taken.
+func (c *ToxicCollection) applyToxicUpdate(toxic *toxics.ToxicWrapper, update *toxicUpdate) {
+	toxic.Toxic = update.toxic
+	toxic.Toxicity = update.Toxicity
+	toxic.ToxicityMode = update.ToxicityMode
+
+	if toxic.Index >= 0 {
 		c.chainUpdateToxic(toxic)
-		return toxic, nil
 	}
-	return nil, ErrToxicNotFound
+	if len(update.Schedule) > 0 {
+		c.rescheduleToxic(toxic, update.schedule)
+	}
 }
 
 func (c *ToxicCollection) UpdateToxic(
@@ -226,17 +354,51 @@ func (c *ToxicCollection) RemoveToxic(ctx context.Context, name string) error {
 		return ErrToxicNotFound
 	}
 
-	c.chainRemoveToxic(ctx, toxic)
+	c.removeToxic(ctx, toxic)
 	log.Trace().Msg("Finished")
 	return nil
 }
 
+// removeToxic removes a toxic of the collection, assumes the lock has already
+// been taken.
+func (c *ToxicCollection) removeToxic(ctx context.Context, toxic *toxics.ToxicWrapper) {
+	if toxic.Schedule != nil {
+		c.unscheduleToxic(ctx, toxic)
+	} else {
+		c.chainRemoveToxic(ctx, toxic)
+	}
+}
+
 func (c *ToxicCollection) StartLink(
 	server *ApiServer,
 	name string,
 	input io.Reader,
 	output io.WriteCloser,
 	direction stream.Direction,
+) {
+	c.startLink(server, name, input, output, direction, false)
+}
+
+// StartDatagramLink starts a link which preserves packet boundaries. Every read
+// from input must return exactly one packet, and every packet is written to
+// output with a single write.
+func (c *ToxicCollection) StartDatagramLink(
+	server *ApiServer,
+	name string,
+	input io.Reader,
+	output io.WriteCloser,
+	direction stream.Direction,
+) {
+	c.startLink(server, name, input, output, direction, true)
+}
+
+func (c *ToxicCollection) startLink(
+	server *ApiServer,
+	name string,
+	input io.Reader,
+	output io.WriteCloser,
+	direction stream.Direction,
+	datagram bool,
 ) {
 	c.Lock()
 	defer c.Unlock()
@@ -248,15 +410,225 @@ func (c *ToxicCollection) StartLink(
 		logger = zerolog.Nop()
 	}
 
+	connection, ok := c.connections[connectionName(name, direction)]
+	if !ok {
+		connection = toxics.NewConnection()
+		connection.Client, connection.ClientPort = clientAddress(connectionName(name, direction))
+		connection.Destination, connection.DestinationPort = destinationAddress(
+			c.destinations[connectionName(name, direction)],
+		)
+		c.connections[connectionName(name, direction)] = connection
+	}
+
 	link := NewToxicLink(c.proxy, c, direction, logger)
+	link.datagram = datagram
+	link.connection = connection
 	link.Start(server, name, input, output)
 	c.links[name] = link
 }
 
+// ClientToxics are the toxics acting on a new client once it is let through by the
+// accept and dial toxics.
+type ClientToxics struct {
+	Resolves   []toxics.ResolveToxic
+	Handshakes []toxics.HandshakeToxic
+}
+
+// Connect runs the accept and dial toxics on a new client, before the upstream
+// is dialed, and returns the other toxics applying to the client when it is
+// allowed. The client counts as a connection of the proxy until done is called.
+// Toxics matching the data of connections never apply, as none was sent yet.
+// The destination is requested by clients of forward proxies, empty otherwise.
+func (c *ToxicCollection) Connect(
+	ctx context.Context,
+	client string,
+	destination string,
+) (toxics.ConnectAction, ClientToxics, func()) {
+	ip, port := clientAddress(client)
+	host, destinationPort := destinationAddress(destination)
+
+	c.Lock()
+	active := len(c.connections) + c.connecting
+	c.connecting++
+	if destination != "" {
+		c.destinations[client] = destination
+	}
+
+	var accepts []toxics.AcceptToxic
+	var dials []toxics.DialToxic
+	var others ClientToxics
+	for dir := range c.chain {
+		// Skip the first noop toxic, it has no effect
+		for _, toxic := range c.chain[dir][1:] {
+			if toxic.Match != nil &&
+				(toxic.Match.MatchesData() || !toxic.Match.MatchesClient(ip, port) ||
+					!toxic.Match.MatchesDestination(host, destinationPort)) {
+				continue
+			}
+			// Toxics in the chunk mode are not rolled once for the connection.
+			chunks := toxic.ToxicityMode == toxics.ToxicityChunk
+			//#nosec
+			if (chunks && toxic.Toxicity <= 0) || (!chunks && rand.Float32() >= toxic.Toxicity) {
+				continue
+			}
+			if accept, ok := toxic.Toxic.(toxics.AcceptToxic); ok {
+				accepts = append(accepts, accept)
+			}
+			if dial, ok := toxic.Toxic.(toxics.DialToxic); ok {
+				dials = append(dials, dial)
+			}
+			if resolve, ok := toxic.Toxic.(toxics.ResolveToxic); ok {
+				others.Resolves = append(others.Resolves, resolve)
+			}
+			if handshake, ok := toxic.Toxic.(toxics.HandshakeToxic); ok {
+				others.Handshakes = append(others.Handshakes, handshake)
+			}
+		}
+	}
+	c.Unlock()
+
+	done := func() {
+		c.Lock()
+		defer c.Unlock()
+		c.connecting--
+		delete(c.destinations, client)
+	}
+
+	for _, toxic := range accepts {
+		if action := toxic.Accept(ctx, active); action != toxics.ConnectAllow {
+			return action, ClientToxics{}, done
+		}
+	}
+	for _, toxic := range dials {
+		if action := toxic.Dial(ctx); action != toxics.ConnectAllow {
+			return action, ClientToxics{}, done
+		}
+	}
+	return toxics.ConnectAllow, others, done
+}
+
+// ConnectionInfo describes a client connection of a proxy.
+type ConnectionInfo struct {
+	// Address of the client, which names the connection
+	Client string `json:"client"`
+	// Destination requested by the client of a forward proxy
+	Destination string    `json:"destination,omitempty"`
+	Started     time.Time `json:"started"`
+	// Bytes received from the client and from the upstream
+	UpstreamBytes   int64 `json:"upstream_bytes"`
+	DownstreamBytes int64 `json:"downstream_bytes"`
+	// Names of the toxics applying to the connection
+	Toxics []string `json:"toxics"`
+}
+
+// GetConnections returns the open connections of the proxy, oldest first.
+func (c *ToxicCollection) GetConnections() []ConnectionInfo {
+	c.Lock()
+	defer c.Unlock()
+
+	result := make([]ConnectionInfo, 0, len(c.connections))
+	for name, connection := range c.connections {
+		info := ConnectionInfo{
+			Client:          name,
+			Destination:     joinDestination(connection),
+			Started:         connection.Started,
+			UpstreamBytes:   connection.Bytes(stream.Upstream),
+			DownstreamBytes: connection.Bytes(stream.Downstream),
+			Toxics:          []string{},
+		}
+		for dir := stream.Direction(0); dir < stream.NumDirections; dir++ {
+			link, ok := c.links[name+dir.String()]
+			if !ok {
+				continue
+			}
+			// Skip the first noop toxic, it is not visible
+			for i, toxic := range c.chain[dir][1:] {
+				if i+1 < len(link.stubs) && link.stubs[i+1].Applies() {
+					info.Toxics = append(info.Toxics, toxic.Name)
+				}
+			}
+		}
+		result = append(result, info)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Started.Equal(result[j].Started) {
+			return result[i].Client < result[j].Client
+		}
+		return result[i].Started.Before(result[j].Started)
+	})
+	return result
+}
+
 func (c *ToxicCollection) RemoveLink(name string) {
 	c.Lock()
 	defer c.Unlock()
+
+	link, ok := c.links[name]
+	if !ok {
+		return
+	}
 	delete(c.links, name)
+
+	// Forget the connection once the links in both directions are gone.
+	connection := connectionName(name, link.direction)
+	for dir := stream.Direction(0); dir < stream.NumDirections; dir++ {
+		if _, ok := c.links[connection+dir.String()]; ok {
+			return
+		}
+	}
+	delete(c.connections, connection)
+}
+
+// connectionName returns the name of the connection a link belongs to. Links
+// are named after their connection followed by their direction.
+func connectionName(link string, direction stream.Direction) string {
+	return strings.TrimSuffix(link, direction.String())
+}
+
+// clientAddress parses the address of the client of a connection, named after
+// it. The address is nil if the name is not a host and port.
+func clientAddress(name string) (net.IP, int) {
+	host, port, err := net.SplitHostPort(name)
+	if err != nil {
+		return nil, 0
+	}
+	number, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, 0
+	}
+	return net.ParseIP(host), number
+}
+
+// destinationAddress parses the destination requested by the client of a forward
+// proxy. The host is empty if there is none.
+func destinationAddress(destination string) (string, int) {
+	host, port, err := net.SplitHostPort(destination)
+	if err != nil {
+		return "", 0
+	}
+	number, err := strconv.Atoi(port)
+	if err != nil {
+		return "", 0
+	}
+	return host, number
+}
+
+func joinDestination(connection *toxics.Connection) string {
+	if connection.Destination == "" {
+		return ""
+	}
+	return net.JoinHostPort(connection.Destination, strconv.Itoa(connection.DestinationPort))
+}
+
+// supportsToxic reports whether the toxic can be used on the links of the proxy.
+// Links of UDP proxies carry one packet per chunk, so only toxics preserving
+// chunk boundaries are allowed.
+func (c *ToxicCollection) supportsToxic(toxic toxics.Toxic) bool {
+	if c.proxy == nil || c.proxy.Protocol() != ProtocolUDP {
+		return true
+	}
+	return toxics.SupportsDatagrams(toxic)
 }
 
 // All following functions assume the lock is already grabbed.
@@ -269,6 +641,11 @@ func (c *ToxicCollection) findToxicByName(name string) *toxics.ToxicWrapper {
 			}
 		}
 	}
+	for toxic := range c.scheduled {
+		if toxic.Name == name {
+			return toxic
+		}
+	}
 	return nil
 }
 
@@ -306,6 +683,8 @@ func (c *ToxicCollection) chainUpdateToxic(toxic *toxics.ToxicWrapper) {
 		}
 	}
 	group.Wait()
+
+	c.shared.Retain(c.sharedKeys())
 }
 
 func (c *ToxicCollection) chainRemoveToxic(ctx context.Context, toxic *toxics.ToxicWrapper) {
@@ -343,5 +722,21 @@ func (c *ToxicCollection) chainRemoveToxic(ctx context.Context, toxic *toxics.To
 		Msg("Waiting to update links")
 	wg.Wait()
 
+	c.shared.Retain(c.sharedKeys())
 	toxic.Index = -1
 }
+
+// sharedKeys returns the keys of the state shared by the toxics of the chains.
+func (c *ToxicCollection) sharedKeys() map[string]bool {
+	keys := make(map[string]bool)
+	for _, chain := range c.chain {
+		for _, toxic := range chain {
+			if shared, ok := toxic.Toxic.(toxics.SharedToxic); ok {
+				if key := shared.SharedKey(toxic.Name); key != "" {
+					keys[key] = true
+				}
+			}
+		}
+	}
+	return keys
+}
@@ -0,0 +1,124 @@
+package toxiproxy
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// Scheduled toxics are kept in the scheduled map of their collection, with the
+// timer of their next transition. They are only part of the chain, and of the
+// links, while they are active.
+
+// StopSchedules stops turning scheduled toxics on and off, once the proxy is
+// removed. Toxics stay in their current phase.
+func (c *ToxicCollection) StopSchedules() {
+	c.Lock()
+	defer c.Unlock()
+
+	for toxic, timer := range c.scheduled {
+		if timer != nil {
+			timer.Stop()
+		}
+		c.scheduled[toxic] = nil
+	}
+}
+
+// All following functions assume the lock is already grabbed.
+
+// scheduleToxic starts the schedule of a toxic which is not in the chain.
+func (c *ToxicCollection) scheduleToxic(toxic *toxics.ToxicWrapper) {
+	toxic.Index = -1
+	toxic.Schedule.SetPhase(toxics.PhasePending)
+	c.scheduleAfter(toxic, time.Duration(toxic.Schedule.Start)*time.Millisecond, c.activateToxic)
+}
+
+// unscheduleToxic stops the schedule of a toxic, and removes it from the chain.
+func (c *ToxicCollection) unscheduleToxic(ctx context.Context, toxic *toxics.ToxicWrapper) {
+	if timer := c.scheduled[toxic]; timer != nil {
+		timer.Stop()
+	}
+	delete(c.scheduled, toxic)
+	if toxic.Index >= 0 {
+		c.chainRemoveToxic(ctx, toxic)
+	}
+}
+
+// scheduleAfter calls transition once the delay elapsed, unless the schedule of
+// the toxic was stopped or restarted in the meantime.
+func (c *ToxicCollection) scheduleAfter(
+	toxic *toxics.ToxicWrapper,
+	delay time.Duration,
+	transition func(*toxics.ToxicWrapper),
+) {
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		c.Lock()
+		defer c.Unlock()
+
+		if c.scheduled[toxic] == timer {
+			transition(toxic)
+		}
+	})
+	c.scheduled[toxic] = timer
+}
+
+func (c *ToxicCollection) activateToxic(toxic *toxics.ToxicWrapper) {
+	c.chainAddToxic(toxic)
+	toxic.Schedule.SetPhase(toxics.PhaseActive)
+
+	if toxic.Schedule.Duration > 0 {
+		duration := time.Duration(toxic.Schedule.Duration) * time.Millisecond
+		c.scheduleAfter(toxic, duration, c.deactivateToxic)
+	} else {
+		// Active until removed.
+		c.scheduled[toxic] = nil
+	}
+}
+
+func (c *ToxicCollection) deactivateToxic(toxic *toxics.ToxicWrapper) {
+	c.chainRemoveToxic(context.Background(), toxic)
+
+	if toxic.Schedule.Period > 0 {
+		toxic.Schedule.SetPhase(toxics.PhaseInactive)
+		c.scheduleAfter(toxic, toxic.Schedule.Inactive(), c.activateToxic)
+	} else {
+		toxic.Schedule.SetPhase(toxics.PhaseFinished)
+		c.scheduled[toxic] = nil
+	}
+}
+
+// rescheduleToxic replaces the schedule of a toxic, a nil schedule makes it
+// always active.
+func (c *ToxicCollection) rescheduleToxic(toxic *toxics.ToxicWrapper, schedule *toxics.Schedule) {
+	if toxic.Schedule != nil {
+		c.unscheduleToxic(context.Background(), toxic)
+	}
+
+	toxic.Schedule = schedule
+	if schedule != nil {
+		if toxic.Index >= 0 {
+			c.chainRemoveToxic(context.Background(), toxic)
+		}
+		c.scheduleToxic(toxic)
+	} else if toxic.Index < 0 {
+		c.chainAddToxic(toxic)
+	}
+}
+
+// inactiveToxics returns the scheduled toxics which are not in the chain, sorted
+// by name.
+func (c *ToxicCollection) inactiveToxics() []*toxics.ToxicWrapper {
+	var inactive []*toxics.ToxicWrapper
+	for toxic := range c.scheduled {
+		if toxic.Index < 0 {
+			inactive = append(inactive, toxic)
+		}
+	}
+	sort.Slice(inactive, func(i, j int) bool {
+		return inactive[i].Name < inactive[j].Name
+	})
+	return inactive
+}
@@ -0,0 +1,28 @@
+package toxics
+
+import (
+	"context"
+	"time"
+)
+
+// The AcceptDelayToxic delays new clients after they are accepted, before the
+// upstream is dialed and any data flows.
+type AcceptDelayToxic struct {
+	// Time in milliseconds
+	Delay int64 `json:"delay"`
+}
+
+func (t *AcceptDelayToxic) Pipe(stub *ToxicStub) {
+	new(NoopToxic).Pipe(stub)
+}
+
+func (t *AcceptDelayToxic) Accept(ctx context.Context, active int) ConnectAction {
+	if !sleep(ctx, time.Duration(t.Delay)*time.Millisecond) {
+		return ConnectClose
+	}
+	return ConnectAllow
+}
+
+func init() {
+	Register("accept_delay", new(AcceptDelayToxic))
+}
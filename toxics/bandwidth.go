@@ -2,6 +2,7 @@ package toxics
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -9,10 +10,80 @@ import (
 	"github.com/Shopify/toxiproxy/v2/stream"
 )
 
-// The BandwidthToxic passes data through at a limited rate.
+// The BandwidthToxic passes data through at a limited rate. With a burst or a
+// shared limit, the rate is enforced by a token bucket, which lets bursts of data
+// through at once and can be shared by several links.
 type BandwidthToxic struct {
 	// Rate in KB/s
 	Rate int64 `json:"rate"`
+	// Size of the token bucket in KB, defaults to a tenth of a second at the rate
+	Burst int64 `json:"burst"`
+	// Links sharing the token bucket
+	Shared BandwidthShare `json:"shared"`
+	// Proxy toxics with the same group share their bucket, defaults to the name
+	// of the toxic
+	Group string `json:"group"`
+
+	// Bucket of the links sharing the stream
+	mutex  sync.Mutex
+	stream *tokenBucket
+}
+
+// A BandwidthShare is the set of links sharing a token bucket.
+type BandwidthShare string
+
+const (
+	// Every link has its own bucket
+	ShareLink BandwidthShare = "link"
+	// All the links of the toxic's stream share a bucket
+	ShareStream BandwidthShare = "stream"
+	// All the links of the proxy share a bucket, with the toxics of both streams
+	// in the same group
+	ShareProxy BandwidthShare = "proxy"
+)
+
+type BandwidthToxicState struct {
+	link  tokenBucket
+	proxy *tokenBucket
+}
+
+func (t *BandwidthToxic) Validate() error {
+	return validateEnum("shared", string(t.Shared),
+		string(ShareLink), string(ShareStream), string(ShareProxy))
+}
+
+// bucketed reports whether the rate is enforced by a token bucket.
+func (t *BandwidthToxic) bucketed() bool {
+	return t.Burst > 0 || (t.Shared != "" && t.Shared != ShareLink)
+}
+
+// bucket returns the token bucket used by the link.
+func (t *BandwidthToxic) bucket(state *BandwidthToxicState) *tokenBucket {
+	switch t.Shared {
+	case ShareStream:
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		if t.stream == nil {
+			t.stream = new(tokenBucket)
+		}
+		return t.stream
+	case ShareProxy:
+		if state.proxy != nil {
+			return state.proxy
+		}
+	}
+	return &state.link
+}
+
+// burst returns the size of the token bucket in bytes.
+func (t *BandwidthToxic) burst() int64 {
+	if t.Burst > 0 {
+		return t.Burst * 1000
+	}
+	if t.Rate*100 > 0 {
+		return t.Rate * 100
+	}
+	return 1
 }
 
 func (t *BandwidthToxic) Pipe(stub *ToxicStub) {
@@ -33,6 +104,13 @@ func (t *BandwidthToxic) Pipe(stub *ToxicStub) {
 				stub.Close()
 				return
 			}
+			if t.Rate > 0 && t.bucketed() {
+				if t.pipeBucket(stub, p) {
+					logger.Trace().Msg("BandwidthToxic was interrupted during writing data")
+					return
+				}
+				continue
+			}
 			if t.Rate <= 0 {
 				sleep = 0
 			} else {
@@ -77,6 +155,66 @@ func (t *BandwidthToxic) Pipe(stub *ToxicStub) {
 	}
 }
 
+// pipeBucket passes the chunk through once its bytes are taken from the token
+// bucket, in pieces no bigger than the burst. It returns true if the toxic was
+// interrupted.
+func (t *BandwidthToxic) pipeBucket(stub *ToxicStub, p *stream.StreamChunk) bool {
+	bucket := t.bucket(stub.State.(*BandwidthToxicState))
+	rate := float64(t.Rate * 1000)
+	burst := t.burst()
+
+	for len(p.Data) > 0 {
+		n := int64(len(p.Data))
+		if n > burst {
+			n = burst
+		}
+
+		wait := bucket.reserve(float64(n), rate, float64(burst), time.Now())
+		select {
+		case <-time.After(wait):
+			stub.Output <- &stream.StreamChunk{Data: p.Data[:n], Timestamp: p.Timestamp}
+			p.Data = p.Data[n:]
+		case <-stub.Interrupt:
+			err := stub.WriteOutput(p, 5*time.Second) // Don't drop any data on the floor
+			if err != nil {
+				log.Warn().
+					Str("component", "BandwidthToxic").
+					Str("method", "pipeBucket").
+					Err(err).
+					Msg("Could not write last packets after interrupt to Output")
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (t *BandwidthToxic) NewState() interface{} {
+	return new(BandwidthToxicState)
+}
+
+// SharedKey returns the key of the proxy bucket, only proxy toxics share one.
+func (t *BandwidthToxic) SharedKey(name string) string {
+	if t.Shared != ShareProxy {
+		return ""
+	}
+	if t.Group != "" {
+		name = t.Group
+	}
+	return "bandwidth:" + name
+}
+
+func (t *BandwidthToxic) Share(stub *ToxicStub, shared *Shared, key string) {
+	state := stub.State.(*BandwidthToxicState)
+	state.proxy = shared.Load(key, func() interface{} {
+		return new(tokenBucket)
+	}).(*tokenBucket)
+}
+
+func (t *BandwidthToxic) PipesChunks() bool {
+	return true
+}
+
 func init() {
 	Register("bandwidth", new(BandwidthToxic))
 }
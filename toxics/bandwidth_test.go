@@ -2,12 +2,15 @@ package toxics_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"net"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/Shopify/toxiproxy/v2/stream"
 	"github.com/Shopify/toxiproxy/v2/testhelper"
 	"github.com/Shopify/toxiproxy/v2/toxics"
 )
@@ -58,6 +61,123 @@ func TestBandwidthToxic(t *testing.T) {
 	)
 }
 
+// pipeBandwidth passes size bytes through the toxic named name on a new link, and
+// returns how long it took.
+func pipeBandwidth(
+	toxic *toxics.BandwidthToxic,
+	name string,
+	shared *toxics.Shared,
+	size int,
+) time.Duration {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = toxic.NewState()
+	if key := toxic.SharedKey(name); key != "" {
+		toxic.Share(stub, shared, key)
+	}
+	go toxic.Pipe(stub)
+
+	start := time.Now()
+	go func() {
+		input <- &stream.StreamChunk{Data: make([]byte, size)}
+		close(input)
+	}()
+	for range output {
+	}
+	return time.Since(start)
+}
+
+func TestBandwidthToxicBurst(t *testing.T) {
+	toxic := &toxics.BandwidthToxic{Rate: 100, Burst: 20}
+	shared := toxics.NewShared()
+
+	AssertDeltaTime(t,
+		"Burst",
+		pipeBandwidth(toxic, "bandwidth", shared, 20000),
+		0,
+		10*time.Millisecond,
+	)
+	// Every link has its own bucket by default.
+	AssertDeltaTime(t,
+		"Burst on another link",
+		pipeBandwidth(toxic, "bandwidth", shared, 20000),
+		0,
+		10*time.Millisecond,
+	)
+	// Over the burst, data passes at the rate.
+	AssertDeltaTime(t,
+		"Over the burst",
+		pipeBandwidth(toxic, "bandwidth", shared, 30000),
+		100*time.Millisecond,
+		20*time.Millisecond,
+	)
+}
+
+func TestBandwidthToxicShared(t *testing.T) {
+	upstream := &toxics.BandwidthToxic{Rate: 1000, Burst: 1, Shared: toxics.ShareStream}
+	downstream := &toxics.BandwidthToxic{Rate: 1000, Burst: 1, Shared: toxics.ShareProxy}
+	other := &toxics.BandwidthToxic{Rate: 1000, Burst: 1, Shared: toxics.ShareProxy}
+	grouped := &toxics.BandwidthToxic{
+		Rate: 1000, Burst: 1, Shared: toxics.ShareProxy, Group: "both",
+	}
+	otherGrouped := &toxics.BandwidthToxic{
+		Rate: 1000, Burst: 1, Shared: toxics.ShareProxy, Group: "both",
+	}
+	perLink := &toxics.BandwidthToxic{Rate: 1000, Burst: 1}
+	names := map[*toxics.BandwidthToxic]string{
+		upstream:     "upstream",
+		downstream:   "downstream",
+		other:        "other",
+		grouped:      "grouped",
+		otherGrouped: "other_grouped",
+		perLink:      "link",
+	}
+
+	for _, test := range []struct {
+		name     string
+		toxics   []*toxics.BandwidthToxic
+		expected time.Duration
+	}{
+		{"link", []*toxics.BandwidthToxic{perLink, perLink}, 100 * time.Millisecond},
+		{"stream", []*toxics.BandwidthToxic{upstream, upstream}, 200 * time.Millisecond},
+		{"proxy", []*toxics.BandwidthToxic{downstream, downstream}, 200 * time.Millisecond},
+		// Unrelated proxy toxics have their own bucket.
+		{"proxy toxics", []*toxics.BandwidthToxic{downstream, other}, 100 * time.Millisecond},
+		// Toxics of both streams in a group share the proxy bucket.
+		{"group", []*toxics.BandwidthToxic{grouped, otherGrouped}, 200 * time.Millisecond},
+	} {
+		shared := toxics.NewShared()
+
+		var wg sync.WaitGroup
+		start := time.Now()
+		for _, toxic := range test.toxics {
+			wg.Add(1)
+			go func(toxic *toxics.BandwidthToxic) {
+				defer wg.Done()
+				pipeBandwidth(toxic, names[toxic], shared, 100000)
+			}(toxic)
+		}
+		wg.Wait()
+
+		AssertDeltaTime(t,
+			"Shared by "+test.name,
+			time.Since(start),
+			test.expected,
+			30*time.Millisecond,
+		)
+	}
+
+}
+
+func TestBandwidthToxicRejectsInvalidShare(t *testing.T) {
+	toxic := new(toxics.BandwidthToxic)
+	err := json.Unmarshal([]byte(`{"shared": "connection"}`), toxic)
+	if err != nil || toxic.Validate() == nil {
+		t.Fatal("Expected invalid share to be rejected")
+	}
+}
+
 func BenchmarkBandwidthToxic100MB(b *testing.B) {
 	upstream := testhelper.NewUpstream(b, true)
 	defer upstream.Close()
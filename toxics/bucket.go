@@ -0,0 +1,56 @@
+package toxics
+
+import (
+	"sync"
+	"time"
+)
+
+// A tokenBucket is refilled at a rate of tokens per second, up to its burst. The
+// rate and burst are given on every use, so they follow updates of the toxic.
+type tokenBucket struct {
+	sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last use, the bucket starts full.
+func (b *tokenBucket) refill(rate, burst float64, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+}
+
+// take removes n tokens from the bucket, it returns false if there are not
+// enough of them.
+func (b *tokenBucket) take(n, rate, burst float64, now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	b.refill(rate, burst, now)
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// reserve removes n tokens from the bucket, going into debt if there are not
+// enough of them. It returns how long to wait until the tokens are earned.
+// Reservations are served in order, so users sharing a bucket get a fair share.
+func (b *tokenBucket) reserve(n, rate, burst float64, now time.Time) time.Duration {
+	b.Lock()
+	defer b.Unlock()
+
+	b.refill(rate, burst, now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
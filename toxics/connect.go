@@ -0,0 +1,70 @@
+package toxics
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// A ConnectAction tells a proxy what to do with a new client.
+type ConnectAction int
+
+const (
+	// Dial the upstream and start the links of the client
+	ConnectAllow ConnectAction = iota
+	// Close the client without dialing the upstream
+	ConnectClose
+	// Close the client with a TCP RST, as if the connection was refused
+	ConnectReset
+)
+
+// Accept toxics act on new clients of a TCP proxy before the upstream is dialed
+// and before any link starts. Accept may block to delay the connection, but
+// must return as soon as the context is done. Active is the number of other
+// connections the proxy has open.
+type AcceptToxic interface {
+	Accept(ctx context.Context, active int) ConnectAction
+}
+
+// Dial toxics act on new clients of a TCP proxy right before the upstream is
+// dialed, once all accept toxics let the client through.
+type DialToxic interface {
+	Dial(ctx context.Context) ConnectAction
+}
+
+// Resolve toxics act on the resolution of the upstream hostname for new clients
+// of a TCP proxy, once the dial toxics let the client through. Resolve returns
+// the addresses the host resolves to for the client, given the addresses the
+// previous toxics resolved it to, or an error failing the resolution. The host is
+// looked up when all of them return no addresses. Upstreams given as IP addresses
+// are not resolved.
+type ResolveToxic interface {
+	Resolve(ctx context.Context, host string, addrs []string) ([]string, error)
+}
+
+// resolvesHost reports whether a resolve toxic restricted to the host applies to
+// the resolved host. An empty host applies to all of them.
+func resolvesHost(restricted, host string) bool {
+	return restricted == "" || strings.EqualFold(restricted, host)
+}
+
+// turnAway returns the action closing a client, with a reset when asked for.
+func turnAway(reset bool) ConnectAction {
+	if reset {
+		return ConnectReset
+	}
+	return ConnectClose
+}
+
+// sleep waits for the duration, it returns false if the context was done first.
+func sleep(ctx context.Context, duration time.Duration) bool {
+	if duration <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(duration):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,154 @@
+package toxics_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+func TestConnectionRateToxicLimitsNewConnections(t *testing.T) {
+	toxic := &toxics.ConnectionRateToxic{Rate: 20, Burst: 2}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if action := toxic.Accept(ctx, 0); action != toxics.ConnectAllow {
+			t.Fatalf("Expected connection %d of the burst to be allowed, got %v", i, action)
+		}
+	}
+	if action := toxic.Accept(ctx, 0); action != toxics.ConnectClose {
+		t.Fatalf("Expected connection over the burst to be closed, got %v", action)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if action := toxic.Accept(ctx, 0); action != toxics.ConnectAllow {
+		t.Fatalf("Expected connection to be allowed once the bucket refilled, got %v", action)
+	}
+
+	toxic.Reset = true
+	if action := toxic.Accept(ctx, 0); action != toxics.ConnectReset {
+		t.Fatalf("Expected connection over the rate to be reset, got %v", action)
+	}
+}
+
+func TestMaxConnectionsToxic(t *testing.T) {
+	toxic := &toxics.MaxConnectionsToxic{Limit: 2}
+	ctx := context.Background()
+
+	if action := toxic.Accept(ctx, 1); action != toxics.ConnectAllow {
+		t.Fatalf("Expected connection under the limit to be allowed, got %v", action)
+	}
+	if action := toxic.Accept(ctx, 2); action != toxics.ConnectClose {
+		t.Fatalf("Expected connection at the limit to be closed, got %v", action)
+	}
+
+	toxic.Limit = 0
+	if action := toxic.Accept(ctx, 100); action != toxics.ConnectAllow {
+		t.Fatalf("Expected no limit with a limit of 0, got %v", action)
+	}
+}
+
+func TestDialDelayToxic(t *testing.T) {
+	ctx := context.Background()
+
+	start := time.Now()
+	action := (&toxics.DialDelayToxic{Delay: 50}).Dial(ctx)
+	if action != toxics.ConnectAllow {
+		t.Fatalf("Expected delayed dial to be allowed, got %v", action)
+	}
+	AssertDeltaTime(t, "Dial delay", time.Since(start), 50*time.Millisecond, 70*time.Millisecond)
+
+	start = time.Now()
+	action = (&toxics.DialDelayToxic{Delay: 1000, Timeout: 50}).Dial(ctx)
+	if action != toxics.ConnectClose {
+		t.Fatalf("Expected dial over the timeout to be closed, got %v", action)
+	}
+	AssertDeltaTime(t, "Dial timeout", time.Since(start), 50*time.Millisecond, 70*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if action := (&toxics.DialDelayToxic{Delay: 1000}).Dial(ctx); action != toxics.ConnectClose {
+		t.Fatalf("Expected canceled dial to be closed, got %v", action)
+	}
+}
+
+func TestRefuseToxicThroughProxy(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal("Failed to create TCP server", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			t.Error("Expected the upstream to never be dialed")
+			conn.Close()
+		}
+	}()
+
+	proxy := NewTestProxy("test", ln.Addr().String())
+	proxy.Start()
+	defer proxy.Stop()
+
+	_, err = proxy.Toxics().AddToxicJson(
+		ToxicToJson(t, "", "refuse", "upstream", &toxics.RefuseToxic{}))
+	if err != nil {
+		t.Fatal("Failed to add refuse toxic", err)
+	}
+
+	conn, err := net.Dial("tcp", proxy.Listen())
+	if err != nil {
+		t.Fatal("Unable to dial proxy", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("Expected refused client to be closed, got", err)
+	}
+}
+
+func TestMaxConnectionsToxicThroughProxy(t *testing.T) {
+	WithEchoServer(t, func(upstream string, response chan []byte) {
+		proxy := NewTestProxy("test", upstream)
+		proxy.Start()
+		defer proxy.Stop()
+
+		_, err := proxy.Toxics().AddToxicJson(ToxicToJson(t, "", "max_connections", "upstream",
+			&toxics.MaxConnectionsToxic{Limit: 1}))
+		if err != nil {
+			t.Fatal("Failed to add max_connections toxic", err)
+		}
+
+		first, err := net.Dial("tcp", proxy.Listen())
+		if err != nil {
+			t.Fatal("Unable to dial proxy", err)
+		}
+		defer first.Close()
+
+		// Wait for the first connection to go through the proxy.
+		first.Write([]byte("hello world\n"))
+		first.SetReadDeadline(time.Now().Add(time.Second))
+		line, err := bufio.NewReader(first).ReadString('\n')
+		if err != nil || line != "hello world\n" {
+			t.Fatalf("Expected first connection to be echoed, got %q: %v", line, err)
+		}
+		<-response
+
+		second, err := net.Dial("tcp", proxy.Listen())
+		if err != nil {
+			t.Fatal("Unable to dial proxy", err)
+		}
+		defer second.Close()
+
+		second.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatal("Expected connection over the limit to be closed, got", err)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package toxics
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+)
+
+// A Connection is shared by the upstream and downstream links of a single client
+// connection. Toxics use it to look at the data flowing in the opposite direction,
+// for example to match responses with the requests they answer.
+type Connection struct {
+	sync.Mutex
+
+	// Address of the client, nil if unknown
+	Client     net.IP
+	ClientPort int
+	// Host and port requested by the client of a forward proxy, empty otherwise
+	Destination     string
+	DestinationPort int
+	Started         time.Time
+
+	read      [stream.NumDirections]int64
+	ended     [stream.NumDirections]bool
+	observers [stream.NumDirections]map[interface{}]func([]byte)
+}
+
+func NewConnection() *Connection {
+	connection := &Connection{Started: time.Now()}
+	for dir := range connection.observers {
+		connection.observers[dir] = make(map[interface{}]func([]byte))
+	}
+	return connection
+}
+
+// Observe calls the observer with all the data read in the given direction from
+// now on, before it passes through any toxic, then with nil once reading in that
+// direction ended. It returns false if data was already read in that direction,
+// so the observer starts in the middle of it.
+func (c *Connection) Observe(
+	direction stream.Direction,
+	key interface{},
+	observer func([]byte),
+) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	c.observers[direction][key] = observer
+	if c.ended[direction] {
+		observer(nil)
+	}
+	return c.read[direction] == 0
+}
+
+// Bytes returns the number of bytes read so far in the given direction.
+func (c *Connection) Bytes(direction stream.Direction) int64 {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.read[direction]
+}
+
+// StopObserving removes the observer registered with the key.
+func (c *Connection) StopObserving(direction stream.Direction, key interface{}) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.observers[direction], key)
+}
+
+// Read passes the data read by the link in the given direction to its observers.
+func (c *Connection) Read(direction stream.Direction, data []byte) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.read[direction] += int64(len(data))
+	for _, observer := range c.observers[direction] {
+		observer(data)
+	}
+}
+
+// End tells the observers that no more data will be read in the given direction.
+func (c *Connection) End(direction stream.Direction) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.ended[direction] {
+		return
+	}
+	c.ended[direction] = true
+	for _, observer := range c.observers[direction] {
+		observer(nil)
+	}
+}
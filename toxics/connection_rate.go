@@ -0,0 +1,42 @@
+package toxics
+
+import (
+	"context"
+	"time"
+)
+
+// The ConnectionRateToxic limits the rate of new connections with a token
+// bucket, clients over the rate are turned away. The bucket is shared by all
+// the connections of the proxy.
+type ConnectionRateToxic struct {
+	// New connections per second, 0 for no limit
+	Rate float64 `json:"rate"`
+	// Number of connections which can be opened at once, defaults to 1
+	Burst int `json:"burst"`
+	// Close clients with a TCP RST instead of a FIN
+	Reset bool `json:"reset"`
+
+	bucket tokenBucket
+}
+
+func (t *ConnectionRateToxic) Pipe(stub *ToxicStub) {
+	new(NoopToxic).Pipe(stub)
+}
+
+func (t *ConnectionRateToxic) burst() float64 {
+	if t.Burst < 1 {
+		return 1
+	}
+	return float64(t.Burst)
+}
+
+func (t *ConnectionRateToxic) Accept(ctx context.Context, active int) ConnectAction {
+	if t.Rate > 0 && !t.bucket.take(1, t.Rate, t.burst(), time.Now()) {
+		return turnAway(t.Reset)
+	}
+	return ConnectAllow
+}
+
+func init() {
+	Register("connection_rate", new(ConnectionRateToxic))
+}
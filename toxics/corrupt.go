@@ -0,0 +1,189 @@
+package toxics
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// The CorruptToxic tampers with the data passing through it, either by flipping
+// a random bit or by overwriting a whole byte with a random value. Corruption can
+// be limited to windows of byte offsets, and made reproducible with a seed.
+type CorruptToxic struct {
+	// Probability of corrupting a byte, or a chunk when Per is "chunk"
+	Probability float64 `json:"probability"`
+	// Whether Probability applies to every byte or to every chunk
+	Per CorruptUnit `json:"per"`
+	// How a byte is corrupted
+	Mode CorruptMode `json:"mode"`
+	// Seed of the random source, every connection starts from the same seed.
+	// Zero uses a different random seed for every connection.
+	Seed int64 `json:"seed"`
+	// Ranges of byte offsets that can be corrupted, all bytes when empty
+	Windows []CorruptWindow `json:"windows"`
+	// Whether window offsets count from the start of the stream or of each chunk
+	Offsets CorruptOrigin `json:"offsets"`
+}
+
+// A CorruptWindow is a range of byte offsets, from Start up to but not including
+// End. An End of zero means there is no upper bound.
+type CorruptWindow struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+type CorruptUnit string
+
+const (
+	CorruptPerByte  CorruptUnit = "byte"
+	CorruptPerChunk CorruptUnit = "chunk"
+)
+
+type CorruptMode string
+
+const (
+	CorruptFlip      CorruptMode = "flip"
+	CorruptOverwrite CorruptMode = "overwrite"
+)
+
+type CorruptOrigin string
+
+const (
+	CorruptFromStream CorruptOrigin = "stream"
+	CorruptFromChunk  CorruptOrigin = "chunk"
+)
+
+func (t *CorruptToxic) Validate() error {
+	err := validateProbability("probability", t.Probability)
+	if err != nil {
+		return err
+	}
+	for _, window := range t.Windows {
+		if window.Start < 0 || window.End < 0 || (window.End != 0 && window.End <= window.Start) {
+			return fmt.Errorf("invalid corrupt window from %d to %d", window.Start, window.End)
+		}
+	}
+	err = validateEnum("corrupt per", string(t.Per),
+		string(CorruptPerByte), string(CorruptPerChunk))
+	if err != nil {
+		return err
+	}
+	err = validateEnum("corrupt mode", string(t.Mode),
+		string(CorruptFlip), string(CorruptOverwrite))
+	if err != nil {
+		return err
+	}
+	return validateEnum("corrupt offsets", string(t.Offsets),
+		string(CorruptFromStream), string(CorruptFromChunk))
+}
+
+type CorruptToxicState struct {
+	rand *rand.Rand
+	seed int64
+	// Number of bytes seen on the stream so far
+	offset int64
+}
+
+// source returns the random source of the connection, and restarts it when
+// the seed was changed.
+func (t *CorruptToxic) source(state *CorruptToxicState) *rand.Rand {
+	if state.rand == nil || state.seed != t.Seed {
+		seed := t.Seed
+		if seed == 0 {
+			//#nosec
+			seed = rand.Int63()
+		}
+		//#nosec
+		state.rand = rand.New(rand.NewSource(seed))
+		state.seed = t.Seed
+	}
+	return state.rand
+}
+
+// inWindow reports whether the byte at the given offset can be corrupted.
+func (t *CorruptToxic) inWindow(offset int64) bool {
+	if len(t.Windows) == 0 {
+		return true
+	}
+	for _, window := range t.Windows {
+		if offset >= window.Start && (window.End == 0 || offset < window.End) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *CorruptToxic) corruptByte(r *rand.Rand, b byte) byte {
+	if t.Mode == CorruptOverwrite {
+		// Never overwrite a byte with its own value.
+		return b ^ byte(1+r.Intn(255))
+	}
+	return b ^ 1<<r.Intn(8)
+}
+
+// corrupt tampers with the data in place. The base is the offset of the first
+// byte of data.
+func (t *CorruptToxic) corrupt(r *rand.Rand, data []byte, base int64) {
+	if t.Per != CorruptPerChunk {
+		for i := range data {
+			if t.inWindow(base+int64(i)) && r.Float64() < t.Probability {
+				data[i] = t.corruptByte(r, data[i])
+			}
+		}
+		return
+	}
+
+	if r.Float64() >= t.Probability {
+		return
+	}
+	eligible := make([]int, 0, len(data))
+	for i := range data {
+		if t.inWindow(base + int64(i)) {
+			eligible = append(eligible, i)
+		}
+	}
+	if len(eligible) > 0 {
+		i := eligible[r.Intn(len(eligible))]
+		data[i] = t.corruptByte(r, data[i])
+	}
+}
+
+func (t *CorruptToxic) Pipe(stub *ToxicStub) {
+	state := stub.State.(*CorruptToxicState)
+
+	for {
+		select {
+		case <-stub.Interrupt:
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				stub.Close()
+				return
+			}
+
+			var base int64
+			if t.Offsets != CorruptFromChunk {
+				base = state.offset
+			}
+			t.corrupt(t.source(state), c.Data, base)
+			state.offset += int64(len(c.Data))
+
+			stub.Output <- c
+		}
+	}
+}
+
+func (t *CorruptToxic) NewState() interface{} {
+	return new(CorruptToxicState)
+}
+
+func (t *CorruptToxic) PreservesDatagrams() bool {
+	return true
+}
+
+func (t *CorruptToxic) PipesChunks() bool {
+	return true
+}
+
+func init() {
+	Register("corrupt", new(CorruptToxic))
+}
@@ -0,0 +1,181 @@
+package toxics_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/bits"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// corruptChunks sends the chunks through the toxic and returns the data the
+// toxic wrote, the chunks themselves are left untouched.
+func corruptChunks(toxic *toxics.CorruptToxic, chunks ...[]byte) [][]byte {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, len(chunks))
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = toxic.NewState()
+
+	done := make(chan struct{})
+	go func() {
+		toxic.Pipe(stub)
+		close(done)
+	}()
+
+	for _, chunk := range chunks {
+		input <- &stream.StreamChunk{Data: append([]byte{}, chunk...)}
+	}
+	close(input)
+	<-done
+
+	var received [][]byte
+	for c := range output {
+		received = append(received, c.Data)
+	}
+	return received
+}
+
+func changedBits(a, b []byte) []int {
+	changed := make([]int, len(a))
+	for i := range a {
+		changed[i] = bits.OnesCount8(a[i] ^ b[i])
+	}
+	return changed
+}
+
+func TestCorruptToxicNoCorruption(t *testing.T) {
+	toxic := &toxics.CorruptToxic{Probability: 0}
+
+	received := corruptChunks(toxic, []byte("hello world"))
+	if string(received[0]) != "hello world" {
+		t.Fatalf("Expected data to pass unchanged, got %q", received[0])
+	}
+}
+
+func TestCorruptToxicFlipsOneBitPerByte(t *testing.T) {
+	toxic := &toxics.CorruptToxic{Probability: 1}
+
+	msg := []byte("hello world")
+	received := corruptChunks(toxic, msg)
+	for i, changed := range changedBits(msg, received[0]) {
+		if changed != 1 {
+			t.Fatalf("Expected exactly one bit flipped in byte %d, got %d", i, changed)
+		}
+	}
+}
+
+func TestCorruptToxicOverwritesBytes(t *testing.T) {
+	toxic := &toxics.CorruptToxic{Probability: 1, Mode: toxics.CorruptOverwrite}
+
+	msg := bytes.Repeat([]byte{0x55}, 1000)
+	received := corruptChunks(toxic, msg)
+	for i, changed := range changedBits(msg, received[0]) {
+		if changed == 0 {
+			t.Fatalf("Expected byte %d to be overwritten with a different value", i)
+		}
+	}
+}
+
+func TestCorruptToxicPerChunk(t *testing.T) {
+	toxic := &toxics.CorruptToxic{Probability: 1, Per: toxics.CorruptPerChunk}
+
+	msg := []byte("hello world")
+	received := corruptChunks(toxic, msg, msg, msg)
+	for _, data := range received {
+		total := 0
+		for _, changed := range changedBits(msg, data) {
+			total += changed
+		}
+		if total != 1 {
+			t.Fatalf("Expected exactly one bit flipped per chunk, got %d in %q", total, data)
+		}
+	}
+}
+
+func TestCorruptToxicStreamWindows(t *testing.T) {
+	toxic := &toxics.CorruptToxic{
+		Probability: 1,
+		Windows:     []toxics.CorruptWindow{{Start: 3, End: 5}, {Start: 8}},
+	}
+
+	msg := []byte("abcdef")
+	received := corruptChunks(toxic, msg, msg)
+	expected := [][]int{
+		{0, 0, 0, 1, 1, 0},
+		{0, 0, 1, 1, 1, 1},
+	}
+	for i, data := range received {
+		changed := changedBits(msg, data)
+		for j := range changed {
+			if changed[j] != expected[i][j] {
+				t.Fatalf("Expected changed bits %v in chunk %d, got %v", expected[i], i, changed)
+			}
+		}
+	}
+}
+
+func TestCorruptToxicChunkWindows(t *testing.T) {
+	toxic := &toxics.CorruptToxic{
+		Probability: 1,
+		Windows:     []toxics.CorruptWindow{{Start: 0, End: 2}},
+		Offsets:     toxics.CorruptFromChunk,
+	}
+
+	msg := []byte("abcdef")
+	received := corruptChunks(toxic, msg, msg)
+	for i, data := range received {
+		if data[0] == msg[0] || data[1] == msg[1] || !bytes.Equal(data[2:], msg[2:]) {
+			t.Fatalf("Expected only the first two bytes of chunk %d to change, got %q", i, data)
+		}
+	}
+}
+
+func TestCorruptToxicSeedIsDeterministic(t *testing.T) {
+	msg := bytes.Repeat([]byte("hello world"), 100)
+
+	first := corruptChunks(&toxics.CorruptToxic{Probability: 0.1, Seed: 42}, msg)
+	second := corruptChunks(&toxics.CorruptToxic{Probability: 0.1, Seed: 42}, msg)
+	if !bytes.Equal(first[0], second[0]) {
+		t.Fatal("Expected the same seed to corrupt the same bytes")
+	}
+	if bytes.Equal(first[0], msg) {
+		t.Fatal("Expected some bytes to be corrupted")
+	}
+}
+
+func TestCorruptToxicRejectsInvalidAttributes(t *testing.T) {
+	for _, attrs := range []string{
+		`{"mode": "shuffle"}`,
+		`{"per": "packet"}`,
+		`{"offsets": "connection"}`,
+	} {
+		toxic := new(toxics.CorruptToxic)
+		err := json.Unmarshal([]byte(attrs), toxic)
+		if err != nil || toxic.Validate() == nil {
+			t.Fatalf("Expected %s to be rejected", attrs)
+		}
+	}
+}
+
+func TestCorruptToxicRejectsInvalidProbabilityAndWindows(t *testing.T) {
+	proxy := NewTestProxy("test", "localhost:20001")
+
+	for _, attributes := range []string{
+		`{"probability": -0.1}`,
+		`{"probability": 1.5}`,
+		`{"probability": 1, "windows": [{"start": 10, "end": 5}]}`,
+		`{"probability": 1, "windows": [{"start": 10, "end": 10}]}`,
+		`{"probability": 1, "windows": [{"start": -1}]}`,
+		`{"probability": 1, "windows": [{"start": 0, "end": -5}]}`,
+	} {
+		_, err := proxy.Toxics().AddToxicJson(strings.NewReader(
+			`{"type": "corrupt", "attributes": ` + attributes + `}`,
+		))
+		if err == nil {
+			t.Errorf("Expected %s to be rejected", attributes)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package toxics
+
+import (
+	"context"
+	"time"
+)
+
+// The DialDelayToxic delays dialing the upstream for new clients. When the delay
+// reaches the timeout, the dial fails after the timeout and the client is closed,
+// like when the upstream cannot be reached.
+type DialDelayToxic struct {
+	// Times in milliseconds
+	Delay   int64 `json:"delay"`
+	Timeout int64 `json:"timeout"`
+}
+
+func (t *DialDelayToxic) Pipe(stub *ToxicStub) {
+	new(NoopToxic).Pipe(stub)
+}
+
+func (t *DialDelayToxic) Dial(ctx context.Context) ConnectAction {
+	if t.Timeout > 0 && t.Delay >= t.Timeout {
+		sleep(ctx, time.Duration(t.Timeout)*time.Millisecond)
+		return ConnectClose
+	}
+	if !sleep(ctx, time.Duration(t.Delay)*time.Millisecond) {
+		return ConnectClose
+	}
+	return ConnectAllow
+}
+
+func init() {
+	Register("dial_delay", new(DialDelayToxic))
+}
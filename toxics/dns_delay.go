@@ -0,0 +1,45 @@
+package toxics
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// The DNSDelayToxic delays the resolution of the upstream hostname. When the
+// delay reaches the timeout, the resolution fails after the timeout, like when
+// the name server does not answer.
+type DNSDelayToxic struct {
+	// Times in milliseconds
+	Delay   int64 `json:"delay"`
+	Timeout int64 `json:"timeout"`
+	// Only delay this hostname, all of them when empty
+	Host string `json:"host"`
+}
+
+func (t *DNSDelayToxic) Pipe(stub *ToxicStub) {
+	new(NoopToxic).Pipe(stub)
+}
+
+func (t *DNSDelayToxic) Resolve(
+	ctx context.Context,
+	host string,
+	addrs []string,
+) ([]string, error) {
+	if !resolvesHost(t.Host, host) {
+		return addrs, nil
+	}
+
+	if t.Timeout > 0 && t.Delay >= t.Timeout {
+		sleep(ctx, time.Duration(t.Timeout)*time.Millisecond)
+		return nil, &net.DNSError{Err: "i/o timeout", Name: host, IsTimeout: true}
+	}
+	if !sleep(ctx, time.Duration(t.Delay)*time.Millisecond) {
+		return nil, ctx.Err()
+	}
+	return addrs, nil
+}
+
+func init() {
+	Register("dns_delay", new(DNSDelayToxic))
+}
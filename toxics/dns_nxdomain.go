@@ -0,0 +1,32 @@
+package toxics
+
+import (
+	"context"
+	"net"
+)
+
+// The DNSNXDomainToxic fails the resolution of the upstream hostname, as if the
+// name did not exist.
+type DNSNXDomainToxic struct {
+	// Only fail this hostname, all of them when empty
+	Host string `json:"host"`
+}
+
+func (t *DNSNXDomainToxic) Pipe(stub *ToxicStub) {
+	new(NoopToxic).Pipe(stub)
+}
+
+func (t *DNSNXDomainToxic) Resolve(
+	ctx context.Context,
+	host string,
+	addrs []string,
+) ([]string, error) {
+	if !resolvesHost(t.Host, host) {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func init() {
+	Register("dns_nxdomain", new(DNSNXDomainToxic))
+}
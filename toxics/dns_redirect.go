@@ -0,0 +1,42 @@
+package toxics
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// The DNSRedirectToxic resolves the upstream hostname to another address, as if
+// its DNS record was changed.
+type DNSRedirectToxic struct {
+	// IP address the hostname resolves to
+	Address string `json:"address"`
+	// Only redirect this hostname, all of them when empty
+	Host string `json:"host"`
+}
+
+func (t *DNSRedirectToxic) Validate() error {
+	if net.ParseIP(t.Address) == nil {
+		return fmt.Errorf("dns_redirect toxic requires an IP address, got %q", t.Address)
+	}
+	return nil
+}
+
+func (t *DNSRedirectToxic) Pipe(stub *ToxicStub) {
+	new(NoopToxic).Pipe(stub)
+}
+
+func (t *DNSRedirectToxic) Resolve(
+	ctx context.Context,
+	host string,
+	addrs []string,
+) ([]string, error) {
+	if t.Address == "" || !resolvesHost(t.Host, host) {
+		return addrs, nil
+	}
+	return []string{t.Address}, nil
+}
+
+func init() {
+	Register("dns_redirect", new(DNSRedirectToxic))
+}
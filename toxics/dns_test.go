@@ -0,0 +1,130 @@
+package toxics_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+func TestDNSNXDomainToxic(t *testing.T) {
+	ctx := context.Background()
+	addrs := []string{"10.0.0.1"}
+
+	_, err := (&toxics.DNSNXDomainToxic{}).Resolve(ctx, "db.internal", addrs)
+	if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+		t.Fatal("Expected resolution to fail with NXDOMAIN, got", err)
+	}
+
+	resolved, err := (&toxics.DNSNXDomainToxic{Host: "cache.internal"}).
+		Resolve(ctx, "db.internal", addrs)
+	if err != nil || strings.Join(resolved, ",") != "10.0.0.1" {
+		t.Fatalf("Expected other hosts to resolve, got %v: %v", resolved, err)
+	}
+}
+
+func TestDNSDelayToxic(t *testing.T) {
+	ctx := context.Background()
+	addrs := []string{"10.0.0.1"}
+
+	start := time.Now()
+	resolved, err := (&toxics.DNSDelayToxic{Delay: 50}).Resolve(ctx, "db.internal", addrs)
+	if err != nil || len(resolved) != 1 {
+		t.Fatalf("Expected delayed resolution to succeed, got %v: %v", resolved, err)
+	}
+	AssertDeltaTime(t, "DNS delay", time.Since(start), 50*time.Millisecond, 20*time.Millisecond)
+
+	start = time.Now()
+	_, err = (&toxics.DNSDelayToxic{Delay: 1000, Timeout: 50}).Resolve(ctx, "db.internal", addrs)
+	if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsTimeout {
+		t.Fatal("Expected resolution over the timeout to time out, got", err)
+	}
+	AssertDeltaTime(t, "DNS timeout", time.Since(start), 50*time.Millisecond, 20*time.Millisecond)
+
+	start = time.Now()
+	_, err = (&toxics.DNSDelayToxic{Delay: 1000, Host: "cache.internal"}).
+		Resolve(ctx, "db.internal", addrs)
+	if err != nil || time.Since(start) > 20*time.Millisecond {
+		t.Fatal("Expected other hosts to resolve right away, got", err)
+	}
+}
+
+func TestDNSRedirectToxic(t *testing.T) {
+	var toxic toxics.DNSRedirectToxic
+	if err := json.Unmarshal([]byte(`{"address": "db.internal"}`), &toxic); err != nil {
+		t.Fatal("Failed to decode toxic", err)
+	}
+	if toxic.Validate() == nil {
+		t.Fatal("Expected hostname to be rejected as an address")
+	}
+	if err := json.Unmarshal([]byte(`{"address": "10.0.0.2"}`), &toxic); err != nil {
+		t.Fatal("Failed to decode toxic", err)
+	}
+	if err := toxic.Validate(); err != nil {
+		t.Fatal("Expected IP address to be accepted", err)
+	}
+
+	resolved, err := toxic.Resolve(context.Background(), "db.internal", []string{"10.0.0.1"})
+	if err != nil || strings.Join(resolved, ",") != "10.0.0.2" {
+		t.Fatalf("Expected host to resolve to the new address, got %v: %v", resolved, err)
+	}
+}
+
+func TestDNSToxicsThroughProxy(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		toxic  string
+		closed bool
+	}{
+		{"nxdomain", `{"type": "dns_nxdomain"}`, true},
+		{"other host", `{"type": "dns_nxdomain", "attributes": {"host": "db.internal"}}`, false},
+		{"redirect", `{"type": "dns_redirect", "attributes": {"address": "127.0.0.2"}}`, true},
+		{"delay", `{"type": "dns_delay", "attributes": {"delay": 10}}`, false},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal("Failed to create TCP server", err)
+			}
+			defer ln.Close()
+
+			go func() {
+				conn, err := ln.Accept()
+				if err == nil {
+					conn.Write([]byte("hello"))
+					conn.Close()
+				}
+			}()
+
+			_, port, _ := net.SplitHostPort(ln.Addr().String())
+			proxy := NewTestProxy("test", net.JoinHostPort("localhost", port))
+			proxy.Start()
+			defer proxy.Stop()
+
+			_, err = proxy.Toxics().AddToxicJson(strings.NewReader(tc.toxic))
+			if err != nil {
+				t.Fatal("Failed to add toxic", err)
+			}
+
+			conn, err := net.Dial("tcp", proxy.Listen())
+			if err != nil {
+				t.Fatal("Unable to dial proxy", err)
+			}
+			defer conn.Close()
+
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			data, err := io.ReadAll(conn)
+			if tc.closed && (err != nil || len(data) != 0) {
+				t.Fatalf("Expected client to be closed, got %q: %v", data, err)
+			} else if !tc.closed && string(data) != "hello" {
+				t.Fatalf("Expected data from the upstream, got %q: %v", data, err)
+			}
+		})
+	}
+}
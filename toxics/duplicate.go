@@ -0,0 +1,116 @@
+package toxics
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+)
+
+// The DuplicateToxic passes every chunk through and emits a copy of it with a
+// given probability, optionally after a delay.
+type DuplicateToxic struct {
+	// Probability of duplicating a chunk, between 0 and 1
+	Probability float64 `json:"probability"`
+	// Time in milliseconds to delay the duplicate by
+	Delay int64 `json:"delay"`
+}
+
+type DuplicateToxicState struct {
+	// Duplicates waiting for their delay to pass
+	pending []*delayedChunk
+	// Fires when the earliest pending duplicate is due
+	timer *time.Timer
+}
+
+type delayedChunk struct {
+	chunk *stream.StreamChunk
+	due   time.Time
+}
+
+func (t *DuplicateToxic) Validate() error {
+	err := validateProbability("probability", t.Probability)
+	if err != nil {
+		return err
+	}
+	return validateNonNegative("delay", t.Delay)
+}
+
+// next returns a channel firing when the earliest pending duplicate is due,
+// or nil if there are no pending duplicates.
+func (s *DuplicateToxicState) next() <-chan time.Time {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	earliest := s.pending[0].due
+	for _, pending := range s.pending[1:] {
+		if pending.due.Before(earliest) {
+			earliest = pending.due
+		}
+	}
+	s.timer = resetTimer(s.timer, time.Until(earliest))
+	return s.timer.C
+}
+
+// release writes all the duplicates which are due.
+func (s *DuplicateToxicState) release(stub *ToxicStub, now time.Time) {
+	remaining := s.pending[:0]
+	for _, pending := range s.pending {
+		if pending.due.After(now) {
+			remaining = append(remaining, pending)
+		} else {
+			stub.Output <- pending.chunk
+		}
+	}
+	s.pending = remaining
+}
+
+func (t *DuplicateToxic) Pipe(stub *ToxicStub) {
+	state := stub.State.(*DuplicateToxicState)
+
+	for {
+		select {
+		case <-stub.Interrupt:
+			return
+		case now := <-state.next():
+			state.release(stub, now)
+		case c := <-stub.Input:
+			if c == nil {
+				stub.Close()
+				return
+			}
+			stub.Output <- c
+
+			//#nosec
+			if rand.Float64() >= t.Probability {
+				continue
+			}
+			duplicate := &stream.StreamChunk{
+				Data:      make([]byte, len(c.Data)),
+				Timestamp: c.Timestamp,
+			}
+			copy(duplicate.Data, c.Data)
+
+			if t.Delay <= 0 {
+				stub.Output <- duplicate
+			} else {
+				state.pending = append(state.pending, &delayedChunk{
+					chunk: duplicate,
+					due:   time.Now().Add(time.Duration(t.Delay) * time.Millisecond),
+				})
+			}
+		}
+	}
+}
+
+func (t *DuplicateToxic) NewState() interface{} {
+	return new(DuplicateToxicState)
+}
+
+func (t *DuplicateToxic) PreservesDatagrams() bool {
+	return true
+}
+
+func init() {
+	Register("duplicate", new(DuplicateToxic))
+}
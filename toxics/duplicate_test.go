@@ -0,0 +1,165 @@
+package toxics_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+type statefulToxic interface {
+	toxics.Toxic
+	toxics.StatefulToxic
+}
+
+// pipeChunks sends one single byte chunk per value through the toxic, closes
+// the input and returns the data of all the chunks the toxic wrote.
+func pipeChunks(toxic statefulToxic, values []byte) []byte {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 2*len(values))
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = toxic.NewState()
+
+	done := make(chan struct{})
+	go func() {
+		toxic.Pipe(stub)
+		close(done)
+	}()
+
+	for _, value := range values {
+		input <- &stream.StreamChunk{Data: []byte{value}}
+	}
+	close(input)
+	<-done
+
+	var received []byte
+	for c := range output {
+		received = append(received, c.Data...)
+	}
+	return received
+}
+
+func TestDuplicateToxicNoDuplicates(t *testing.T) {
+	toxic := &toxics.DuplicateToxic{Probability: 0}
+
+	received := pipeChunks(toxic, []byte{1, 2, 3})
+	if !bytes.Equal(received, []byte{1, 2, 3}) {
+		t.Fatalf("Expected chunks to pass unchanged, got %v", received)
+	}
+}
+
+func TestDuplicateToxicDuplicatesEveryChunk(t *testing.T) {
+	toxic := &toxics.DuplicateToxic{Probability: 1}
+
+	received := pipeChunks(toxic, []byte{1, 2, 3})
+	if !bytes.Equal(received, []byte{1, 1, 2, 2, 3, 3}) {
+		t.Fatalf("Expected every chunk to be duplicated, got %v", received)
+	}
+}
+
+func TestDuplicateToxicProbability(t *testing.T) {
+	toxic := &toxics.DuplicateToxic{Probability: 0.5}
+
+	received := pipeChunks(toxic, make([]byte, 10000))
+	duplicates := len(received) - 10000
+	if duplicates < 4000 || duplicates > 6000 {
+		t.Fatalf("Expected about half of 10000 chunks to be duplicated, got %d", duplicates)
+	}
+}
+
+func TestDuplicateToxicCopiesData(t *testing.T) {
+	toxic := &toxics.DuplicateToxic{Probability: 1}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 2)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = toxic.NewState()
+	go toxic.Pipe(stub)
+	defer close(input)
+
+	input <- &stream.StreamChunk{Data: []byte("hello")}
+	original := <-output
+	duplicate := <-output
+	original.Data[0] = 'j'
+
+	if string(duplicate.Data) != "hello" {
+		t.Fatalf("Expected duplicate to keep its own data, got %q", duplicate.Data)
+	}
+}
+
+func TestDuplicateToxicDelay(t *testing.T) {
+	toxic := &toxics.DuplicateToxic{Probability: 1, Delay: 50}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 2)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = toxic.NewState()
+	go toxic.Pipe(stub)
+	defer close(input)
+
+	start := time.Now()
+	input <- &stream.StreamChunk{Data: []byte("hello")}
+	<-output
+
+	select {
+	case c := <-output:
+		elapsed := time.Since(start)
+		if elapsed < 50*time.Millisecond {
+			t.Fatalf("Expected duplicate to be delayed by 50ms, got %s", elapsed)
+		}
+		if string(c.Data) != "hello" {
+			t.Fatalf("Expected duplicate of hello, got %q", c.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Duplicate was never emitted")
+	}
+}
+
+func TestDuplicateToxicKeepsPendingAcrossInterrupt(t *testing.T) {
+	toxic := &toxics.DuplicateToxic{Probability: 1, Delay: 50}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 2)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = toxic.NewState()
+
+	done := make(chan struct{})
+	go func() {
+		toxic.Pipe(stub)
+		close(done)
+	}()
+	input <- &stream.StreamChunk{Data: []byte("hello")}
+	<-output
+	stub.Interrupt <- struct{}{}
+	<-done
+
+	go toxic.Pipe(stub)
+	defer close(input)
+
+	select {
+	case c := <-output:
+		if string(c.Data) != "hello" {
+			t.Fatalf("Expected duplicate of hello, got %q", c.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Duplicate was lost after interrupt")
+	}
+}
+
+func TestDuplicateToxicRejectsInvalidProbability(t *testing.T) {
+	for _, probability := range []float64{-0.5, 1.5} {
+		toxic := &toxics.DuplicateToxic{Probability: probability}
+		if toxic.Validate() == nil {
+			t.Errorf("Expected probability %v to be rejected", probability)
+		}
+	}
+}
+
+func TestDuplicateToxicRejectsNegativeDelay(t *testing.T) {
+	toxic := &toxics.DuplicateToxic{Probability: 0.5, Delay: -10}
+	if toxic.Validate() == nil {
+		t.Error("Expected a negative delay to be rejected")
+	}
+}
@@ -0,0 +1,53 @@
+package toxics
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+// CertificateOptions describe a certificate signed for the TLS handshake of a
+// client.
+type CertificateOptions struct {
+	// Name the certificate is valid for, localhost and its addresses when empty
+	ServerName string
+	// Whether the validity of the certificate ended before it was signed
+	Expired bool
+	// Whether the certificate signs itself, instead of being signed by the CA
+	SelfSigned bool
+}
+
+// Handshake is the TLS handshake of a new client, which handshake toxics change
+// before it starts.
+type Handshake struct {
+	// Config the proxy handshakes with, a copy the toxics can change
+	Config *tls.Config
+	// Sign returns a certificate signed by the internal CA of the server
+	Sign func(options CertificateOptions) (*tls.Certificate, error)
+	// Reset makes closing the connection of the client reset it, through the
+	// connections it runs over
+	Reset func(conn net.Conn) error
+}
+
+// OnClientHello chains a function called with the ClientHello sent by the client,
+// before the server answers it. An error aborts the handshake with an alert.
+func (h *Handshake) OnClientHello(f func(hello *tls.ClientHelloInfo) error) {
+	previous := h.Config.GetConfigForClient
+	h.Config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if err := f(hello); err != nil {
+			return nil, err
+		}
+		if previous != nil {
+			return previous(hello)
+		}
+		return nil, nil
+	}
+}
+
+// Handshake toxics act on the TLS handshake with new clients of TCP proxies
+// terminating TLS, once the connection toxics let the client through. They
+// change the config of the handshake. The callbacks they set on it may block to
+// delay the handshake, but must return as soon as the context is done.
+type HandshakeToxic interface {
+	Handshake(ctx context.Context, handshake *Handshake)
+}
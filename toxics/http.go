@@ -0,0 +1,260 @@
+package toxics
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+)
+
+// The HTTPToxic parses HTTP/1.x messages, and applies to the requests matching
+// its method, path and header. On the downstream it replaces a fraction of the
+// responses to these requests with an error response, and delays them. On the
+// upstream it delays the requests themselves.
+//
+// Downstream, requests are followed on the upstream of the same connection, so
+// the toxic only applies to connections opened after it was added.
+type HTTPToxic struct {
+	// Request method, path prefix and header to match, all requests when empty
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Header as "Name: value", or "Name" to match any value
+	Header string `json:"header"`
+	// Probability of replacing the response, between 0 and 1
+	Probability float64 `json:"probability"`
+	// Status code and body of the error response, responses are only replaced
+	// when a status code is set
+	Status int    `json:"status"`
+	Body   string `json:"body"`
+	// Time in milliseconds to delay every matching request or response by
+	Latency int64 `json:"latency"`
+}
+
+type HTTPToxicState struct {
+	parser *httpParser
+	// Whether the body of the current response is dropped
+	replacing bool
+	// Whether the toxic joined the connection too late to follow its requests
+	passthrough bool
+
+	// Requests seen on the upstream, waiting for their response
+	sync.Mutex
+	requests   []*http.Request
+	connection *Connection
+}
+
+func (t *HTTPToxic) Validate() error {
+	err := validateProbability("probability", t.Probability)
+	if err != nil {
+		return err
+	}
+	if t.Status == 0 {
+		return nil
+	}
+	// Informational responses would leave the request without a final response.
+	if t.Status < 200 || t.Status > 599 {
+		return fmt.Errorf("invalid http status %d, must be between 200 and 599", t.Status)
+	}
+	if t.Probability == 0 {
+		return fmt.Errorf("http toxic with status %d requires a probability above 0", t.Status)
+	}
+	return nil
+}
+
+// matches reports whether the toxic applies to the request. Only toxics without
+// criteria apply when the request is not known.
+func (t *HTTPToxic) matches(request *http.Request) bool {
+	if request == nil {
+		return t.Method == "" && t.Path == "" && t.Header == ""
+	}
+	if t.Method != "" && !strings.EqualFold(request.Method, t.Method) {
+		return false
+	}
+	if t.Path != "" && !strings.HasPrefix(request.URL.Path, t.Path) {
+		return false
+	}
+	if t.Header != "" {
+		header := strings.SplitN(t.Header, ":", 2)
+		values := request.Header.Values(strings.TrimSpace(header[0]))
+		if len(values) == 0 {
+			return false
+		}
+		if len(header) == 2 && !containsString(values, strings.TrimSpace(header[1])) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// response builds the error response replacing the response to the request.
+func (t *HTTPToxic) response(request *http.Request, close bool) []byte {
+	var response bytes.Buffer
+	fmt.Fprintf(&response, "HTTP/1.1 %d %s\r\n", t.Status, http.StatusText(t.Status))
+	response.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&response, "Content-Length: %d\r\n", len(t.Body))
+	if close {
+		response.WriteString("Connection: close\r\n")
+	}
+	response.WriteString("\r\n")
+	if request == nil || request.Method != http.MethodHead {
+		response.WriteString(t.Body)
+	}
+	return response.Bytes()
+}
+
+func (t *HTTPToxic) Pipe(stub *ToxicStub) {
+	state := stub.State.(*HTTPToxicState)
+
+	for {
+		select {
+		case <-stub.Interrupt:
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				stub.Close()
+				return
+			}
+			if state.passthrough {
+				stub.Output <- c
+			} else if t.handle(stub, state, c) {
+				return
+			}
+		}
+	}
+}
+
+// handle passes the messages in the chunk through, delaying and replacing the
+// matching ones. It returns true if the toxic was interrupted.
+func (t *HTTPToxic) handle(stub *ToxicStub, state *HTTPToxicState, c *stream.StreamChunk) bool {
+	var output []byte
+	interrupted := false
+
+	for _, part := range state.parser.parse(c.Data) {
+		switch part.kind {
+		case httpPartHead:
+			interim := part.response != nil && part.response.StatusCode < 200 &&
+				part.response.StatusCode != http.StatusSwitchingProtocols
+			matched := !interim && t.matches(part.request)
+
+			if matched && t.Latency > 0 && !interrupted {
+				if len(output) > 0 {
+					stub.Output <- &stream.StreamChunk{Data: output, Timestamp: c.Timestamp}
+					output = nil
+				}
+				select {
+				case <-time.After(time.Duration(t.Latency) * time.Millisecond):
+				case <-stub.Interrupt:
+					// Finish the chunk without applying latency.
+					interrupted = true
+				}
+			}
+
+			//#nosec
+			state.replacing = matched && part.response != nil && t.Status != 0 &&
+				rand.Float64() < t.Probability
+			if state.replacing {
+				output = append(output, t.response(part.request, part.close)...)
+			} else {
+				output = append(output, part.data...)
+			}
+		case httpPartBody:
+			if !state.replacing {
+				output = append(output, part.data...)
+			}
+		default:
+			state.replacing = false
+			output = append(output, part.data...)
+		}
+	}
+
+	if len(output) == 0 {
+		return interrupted
+	}
+	chunk := &stream.StreamChunk{Data: output, Timestamp: c.Timestamp}
+	if !interrupted {
+		stub.Output <- chunk
+		return false
+	}
+
+	err := stub.WriteOutput(chunk, 5*time.Second) // Don't drop any data on the floor
+	if err != nil {
+		log.Warn().
+			Str("component", "HTTPToxic").
+			Str("method", "Pipe").
+			Err(err).
+			Msg("Could not write last packets after interrupt to Output")
+	}
+	return true
+}
+
+// Attach follows the requests on the upstream, to know which requests the
+// responses on the downstream answer.
+func (t *HTTPToxic) Attach(stub *ToxicStub, connection *Connection, direction stream.Direction) {
+	if direction != stream.Downstream {
+		return
+	}
+	state := stub.State.(*HTTPToxicState)
+
+	requests := new(httpParser)
+	fresh := connection.Observe(stream.Upstream, state, func(data []byte) {
+		for _, part := range requests.parse(data) {
+			if part.kind == httpPartHead && part.request != nil {
+				state.Lock()
+				state.requests = append(state.requests, part.request)
+				state.Unlock()
+			}
+		}
+	})
+	state.connection = connection
+
+	if !fresh {
+		connection.StopObserving(stream.Upstream, state)
+		state.passthrough = true
+	}
+}
+
+func (t *HTTPToxic) Cleanup(stub *ToxicStub) {
+	state := stub.State.(*HTTPToxicState)
+	if state.connection != nil {
+		state.connection.StopObserving(stream.Upstream, state)
+	}
+}
+
+func (t *HTTPToxic) NewState() interface{} {
+	state := new(HTTPToxicState)
+	state.parser = &httpParser{request: state.nextRequest}
+	return state
+}
+
+// nextRequest returns the oldest request still waiting for its response.
+func (s *HTTPToxicState) nextRequest() *http.Request {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.requests) == 0 {
+		return nil
+	}
+	request := s.requests[0]
+	s.requests = s.requests[1:]
+	return request
+}
+
+func init() {
+	Register("http", new(HTTPToxic))
+}
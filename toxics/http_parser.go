@@ -0,0 +1,261 @@
+package toxics
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"strconv"
+)
+
+// httpParser splits an HTTP/1.x stream into the heads and bodies of the messages
+// it carries, so messages can be told apart however the stream is chunked. Data
+// it cannot parse, and tunnels set up with CONNECT or upgrades, are passed on as
+// raw parts.
+type httpParser struct {
+	state  httpParserState
+	buffer []byte
+	// Bytes left in a body with a length, or in the current chunk
+	remaining int64
+	// Returns the request answered by the next response, it is called once for
+	// every final response parsed.
+	request func() *http.Request
+}
+
+type httpParserState int
+
+const (
+	httpHead httpParserState = iota
+	httpBody
+	httpChunkSize
+	httpChunkData
+	httpTrailer
+	httpUntilClose
+	httpRaw
+)
+
+// Heads are never split, and limited in size like in net/http.
+const maxHTTPHeadSize = http.DefaultMaxHeaderBytes
+
+type httpPartKind int
+
+const (
+	httpPartHead httpPartKind = iota
+	httpPartBody
+	httpPartRaw
+)
+
+type httpPart struct {
+	kind httpPartKind
+	data []byte
+	// The request for request heads, or the answered request for response heads
+	// when it is known
+	request *http.Request
+	// The response for response heads
+	response *http.Response
+	// Whether the connection is closed after the response
+	close bool
+}
+
+var (
+	crlf          = []byte("\r\n")
+	emptyLine     = []byte("\r\n\r\n")
+	chunkedCoding = "chunked"
+)
+
+func (p *httpParser) parse(data []byte) []httpPart {
+	var parts []httpPart
+	for len(data) > 0 {
+		var n int
+		var part *httpPart
+
+		switch p.state {
+		case httpHead:
+			n, part = p.parseHead(data)
+		case httpBody, httpChunkData:
+			n = len(data)
+			if int64(n) > p.remaining {
+				n = int(p.remaining)
+			}
+			p.remaining -= int64(n)
+			part = &httpPart{kind: httpPartBody, data: data[:n]}
+			if p.remaining == 0 && p.state == httpBody {
+				p.state = httpHead
+			} else if p.remaining == 0 {
+				p.state = httpChunkSize
+			}
+		case httpChunkSize:
+			n, part = p.parseChunkSize(data)
+		case httpTrailer:
+			n, part = p.parseTrailer(data)
+		case httpUntilClose:
+			n = len(data)
+			part = &httpPart{kind: httpPartBody, data: data}
+		default:
+			n = len(data)
+			part = &httpPart{kind: httpPartRaw, data: data}
+		}
+
+		data = data[n:]
+		if part != nil {
+			parts = append(parts, *part)
+		}
+	}
+	return parts
+}
+
+// until buffers data until the buffer ends with the delimiter. It returns the
+// number of bytes consumed from data, and the buffered bytes once complete.
+func (p *httpParser) until(data []byte, delimiter []byte) (int, []byte) {
+	buffered := len(p.buffer)
+	p.buffer = append(p.buffer, data...)
+
+	i := bytes.Index(p.buffer, delimiter)
+	if i < 0 {
+		return len(data), nil
+	}
+	end := i + len(delimiter)
+	complete := p.buffer[:end]
+	p.buffer = nil
+	return end - buffered, complete
+}
+
+// raw gives up on parsing, all data from now on is passed as is.
+func (p *httpParser) raw(data []byte) *httpPart {
+	p.state = httpRaw
+	p.buffer = nil
+	return &httpPart{kind: httpPartRaw, data: data}
+}
+
+func (p *httpParser) parseHead(data []byte) (int, *httpPart) {
+	n, head := p.until(data, emptyLine)
+	if head == nil {
+		if len(p.buffer) > maxHTTPHeadSize {
+			return n, p.raw(p.buffer)
+		}
+		return n, nil
+	}
+
+	if bytes.HasPrefix(head, []byte("HTTP/")) {
+		return n, p.parseResponseHead(head)
+	}
+
+	request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(head)))
+	if err != nil {
+		return n, p.raw(head)
+	}
+
+	switch {
+	case request.Method == http.MethodConnect:
+		p.state = httpRaw
+	case isChunked(request.TransferEncoding):
+		p.state = httpChunkSize
+	case request.ContentLength > 0:
+		p.state = httpBody
+		p.remaining = request.ContentLength
+	}
+	return n, &httpPart{kind: httpPartHead, data: head, request: request}
+}
+
+func (p *httpParser) parseResponseHead(head []byte) *httpPart {
+	response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(head)), nil)
+	if err != nil {
+		return p.raw(head)
+	}
+	response.Body.Close()
+
+	status := response.StatusCode
+	interim := status >= 100 && status < 200 && status != http.StatusSwitchingProtocols
+
+	var request *http.Request
+	if !interim && p.request != nil {
+		request = p.request()
+	}
+	noBody := status == http.StatusNoContent || status == http.StatusNotModified ||
+		interim || (request != nil && request.Method == http.MethodHead)
+	connect := request != nil && request.Method == http.MethodConnect &&
+		status >= 200 && status < 300
+
+	switch {
+	case status == http.StatusSwitchingProtocols || connect:
+		p.state = httpRaw
+	case noBody:
+		p.state = httpHead
+	case isChunked(response.TransferEncoding):
+		p.state = httpChunkSize
+	case response.ContentLength > 0:
+		p.state = httpBody
+		p.remaining = response.ContentLength
+	case response.ContentLength < 0:
+		p.state = httpUntilClose
+	}
+
+	return &httpPart{
+		kind:     httpPartHead,
+		data:     head,
+		request:  request,
+		response: response,
+		close:    response.Close || p.state == httpUntilClose,
+	}
+}
+
+func (p *httpParser) parseChunkSize(data []byte) (int, *httpPart) {
+	n, line := p.until(data, crlf)
+	if line == nil {
+		if len(p.buffer) > maxHTTPHeadSize {
+			return n, p.raw(p.buffer)
+		}
+		return n, nil
+	}
+
+	size := bytes.TrimSpace(line)
+	if i := bytes.IndexByte(size, ';'); i >= 0 {
+		size = bytes.TrimSpace(size[:i])
+	}
+	length, err := strconv.ParseInt(string(size), 16, 64)
+	if err != nil || length < 0 {
+		return n, p.raw(line)
+	}
+
+	if length == 0 {
+		p.state = httpTrailer
+	} else {
+		// The chunk data is followed by a CRLF.
+		p.state = httpChunkData
+		p.remaining = length + int64(len(crlf))
+	}
+	return n, &httpPart{kind: httpPartBody, data: line}
+}
+
+func (p *httpParser) parseTrailer(data []byte) (int, *httpPart) {
+	// The trailer is either empty, or header lines followed by an empty line.
+	buffered := len(p.buffer)
+	p.buffer = append(p.buffer, data...)
+
+	end := -1
+	if bytes.HasPrefix(p.buffer, crlf) {
+		end = len(crlf)
+	} else if i := bytes.Index(p.buffer, emptyLine); i >= 0 {
+		end = i + len(emptyLine)
+	}
+
+	if end < 0 {
+		if len(p.buffer) > maxHTTPHeadSize {
+			return len(data), p.raw(p.buffer)
+		}
+		return len(data), nil
+	}
+
+	trailer := p.buffer[:end]
+	p.buffer = nil
+	p.state = httpHead
+	return end - buffered, &httpPart{kind: httpPartBody, data: trailer}
+}
+
+func isChunked(codings []string) bool {
+	for _, coding := range codings {
+		if coding == chunkedCoding {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+package toxics_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+const (
+	okResponse      = "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"
+	chunkedResponse = "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5\r\nhello\r\n6;ext=1\r\n world\r\n0\r\nX-Trailer: 1\r\n\r\n"
+	errorResponse = "HTTP/1.1 503 Service Unavailable\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\nContent-Length: 4\r\n\r\ndown"
+)
+
+// pipeHTTP sends the data through the toxic in small chunks, so messages span
+// several chunks, and returns all the data written by the toxic.
+func pipeHTTP(
+	t *testing.T,
+	toxic *toxics.HTTPToxic,
+	connection *toxics.Connection,
+	requests, responses string,
+) string {
+	t.Helper()
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, len(responses))
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = toxic.NewState()
+	if connection != nil {
+		toxic.Attach(stub, connection, stream.Downstream)
+		connection.Read(stream.Upstream, []byte(requests))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		toxic.Pipe(stub)
+		close(done)
+	}()
+
+	for i := 0; i < len(responses); i += 3 {
+		end := i + 3
+		if end > len(responses) {
+			end = len(responses)
+		}
+		input <- &stream.StreamChunk{Data: []byte(responses[i:end])}
+	}
+	close(input)
+	<-done
+
+	var received string
+	for c := range output {
+		received += string(c.Data)
+	}
+	return received
+}
+
+func TestHTTPToxicPassesResponsesThrough(t *testing.T) {
+	toxic := &toxics.HTTPToxic{}
+
+	responses := okResponse + chunkedResponse + okResponse
+	received := pipeHTTP(t, toxic, nil, "", responses)
+	if received != responses {
+		t.Fatalf("Expected responses to pass unchanged, got %q", received)
+	}
+}
+
+func TestHTTPToxicReplacesResponses(t *testing.T) {
+	toxic := &toxics.HTTPToxic{Probability: 1, Status: 503, Body: "down"}
+
+	received := pipeHTTP(t, toxic, nil, "", okResponse+chunkedResponse+okResponse)
+	expected := errorResponse + errorResponse + errorResponse
+	if received != expected {
+		t.Fatalf("Expected every response to be replaced, got %q", received)
+	}
+}
+
+func TestHTTPToxicMatchesRequests(t *testing.T) {
+	toxic := &toxics.HTTPToxic{
+		Method:      "POST",
+		Path:        "/fail",
+		Header:      "X-Test: yes",
+		Probability: 1,
+		Status:      503,
+		Body:        "down",
+	}
+
+	requests := "POST /fail HTTP/1.1\r\nHost: a\r\nX-Test: yes\r\nContent-Length: 3\r\n\r\nabc" +
+		"GET /fail HTTP/1.1\r\nHost: a\r\nX-Test: yes\r\n\r\n" +
+		"POST /fail/deeper HTTP/1.1\r\nHost: a\r\nX-Test: yes\r\n" +
+		"Transfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n" +
+		"POST /fail HTTP/1.1\r\nHost: a\r\nX-Test: no\r\nContent-Length: 0\r\n\r\n" +
+		"POST /fail HTTP/1.1\r\nHost: a\r\nX-Test: no\r\nX-Test: yes\r\nContent-Length: 0\r\n\r\n"
+	responses := okResponse + chunkedResponse + okResponse + okResponse + okResponse
+
+	received := pipeHTTP(t, toxic, toxics.NewConnection(), requests, responses)
+	expected := errorResponse + chunkedResponse + errorResponse + okResponse + errorResponse
+	if received != expected {
+		t.Fatalf("Expected only matching responses to be replaced, got %q", received)
+	}
+}
+
+func TestHTTPToxicFramesHeadAndInterimResponses(t *testing.T) {
+	toxic := &toxics.HTTPToxic{Probability: 1, Status: 503, Body: "down"}
+
+	requests := "HEAD / HTTP/1.1\r\nHost: a\r\n\r\n" +
+		"PUT / HTTP/1.1\r\nHost: a\r\nExpect: 100-continue\r\nContent-Length: 1\r\n\r\nx"
+	responses := "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n" +
+		"HTTP/1.1 100 Continue\r\n\r\n" + okResponse
+
+	received := pipeHTTP(t, toxic, toxics.NewConnection(), requests, responses)
+	expected := strings.TrimSuffix(errorResponse, "down") +
+		"HTTP/1.1 100 Continue\r\n\r\n" + errorResponse
+	if received != expected {
+		t.Fatalf("Expected HEAD and interim responses to be framed, got %q", received)
+	}
+}
+
+func TestHTTPToxicClosesResponsesUntilClose(t *testing.T) {
+	toxic := &toxics.HTTPToxic{Probability: 1, Status: 503, Body: "down"}
+
+	received := pipeHTTP(t, toxic, nil, "", "HTTP/1.0 200 OK\r\n\r\nhello until close")
+	expected := strings.Replace(errorResponse, "\r\n\r\n", "\r\nConnection: close\r\n\r\n", 1)
+	if received != expected {
+		t.Fatalf("Expected error response closing the connection, got %q", received)
+	}
+}
+
+func TestHTTPToxicIgnoresConnectionsJoinedLate(t *testing.T) {
+	toxic := &toxics.HTTPToxic{Probability: 1, Status: 503, Body: "down"}
+
+	connection := toxics.NewConnection()
+	connection.Read(stream.Upstream, []byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+
+	received := pipeHTTP(t, toxic, connection, "", okResponse)
+	if received != okResponse {
+		t.Fatalf("Expected response to pass unchanged, got %q", received)
+	}
+}
+
+func TestHTTPToxicLatency(t *testing.T) {
+	toxic := &toxics.HTTPToxic{Latency: 50}
+
+	start := time.Now()
+	received := pipeHTTP(t, toxic, nil, "", okResponse+okResponse)
+	if received != okResponse+okResponse {
+		t.Fatalf("Expected responses to pass unchanged, got %q", received)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("Expected both responses to be delayed by 50ms, took %s", elapsed)
+	}
+}
+
+func TestHTTPToxicRejectsInvalidStatus(t *testing.T) {
+	toxic := new(toxics.HTTPToxic)
+	err := json.Unmarshal([]byte(`{"status": 100}`), toxic)
+	if err != nil || toxic.Validate() == nil {
+		t.Fatal("Expected informational status to be rejected")
+	}
+}
+
+func TestHTTPToxicRejectsInvalidProbability(t *testing.T) {
+	for _, toxic := range []*toxics.HTTPToxic{
+		{Probability: -0.1},
+		{Probability: 1.5, Status: 503},
+		{Probability: 0, Status: 503},
+	} {
+		if toxic.Validate() == nil {
+			t.Errorf("Expected %+v to be rejected", toxic)
+		}
+	}
+}
+
+func TestHTTPToxicThroughProxy(t *testing.T) {
+	var connections int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "real %s", r.URL.Path)
+		},
+	))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	proxy := NewTestProxy("test", server.Listener.Addr().String())
+	proxy.Start()
+	defer proxy.Stop()
+
+	_, err := proxy.Toxics().AddToxicJson(ToxicToJson(t, "", "http", "downstream",
+		&toxics.HTTPToxic{Path: "/fail", Probability: 1, Status: 503, Body: "down"}))
+	if err != nil {
+		t.Fatal("Failed to add http toxic", err)
+	}
+
+	client := &http.Client{Timeout: time.Second}
+	for _, path := range []string{"/ok", "/fail", "/ok", "/fail"} {
+		resp, err := client.Get("http://" + proxy.Listen() + path)
+		if err != nil {
+			t.Fatal("Failed to request through proxy", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal("Failed to read response body", err)
+		}
+
+		if path == "/fail" && (resp.StatusCode != 503 || string(body) != "down") {
+			t.Fatalf("Expected error response for %s, got %d %q", path, resp.StatusCode, body)
+		}
+		if path == "/ok" && (resp.StatusCode != 200 || string(body) != "real /ok") {
+			t.Fatalf("Expected real response for %s, got %d %q", path, resp.StatusCode, body)
+		}
+	}
+
+	if n := atomic.LoadInt32(&connections); n != 1 {
+		t.Fatalf("Expected keep-alive connection to be reused, got %d connections", n)
+	}
+}
@@ -1,33 +1,162 @@
 package toxics
 
 import (
+	"fmt"
+	"math"
 	"math/rand"
 	"time"
 )
 
 // The LatencyToxic passes data through with the a delay of latency +/- jitter added.
+// The delay follows the chosen distribution around the latency, with the jitter as
+// its spread.
 type LatencyToxic struct {
 	// Times in milliseconds
 	Latency int64 `json:"latency"`
 	Jitter  int64 `json:"jitter"`
+	// Distribution of the delay, uniform by default
+	Distribution LatencyDistribution `json:"distribution"`
+	// Correlation between the delays of consecutive chunks, between 0 and 1
+	Correlation float64 `json:"correlation"`
+	// Samples of the empirical distribution, in units of jitter
+	Table []float64 `json:"table"`
+}
+
+// A LatencyDistribution is the shape of the delays around the latency.
+type LatencyDistribution string
+
+const (
+	// Between latency - jitter and latency + jitter
+	DistributionUniform LatencyDistribution = "uniform"
+	// Normal, with the jitter as standard deviation
+	DistributionNormal LatencyDistribution = "normal"
+	// Pareto, a long tail above the latency
+	DistributionPareto LatencyDistribution = "pareto"
+	// A quarter normal and three quarters pareto, like netem
+	DistributionParetoNormal LatencyDistribution = "pareto_normal"
+	// Log-normal, a long tail above the latency
+	DistributionLogNormal LatencyDistribution = "log_normal"
+	// Samples drawn from the table
+	DistributionEmpirical LatencyDistribution = "empirical"
+)
+
+// Shape of the pareto distribution, as in the netem tables.
+const paretoShape = 3
+
+var (
+	paretoMean   = paretoShape / (paretoShape - 1.0)
+	paretoStdDev = math.Sqrt(paretoShape/(paretoShape-2.0)) / (paretoShape - 1.0)
+	logNormMean  = math.Exp(0.5)
+	logNormStd   = math.Sqrt((math.E - 1) * math.E)
+)
+
+type LatencyToxicState struct {
+	// Normal variable the delays are derived from, correlated across chunks
+	normal float64
+	// Independent normal variable the pareto part of pareto_normal is derived from
+	pareto float64
+	primed bool
+}
+
+func (t *LatencyToxic) Validate() error {
+	err := validateEnum("distribution", string(t.Distribution),
+		string(DistributionUniform), string(DistributionNormal), string(DistributionPareto),
+		string(DistributionParetoNormal), string(DistributionLogNormal),
+		string(DistributionEmpirical))
+	if err != nil {
+		return err
+	}
+	if t.Correlation < 0 || t.Correlation > 1 {
+		return fmt.Errorf("invalid correlation %v, must be between 0 and 1", t.Correlation)
+	}
+	if t.Distribution == DistributionEmpirical && len(t.Table) == 0 {
+		return fmt.Errorf("the empirical distribution needs a table")
+	}
+	return nil
 }
 
 func (t *LatencyToxic) GetBufferSize() int {
 	return 1024
 }
 
-func (t *LatencyToxic) delay() time.Duration {
+func (t *LatencyToxic) delay(state *LatencyToxicState) time.Duration {
 	// Delay = t.Latency +/- t.Jitter
-	delay := t.Latency
-	jitter := t.Jitter
-	if jitter > 0 {
-		//#nosec
-		delay += rand.Int63n(jitter*2) - jitter
+	delay := float64(t.Latency)
+	if t.Jitter > 0 {
+		delay += t.sample(state) * float64(t.Jitter)
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return time.Duration(delay * float64(time.Millisecond))
+}
+
+// sample returns the next value of the distribution, centered on 0 with a spread
+// of about 1.
+//
+// Every distribution is derived from a normal variable, which is correlated with
+// the one of the previous chunk. This keeps the shape of the distribution whatever
+// the correlation.
+func (t *LatencyToxic) sample(state *LatencyToxicState) float64 {
+	normal := t.correlate(state.normal, state.primed)
+	state.normal = normal
+	// The parts of pareto_normal are independent, like in netem.
+	state.pareto = t.correlate(state.pareto, state.primed)
+	state.primed = true
+
+	uniform := toUniform(normal)
+
+	switch t.Distribution {
+	case DistributionNormal:
+		return normal
+	case DistributionPareto:
+		return pareto(uniform)
+	case DistributionParetoNormal:
+		return 0.25*normal + 0.75*pareto(toUniform(state.pareto))
+	case DistributionLogNormal:
+		return (math.Exp(normal) - logNormMean) / logNormStd
+	case DistributionEmpirical:
+		if len(t.Table) == 0 {
+			return 0
+		}
+		i := int(uniform * float64(len(t.Table)))
+		if i >= len(t.Table) {
+			i = len(t.Table) - 1
+		}
+		return t.Table[i]
+	}
+	return 2*uniform - 1
+}
+
+// correlate returns a new standard normal variable, correlated with the previous
+// one once there is one.
+func (t *LatencyToxic) correlate(previous float64, primed bool) float64 {
+	//#nosec
+	normal := rand.NormFloat64()
+	if primed && t.Correlation > 0 {
+		normal = t.Correlation*previous + math.Sqrt(1-t.Correlation*t.Correlation)*normal
+	}
+	return normal
+}
+
+// toUniform maps a standard normal variable to a uniform one between 0 and 1.
+func toUniform(normal float64) float64 {
+	return 0.5 * math.Erfc(-normal/math.Sqrt2)
+}
+
+// pareto maps a uniform value to a pareto distribution with a mean of 0 and a
+// standard deviation of 1.
+func pareto(uniform float64) float64 {
+	tail := 1 - uniform
+	if tail < 1e-9 {
+		tail = 1e-9
 	}
-	return time.Duration(delay) * time.Millisecond
+	return (math.Pow(tail, -1.0/paretoShape) - paretoMean) / paretoStdDev
 }
 
 func (t *LatencyToxic) Pipe(stub *ToxicStub) {
+	state := stub.State.(*LatencyToxicState)
+
 	for {
 		select {
 		case <-stub.Interrupt:
@@ -37,7 +166,7 @@ func (t *LatencyToxic) Pipe(stub *ToxicStub) {
 				stub.Close()
 				return
 			}
-			sleep := t.delay() - time.Since(c.Timestamp)
+			sleep := t.delay(state) - time.Since(c.Timestamp)
 			select {
 			case <-time.After(sleep):
 				c.Timestamp = c.Timestamp.Add(sleep)
@@ -51,6 +180,18 @@ func (t *LatencyToxic) Pipe(stub *ToxicStub) {
 	}
 }
 
+func (t *LatencyToxic) NewState() interface{} {
+	return new(LatencyToxicState)
+}
+
+func (t *LatencyToxic) PreservesDatagrams() bool {
+	return true
+}
+
+func (t *LatencyToxic) PipesChunks() bool {
+	return true
+}
+
 func init() {
 	Register("latency", new(LatencyToxic))
 }
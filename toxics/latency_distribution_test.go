@@ -0,0 +1,142 @@
+package toxics
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// sampleDelays returns n delays of the toxic in milliseconds.
+func sampleDelays(toxic *LatencyToxic, n int) []float64 {
+	state := toxic.NewState().(*LatencyToxicState)
+	delays := make([]float64, n)
+	for i := range delays {
+		delays[i] = float64(toxic.delay(state)) / float64(time.Millisecond)
+	}
+	return delays
+}
+
+func meanAndStdDev(values []float64) (float64, float64) {
+	var sum, squares float64
+	for _, value := range values {
+		sum += value
+	}
+	mean := sum / float64(len(values))
+	for _, value := range values {
+		squares += (value - mean) * (value - mean)
+	}
+	return mean, math.Sqrt(squares / float64(len(values)))
+}
+
+// autocorrelation returns the correlation between consecutive values.
+func autocorrelation(values []float64) float64 {
+	mean, stdDev := meanAndStdDev(values)
+	var sum float64
+	for i := 1; i < len(values); i++ {
+		sum += (values[i] - mean) * (values[i-1] - mean)
+	}
+	return sum / float64(len(values)-1) / (stdDev * stdDev)
+}
+
+func TestLatencyDistributions(t *testing.T) {
+	for _, test := range []struct {
+		distribution LatencyDistribution
+		stdDev       float64
+		min          float64
+		tail         bool
+	}{
+		{"", 100 / math.Sqrt(3), 900, false},
+		{DistributionUniform, 100 / math.Sqrt(3), 900, false},
+		{DistributionNormal, 100, 0, false},
+		// The deviation of long tails is too slow to converge to be checked.
+		{DistributionPareto, 0, 1000 - 100/math.Sqrt(3), true},
+		{DistributionParetoNormal, 0, 0, true},
+		{DistributionLogNormal, 0, 1000 - 100/math.Sqrt(math.E-1), true},
+	} {
+		toxic := &LatencyToxic{Latency: 1000, Jitter: 100, Distribution: test.distribution}
+		delays := sampleDelays(toxic, 50000)
+		mean, stdDev := meanAndStdDev(delays)
+
+		if math.Abs(mean-1000) > 5 {
+			t.Errorf("%q: expected a mean of 1000ms, got %v", test.distribution, mean)
+		}
+		if test.stdDev > 0 && math.Abs(stdDev-test.stdDev) > test.stdDev/10 {
+			t.Errorf("%q: expected a deviation of %v, got %v", test.distribution, test.stdDev, stdDev)
+		}
+
+		var min, max float64 = math.Inf(1), 0
+		for _, delay := range delays {
+			min = math.Min(min, delay)
+			max = math.Max(max, delay)
+		}
+		if min < test.min-0.5 {
+			t.Errorf("%q: expected delays above %v, got %v", test.distribution, test.min, min)
+		}
+		if test.tail && max < 1500 {
+			t.Errorf("%q: expected a long tail, got a maximum of %v", test.distribution, max)
+		}
+		if !test.tail && test.distribution != DistributionNormal && max > 1100 {
+			t.Errorf("%q: expected delays under 1100, got %v", test.distribution, max)
+		}
+	}
+}
+
+func TestLatencyEmpiricalDistribution(t *testing.T) {
+	toxic := &LatencyToxic{
+		Latency:      1000,
+		Jitter:       100,
+		Distribution: DistributionEmpirical,
+		Table:        []float64{-1, 1, 1, 1},
+	}
+
+	counts := make(map[float64]int)
+	for _, delay := range sampleDelays(toxic, 10000) {
+		counts[delay]++
+	}
+	if len(counts) != 2 || counts[900] < 2200 || counts[900] > 2800 {
+		t.Fatalf("Expected a quarter of the delays at 900ms and the rest at 1100ms, got %v", counts)
+	}
+}
+
+func TestLatencyCorrelation(t *testing.T) {
+	for _, distribution := range []LatencyDistribution{DistributionUniform, DistributionPareto} {
+		toxic := &LatencyToxic{Latency: 1000, Jitter: 100, Distribution: distribution}
+		if ac := autocorrelation(sampleDelays(toxic, 20000)); math.Abs(ac) > 0.05 {
+			t.Errorf("%q: expected independent delays, got a correlation of %v", distribution, ac)
+		}
+
+		toxic.Correlation = 0.9
+		delays := sampleDelays(toxic, 20000)
+		if ac := autocorrelation(delays); ac < 0.7 {
+			t.Errorf("%q: expected correlated delays, got a correlation of %v", distribution, ac)
+		}
+		// The correlation keeps the distribution.
+		if mean, _ := meanAndStdDev(delays); math.Abs(mean-1000) > 15 {
+			t.Errorf("%q: expected a mean of 1000ms, got %v", distribution, mean)
+		}
+	}
+}
+
+func TestLatencyParetoNormalHasIndependentParts(t *testing.T) {
+	toxic := &LatencyToxic{Distribution: DistributionParetoNormal}
+	state := toxic.NewState().(*LatencyToxicState)
+
+	samples := make([]float64, 20000)
+	normals := make([]float64, len(samples))
+	for i := range samples {
+		samples[i] = toxic.sample(state)
+		normals[i] = state.normal
+	}
+
+	// Only a quarter of the sample comes from the normal part.
+	mean, stdDev := meanAndStdDev(samples)
+	normalMean, normalStdDev := meanAndStdDev(normals)
+	var covariance float64
+	for i := range samples {
+		covariance += (samples[i] - mean) * (normals[i] - normalMean)
+	}
+	correlation := covariance / float64(len(samples)) / (stdDev * normalStdDev)
+	if correlation > 0.5 {
+		t.Fatalf("Expected the pareto part to be independent, got a correlation of %v", correlation)
+	}
+}
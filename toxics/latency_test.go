@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"io"
 	"net"
 	"strconv"
@@ -270,3 +271,24 @@ func TestLatencyToxicBandwidth(t *testing.T) {
 		t.Error("Failed to close TCP connection", err)
 	}
 }
+
+func TestLatencyToxicRejectsInvalidAttributes(t *testing.T) {
+	for _, attributes := range []string{
+		`{"distribution": "gamma"}`,
+		`{"correlation": 1.5}`,
+		`{"distribution": "empirical"}`,
+		`{"latency": 200, "correlation": -0.1}`,
+	} {
+		toxic := &toxics.LatencyToxic{Latency: 100}
+		err := json.Unmarshal([]byte(attributes), toxic)
+		if err != nil || toxic.Validate() == nil {
+			t.Errorf("Expected %s to be rejected", attributes)
+		}
+	}
+
+	toxic := &toxics.LatencyToxic{Latency: 100}
+	err := json.Unmarshal([]byte(`{"distribution": "empirical", "table": [0, 1]}`), toxic)
+	if err != nil || toxic.Validate() != nil {
+		t.Fatal("Expected empirical distribution with a table to be accepted", err)
+	}
+}
@@ -3,8 +3,11 @@ package toxics_test
 import (
 	"bytes"
 	"crypto/rand"
+	"net/http"
+	"strings"
 	"testing"
 
+	"github.com/Shopify/toxiproxy/v2"
 	"github.com/Shopify/toxiproxy/v2/stream"
 	"github.com/Shopify/toxiproxy/v2/toxics"
 )
@@ -157,3 +160,31 @@ func TestLimitDataToxicLimitIsSetToZero(t *testing.T) {
 
 	check(t, toxic, [][]byte{buf}, [][]byte{})
 }
+
+func TestLimitDataToxicRejectsChunkMode(t *testing.T) {
+	proxy := NewTestProxy("test", "localhost:20001")
+
+	// Every chunk would go through a new limit, so the limit would never be reached.
+	_, err := proxy.Toxics().AddToxicJson(strings.NewReader(
+		`{"type": "limit_data", "toxicity_mode": "chunk", "attributes": {"bytes": 100}}`,
+	))
+	if apiErr, ok := err.(*toxiproxy.ApiError); !ok || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected chunk mode to be rejected with a bad request, got %v", err)
+	}
+
+	toxic, err := proxy.Toxics().AddToxicJson(strings.NewReader(
+		`{"type": "limit_data", "attributes": {"bytes": 100}}`,
+	))
+	if err != nil {
+		t.Fatal("Failed to add toxic:", err)
+	}
+	_, err = proxy.Toxics().UpdateToxicJson(toxic.Name, strings.NewReader(
+		`{"toxicity_mode": "chunk"}`,
+	))
+	if apiErr, ok := err.(*toxiproxy.ApiError); !ok || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected an update to chunk mode to be rejected with a bad request, got %v", err)
+	}
+	if toxic.ToxicityMode != "" {
+		t.Fatalf("Expected the toxicity mode to be unchanged, got %q", toxic.ToxicityMode)
+	}
+}
@@ -0,0 +1,267 @@
+package toxics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+)
+
+// A Match restricts a toxic to some connections, by the address of the client,
+// by the destination requested from a forward proxy or by the data the client
+// sends first. All the criteria set must match.
+type Match struct {
+	// Client address, or network in CIDR notation
+	Client string `json:"client"`
+	// Client port, or range of ports as "from-to"
+	Ports string `json:"ports"`
+	// Literal the data sent by the client starts with
+	Prefix string `json:"prefix"`
+	// Regular expression found in the first bytes sent by the client
+	Regex string `json:"regex"`
+	// Number of bytes the regular expression is matched against
+	Bytes int `json:"bytes"`
+	// Destination host, or "*." followed by the domain of the hosts
+	Destination string `json:"destination"`
+	// Destination port, or range of ports as "from-to"
+	DestinationPorts string `json:"destination_ports"`
+
+	network            *net.IPNet
+	portMin            int
+	portMax            int
+	destinationPortMin int
+	destinationPortMax int
+	pattern            *regexp.Regexp
+}
+
+const (
+	defaultMatchBytes = 1024
+	maxMatchBytes     = 64 * 1024
+)
+
+func (m *Match) UnmarshalJSON(data []byte) error {
+	type attributes Match
+	var decoded attributes
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return err
+	}
+
+	match := Match(decoded)
+	if match.Client != "" {
+		match.network, err = parseNetwork(match.Client)
+		if err != nil {
+			return err
+		}
+	}
+	if match.Ports != "" {
+		match.portMin, match.portMax, err = parsePorts(match.Ports)
+		if err != nil {
+			return err
+		}
+	}
+	if match.DestinationPorts != "" {
+		match.destinationPortMin, match.destinationPortMax, err = parsePorts(match.DestinationPorts)
+		if err != nil {
+			return err
+		}
+	}
+	if match.Regex != "" {
+		match.pattern, err = regexp.Compile(match.Regex)
+		if err != nil {
+			return fmt.Errorf("invalid match regex: %v", err)
+		}
+	}
+	if match.Bytes < 0 || match.Bytes > maxMatchBytes {
+		return fmt.Errorf("invalid match bytes %d, must be at most %d", match.Bytes, maxMatchBytes)
+	}
+
+	*m = match
+	return nil
+}
+
+// parseNetwork parses an address or a network in CIDR notation.
+func parseNetwork(client string) (*net.IPNet, error) {
+	if strings.Contains(client, "/") {
+		_, network, err := net.ParseCIDR(client)
+		if err != nil {
+			return nil, fmt.Errorf("invalid match client %q", client)
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(client)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid match client %q", client)
+	}
+	bits := 8 * len(ip.To4())
+	if bits == 0 {
+		bits = 8 * net.IPv6len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// parsePorts parses a port or a range of ports.
+func parsePorts(ports string) (int, int, error) {
+	bounds := strings.SplitN(ports, "-", 2)
+	min, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid match ports %q", ports)
+	}
+	max := min
+	if len(bounds) == 2 {
+		max, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid match ports %q", ports)
+		}
+	}
+	if min < 0 || max > 65535 || min > max {
+		return 0, 0, fmt.Errorf("invalid match ports %q", ports)
+	}
+	return min, max, nil
+}
+
+// MatchesData reports whether the match has criteria on the data.
+func (m *Match) MatchesData() bool {
+	return m.Prefix != "" || m.pattern != nil
+}
+
+// MatchesClient reports whether the client address matches the criteria on it.
+// Clients with an unknown address never match them.
+func (m *Match) MatchesClient(ip net.IP, port int) bool {
+	if m.network != nil && (ip == nil || !m.network.Contains(ip)) {
+		return false
+	}
+	if m.Ports != "" && (port < m.portMin || port > m.portMax) {
+		return false
+	}
+	return true
+}
+
+// MatchesDestination reports whether the destination requested by the client
+// matches the criteria on it. Clients of proxies which are not forward proxies
+// request no destination, they never match them.
+func (m *Match) MatchesDestination(host string, port int) bool {
+	if m.Destination != "" && !matchesHost(m.Destination, host) {
+		return false
+	}
+	if m.DestinationPorts != "" &&
+		(host == "" || port < m.destinationPortMin || port > m.destinationPortMax) {
+		return false
+	}
+	return true
+}
+
+// matchesHost reports whether the host is the one of the pattern, or one of its
+// subdomains when the pattern starts with "*.".
+func matchesHost(pattern, host string) bool {
+	if host == "" {
+		return false
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		return len(host) > len(pattern)-1 &&
+			strings.EqualFold(host[len(host)-len(pattern)+1:], pattern[1:])
+	}
+	return strings.EqualFold(pattern, host)
+}
+
+func (m *Match) bytes() int {
+	if m.Bytes > 0 {
+		return m.Bytes
+	}
+	return defaultMatchBytes
+}
+
+// matchData matches the first bytes sent by the client. It returns whether they
+// match, and whether more data could change the result unless the data is final.
+func (m *Match) matchData(data []byte, final bool) (bool, bool) {
+	if m.Prefix != "" {
+		prefix := []byte(m.Prefix)
+		if len(data) < len(prefix) {
+			if final || !bytes.HasPrefix(prefix, data) {
+				return false, true
+			}
+			return false, false
+		}
+		if !bytes.HasPrefix(data, prefix) {
+			return false, true
+		}
+	}
+
+	if m.pattern != nil {
+		if len(data) > m.bytes() {
+			data = data[:m.bytes()]
+		}
+		if m.pattern.Match(data) {
+			return true, true
+		}
+		return false, final || len(data) >= m.bytes()
+	}
+	return true, true
+}
+
+// matchState follows whether the connection of a stub matches the toxic. The
+// decision is made once, as soon as enough data was sent by the client or once
+// the client sent all its data.
+type matchState struct {
+	sync.Mutex
+
+	match   *Match
+	data    []byte
+	decided bool
+	matched bool
+}
+
+// newMatchState decides on the client address right away, and follows the data
+// sent by the client when the match has criteria on it.
+func newMatchState(match *Match, connection *Connection) *matchState {
+	state := &matchState{match: match}
+
+	if connection == nil ||
+		!match.MatchesClient(connection.Client, connection.ClientPort) ||
+		!match.MatchesDestination(connection.Destination, connection.DestinationPort) {
+		state.decided = true
+		return state
+	}
+	if !match.MatchesData() {
+		state.decided = true
+		state.matched = true
+		return state
+	}
+
+	fresh := connection.Observe(stream.Upstream, state, state.observe)
+	if !fresh {
+		// The start of the data was missed.
+		connection.StopObserving(stream.Upstream, state)
+		state.decided = true
+	}
+	return state
+}
+
+func (s *matchState) observe(data []byte) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.decided {
+		return
+	}
+	s.data = append(s.data, data...)
+	s.matched, s.decided = s.match.matchData(s.data, data == nil)
+	if s.decided {
+		s.data = nil
+	}
+}
+
+// result returns whether the connection matches, and whether it was decided.
+func (s *matchState) result() (bool, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.matched, s.decided
+}
@@ -0,0 +1,46 @@
+package toxics
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+)
+
+func TestMatchStateDecidedWhenDataEnds(t *testing.T) {
+	for _, data := range []string{
+		`{"prefix": "GET /index"}`,
+		`{"regex": "Host: example"}`,
+	} {
+		match := new(Match)
+		if err := json.Unmarshal([]byte(data), match); err != nil {
+			t.Fatal("Failed to decode match", err)
+		}
+		connection := NewConnection()
+		connection.Client = net.ParseIP("127.0.0.1")
+		state := newMatchState(match, connection)
+
+		// The client sends less data than the match needs, then closes.
+		connection.Read(stream.Upstream, []byte("GET"))
+		if _, decided := state.result(); decided {
+			t.Fatalf("Expected %s to wait for more data", data)
+		}
+		connection.End(stream.Upstream)
+		if matched, decided := state.result(); !decided || matched {
+			t.Fatalf("Expected %s to not match once the data ended", data)
+		}
+	}
+}
+
+func TestMatchStateObservesEndedConnection(t *testing.T) {
+	match := &Match{Prefix: "GET"}
+	connection := NewConnection()
+	connection.Client = net.ParseIP("127.0.0.1")
+	connection.End(stream.Upstream)
+
+	state := newMatchState(match, connection)
+	if matched, decided := state.result(); !decided || matched {
+		t.Fatal("Expected a client which sent nothing to not match")
+	}
+}
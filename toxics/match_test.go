@@ -0,0 +1,302 @@
+package toxics_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+func decodeMatch(t *testing.T, data string) *toxics.Match {
+	var match *toxics.Match
+	if err := json.Unmarshal([]byte(data), &match); err != nil {
+		t.Fatalf("Failed to decode match %s: %v", data, err)
+	}
+	return match
+}
+
+func TestMatchClient(t *testing.T) {
+	testCases := []struct {
+		name    string
+		match   string
+		ip      string
+		port    int
+		matched bool
+	}{
+		{"network", `{"client":"10.0.0.0/8"}`, "10.1.2.3", 5000, true},
+		{"outside network", `{"client":"10.0.0.0/8"}`, "192.168.0.1", 5000, false},
+		{"address", `{"client":"127.0.0.1"}`, "127.0.0.1", 5000, true},
+		{"other address", `{"client":"127.0.0.1"}`, "127.0.0.2", 5000, false},
+		{"ipv6", `{"client":"::1"}`, "::1", 5000, true},
+		{"port range", `{"ports":"4000-6000"}`, "127.0.0.1", 5000, true},
+		{"outside port range", `{"ports":"4000-6000"}`, "127.0.0.1", 7000, false},
+		{"single port", `{"ports":"5000"}`, "127.0.0.1", 5000, true},
+		{"unknown client", `{"client":"0.0.0.0/0"}`, "", 0, false},
+		{"no criteria", `{}`, "", 0, true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			match := decodeMatch(t, tc.match)
+			if matched := match.MatchesClient(net.ParseIP(tc.ip), tc.port); matched != tc.matched {
+				t.Fatalf("Expected client %s:%d to match %v, got %v", tc.ip, tc.port, tc.matched, matched)
+			}
+		})
+	}
+}
+
+func TestMatchDestination(t *testing.T) {
+	testCases := []struct {
+		name    string
+		match   string
+		host    string
+		port    int
+		matched bool
+	}{
+		{"host", `{"destination":"example.com"}`, "example.com", 443, true},
+		{"host case", `{"destination":"Example.com"}`, "example.COM", 443, true},
+		{"other host", `{"destination":"example.com"}`, "example.org", 443, false},
+		{"wildcard", `{"destination":"*.example.com"}`, "api.example.com", 443, true},
+		{"wildcard parent", `{"destination":"*.example.com"}`, "example.com", 443, false},
+		{"wildcard suffix", `{"destination":"*.example.com"}`, "badexample.com", 443, false},
+		{"port", `{"destination_ports":"443"}`, "example.com", 443, true},
+		{"other port", `{"destination_ports":"443"}`, "example.com", 80, false},
+		{"address", `{"destination":"10.0.0.1","destination_ports":"80-90"}`, "10.0.0.1", 85, true},
+		{"not forwarded", `{"destination_ports":"1-65535"}`, "", 0, false},
+		{"no criteria", `{}`, "", 0, true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			match := decodeMatch(t, tc.match)
+			if matched := match.MatchesDestination(tc.host, tc.port); matched != tc.matched {
+				t.Fatalf("Expected destination %s:%d to match %v, got %v",
+					tc.host, tc.port, tc.matched, matched)
+			}
+		})
+	}
+}
+
+func TestInvalidMatch(t *testing.T) {
+	for _, data := range []string{
+		`{"destination_ports":"https"}`,
+		`{"client":"10.0.0.0/33"}`,
+		`{"client":"localhost"}`,
+		`{"ports":"6000-4000"}`,
+		`{"ports":"http"}`,
+		`{"ports":"70000"}`,
+		`{"regex":"("}`,
+		`{"bytes":-1}`,
+	} {
+		var match toxics.Match
+		if err := json.Unmarshal([]byte(data), &match); err == nil {
+			t.Errorf("Expected match %s to be rejected", data)
+		}
+	}
+}
+
+// runMatchedStub runs the toxic on a new stub of the connection, after the
+// client sent its data, and returns the output for the chunks.
+func runMatchedStub(
+	toxic *toxics.ToxicWrapper,
+	connection *toxics.Connection,
+	sent string,
+	chunks []string,
+) []string {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+	stub.Filter(toxic.Match, connection)
+
+	if sent != "" {
+		connection.Read(stream.Upstream, []byte(sent))
+	}
+	return runStub(stub, toxic, input, output, chunks)
+}
+
+func TestToxicMatchData(t *testing.T) {
+	testCases := []struct {
+		name     string
+		match    string
+		sent     string
+		expected []string
+	}{
+		{"prefix", `{"prefix":"GET "}`, "GET / HTTP/1.1", []string{"!a", "!b"}},
+		{"other prefix", `{"prefix":"GET "}`, "POST / HTTP/1.1", []string{"a", "b"}},
+		{"regex", `{"regex":"Host: example"}`, "GET /\r\nHost: example\r\n", []string{"!a", "!b"}},
+		{"regex after bytes", `{"regex":"Host","bytes":4}`, "GET /\r\nHost\r\n", []string{"a", "b"}},
+		{"undecided", `{"prefix":"GET /index"}`, "GET", []string{"a", "b"}},
+		{"client and prefix", `{"client":"10.0.0.0/8","prefix":"GET"}`, "GET /", []string{"a", "b"}},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			toxic := &toxics.ToxicWrapper{
+				Toxic:    new(markToxic),
+				Toxicity: 1,
+				Match:    decodeMatch(t, tc.match),
+			}
+			connection := toxics.NewConnection()
+			connection.Client = net.ParseIP("127.0.0.1")
+
+			received := runMatchedStub(toxic, connection, tc.sent, []string{"a", "b"})
+			if strings.Join(received, ",") != strings.Join(tc.expected, ",") {
+				t.Fatalf("Expected %v, got %v", tc.expected, received)
+			}
+		})
+	}
+}
+
+func TestToxicMatchDecidedByChunk(t *testing.T) {
+	toxic := &toxics.ToxicWrapper{
+		Toxic:    new(markToxic),
+		Toxicity: 1,
+		Match:    decodeMatch(t, `{"prefix":"GET /"}`),
+	}
+	connection := toxics.NewConnection()
+	connection.Client = net.ParseIP("127.0.0.1")
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+	stub.Filter(toxic.Match, connection)
+	go stub.Run(toxic)
+
+	var received []string
+	for _, chunk := range []string{"GE", "T /", "x"} {
+		// The link passes the data to the connection before the chain.
+		connection.Read(stream.Upstream, []byte(chunk))
+		input <- &stream.StreamChunk{Data: []byte(chunk)}
+		received = append(received, string((<-output).Data))
+	}
+	stub.InterruptToxic()
+
+	// The chunk completing the prefix is the first to go through the toxic.
+	if strings.Join(received, ",") != "GE,!T /,!x" {
+		t.Fatalf("Expected chunks to go through the toxic once matched, got %v", received)
+	}
+}
+
+// countToxic prefixes the data of every chunk with the number of chunks it saw
+// since it started, it does not support the chunk toxicity mode.
+type countToxic struct{}
+
+func (t *countToxic) Pipe(stub *toxics.ToxicStub) {
+	count := 0
+	for {
+		select {
+		case <-stub.Interrupt:
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				stub.Close()
+				return
+			}
+			count++
+			stub.Output <- &stream.StreamChunk{Data: []byte(fmt.Sprintf("%d%s", count, c.Data))}
+		}
+	}
+}
+
+func TestToxicMatchDecidedByChunkKeepsToxicState(t *testing.T) {
+	toxic := &toxics.ToxicWrapper{
+		Toxic:    new(countToxic),
+		Toxicity: 1,
+		Match:    decodeMatch(t, `{"prefix":"GET /"}`),
+	}
+	connection := toxics.NewConnection()
+	connection.Client = net.ParseIP("127.0.0.1")
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+	stub.Filter(toxic.Match, connection)
+	go stub.Run(toxic)
+
+	var received []string
+	for _, chunk := range []string{"GE", "T /", "x", "y"} {
+		// Let the stub wait for the chunk, so it reads the chunk deciding the match.
+		time.Sleep(10 * time.Millisecond)
+		connection.Read(stream.Upstream, []byte(chunk))
+		input <- &stream.StreamChunk{Data: []byte(chunk)}
+		received = append(received, string((<-output).Data))
+	}
+	stub.InterruptToxic()
+
+	// The chunk completing the prefix is the first chunk of a single run of the toxic.
+	if strings.Join(received, ",") != "GE,1T /,2x,3y" {
+		t.Fatalf("Expected chunks to go through one run of the toxic, got %v", received)
+	}
+}
+
+func TestToxicMatchMissedData(t *testing.T) {
+	toxic := &toxics.ToxicWrapper{
+		Toxic:    new(markToxic),
+		Toxicity: 1,
+		Match:    decodeMatch(t, `{"prefix":"GET"}`),
+	}
+	connection := toxics.NewConnection()
+	connection.Client = net.ParseIP("127.0.0.1")
+	connection.Read(stream.Upstream, []byte("GET"))
+
+	// Toxics added to a connection after its first byte never match its data.
+	received := runMatchedStub(toxic, connection, "", []string{"a"})
+	if received[0] != "a" {
+		t.Fatalf("Expected the toxic to not apply, got %s", received[0])
+	}
+}
+
+func TestToxicMatchThroughProxy(t *testing.T) {
+	for _, tc := range []struct {
+		message string
+		delayed bool
+	}{
+		{"fast request\n", false},
+		{"slow request\n", true},
+	} {
+		WithEchoServer(t, func(upstream string, response chan []byte) {
+			proxy := NewTestProxy("test", upstream)
+			proxy.Start()
+			defer proxy.Stop()
+
+			_, err := proxy.Toxics().AddToxicJson(strings.NewReader(`{
+				"type": "latency",
+				"stream": "downstream",
+				"attributes": {"latency": 100},
+				"match": {"client": "127.0.0.0/8", "prefix": "slow"}
+			}`))
+			if err != nil {
+				t.Fatal("Failed to add toxic", err)
+			}
+
+			conn, err := net.Dial("tcp", proxy.Listen())
+			if err != nil {
+				t.Fatal("Unable to dial proxy", err)
+			}
+			defer conn.Close()
+
+			start := time.Now()
+			conn.Write([]byte(tc.message))
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil || line != tc.message {
+				t.Fatalf("Expected %q to be echoed, got %q: %v", tc.message, line, err)
+			}
+			<-response
+
+			if tc.delayed {
+				AssertDeltaTime(t, "Matched", time.Since(start), 100*time.Millisecond, 150*time.Millisecond)
+			} else {
+				AssertDeltaTime(t, "Not matched", time.Since(start), 0, 50*time.Millisecond)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package toxics
+
+import "context"
+
+// The MaxConnectionsToxic turns new clients away while the proxy already has
+// the maximum number of connections open.
+type MaxConnectionsToxic struct {
+	// Maximum number of concurrent connections, 0 for no limit
+	Limit int `json:"limit"`
+	// Close clients with a TCP RST instead of a FIN
+	Reset bool `json:"reset"`
+}
+
+func (t *MaxConnectionsToxic) Pipe(stub *ToxicStub) {
+	new(NoopToxic).Pipe(stub)
+}
+
+func (t *MaxConnectionsToxic) Accept(ctx context.Context, active int) ConnectAction {
+	if t.Limit > 0 && active >= t.Limit {
+		return turnAway(t.Reset)
+	}
+	return ConnectAllow
+}
+
+func init() {
+	Register("max_connections", new(MaxConnectionsToxic))
+}
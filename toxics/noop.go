@@ -18,6 +18,14 @@ func (t *NoopToxic) Pipe(stub *ToxicStub) {
 	}
 }
 
+func (t *NoopToxic) PreservesDatagrams() bool {
+	return true
+}
+
+func (t *NoopToxic) PipesChunks() bool {
+	return true
+}
+
 func init() {
 	Register("noop", new(NoopToxic))
 }
@@ -0,0 +1,101 @@
+package toxics
+
+import (
+	"math/rand"
+)
+
+// The PacketLossToxic drops whole chunks (datagrams on UDP proxies) with a given
+// probability. When good_to_bad is set, the Gilbert-Elliott model is used: the
+// link switches between a good and a bad state, and each state has its own loss
+// probability, so losses come in bursts like on a real lossy link.
+type PacketLossToxic struct {
+	// Probability of dropping a chunk, or in the good state of the burst model
+	Probability float64 `json:"probability"`
+	// Probabilities of switching between the good and bad states on each chunk
+	GoodToBad float64 `json:"good_to_bad"`
+	BadToGood float64 `json:"bad_to_good"`
+	// Probability of dropping a chunk in the bad state
+	BadProbability float64 `json:"bad_probability"`
+}
+
+type PacketLossToxicState struct {
+	bad bool
+}
+
+func (t *PacketLossToxic) Validate() error {
+	for _, probability := range []struct {
+		name  string
+		value float64
+	}{
+		{"probability", t.Probability},
+		{"good_to_bad", t.GoodToBad},
+		{"bad_to_good", t.BadToGood},
+		{"bad_probability", t.BadProbability},
+	} {
+		if err := validateProbability(probability.name, probability.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drop decides whether the next chunk is lost, and moves the burst model to its
+// next state.
+func (t *PacketLossToxic) drop(state *PacketLossToxicState) bool {
+	loss := t.Probability
+	if state.bad {
+		loss = t.BadProbability
+	}
+	//#nosec
+	dropped := rand.Float64() < loss
+
+	if t.GoodToBad > 0 {
+		//#nosec
+		transition := rand.Float64()
+		if state.bad && transition < t.BadToGood {
+			state.bad = false
+		} else if !state.bad && transition < t.GoodToBad {
+			state.bad = true
+		}
+	} else {
+		state.bad = false
+	}
+
+	return dropped
+}
+
+func (t *PacketLossToxic) Pipe(stub *ToxicStub) {
+	state := stub.State.(*PacketLossToxicState)
+
+	for {
+		select {
+		case <-stub.Interrupt:
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				stub.Close()
+				return
+			}
+			if t.drop(state) {
+				continue
+			}
+			stub.Output <- c
+		}
+	}
+}
+
+func (t *PacketLossToxic) NewState() interface{} {
+	return new(PacketLossToxicState)
+}
+
+func (t *PacketLossToxic) PreservesDatagrams() bool {
+	return true
+}
+
+func (t *PacketLossToxic) PipesChunks() bool {
+	return true
+}
+
+func init() {
+	Register("packet_loss", new(PacketLossToxic))
+}
@@ -0,0 +1,110 @@
+package toxics_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+func countPassedChunks(toxic *toxics.PacketLossToxic, chunks int) int {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, chunks)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = toxic.NewState()
+
+	done := make(chan struct{})
+	go func() {
+		toxic.Pipe(stub)
+		close(done)
+	}()
+
+	for i := 0; i < chunks; i++ {
+		input <- &stream.StreamChunk{Data: []byte{byte(i)}}
+	}
+	close(input)
+	<-done
+
+	passed := 0
+	for range output {
+		passed++
+	}
+	return passed
+}
+
+func TestPacketLossToxicNoLoss(t *testing.T) {
+	toxic := &toxics.PacketLossToxic{Probability: 0}
+
+	passed := countPassedChunks(toxic, 1000)
+	if passed != 1000 {
+		t.Fatalf("Expected all 1000 chunks to pass, got %d", passed)
+	}
+}
+
+func TestPacketLossToxicFullLoss(t *testing.T) {
+	toxic := &toxics.PacketLossToxic{Probability: 1}
+
+	passed := countPassedChunks(toxic, 1000)
+	if passed != 0 {
+		t.Fatalf("Expected all chunks to be dropped, got %d", passed)
+	}
+}
+
+func TestPacketLossToxicProbability(t *testing.T) {
+	toxic := &toxics.PacketLossToxic{Probability: 0.5}
+
+	passed := countPassedChunks(toxic, 10000)
+	if passed < 4000 || passed > 6000 {
+		t.Fatalf("Expected about half of 10000 chunks to pass, got %d", passed)
+	}
+}
+
+func TestPacketLossToxicBurstModel(t *testing.T) {
+	// The link starts in the good state without loss and never leaves the bad state.
+	toxic := &toxics.PacketLossToxic{
+		Probability:    0,
+		GoodToBad:      1,
+		BadToGood:      0,
+		BadProbability: 1,
+	}
+
+	passed := countPassedChunks(toxic, 1000)
+	if passed != 1 {
+		t.Fatalf("Expected only the first chunk to pass, got %d", passed)
+	}
+}
+
+func TestPacketLossToxicBurstModelRecovers(t *testing.T) {
+	// Every chunk switches state, so every other chunk is dropped.
+	toxic := &toxics.PacketLossToxic{
+		Probability:    0,
+		GoodToBad:      1,
+		BadToGood:      1,
+		BadProbability: 1,
+	}
+
+	passed := countPassedChunks(toxic, 1000)
+	if passed != 500 {
+		t.Fatalf("Expected every other chunk to pass, got %d", passed)
+	}
+}
+
+func TestPacketLossToxicRejectsInvalidProbabilities(t *testing.T) {
+	proxy := NewTestProxy("test", "localhost:20001")
+
+	for _, attributes := range []string{
+		`{"probability": -0.1}`,
+		`{"probability": 1.5}`,
+		`{"good_to_bad": 2}`,
+		`{"bad_to_good": -1}`,
+		`{"bad_probability": 1.01}`,
+	} {
+		_, err := proxy.Toxics().AddToxicJson(strings.NewReader(
+			`{"type": "packet_loss", "attributes": ` + attributes + `}`,
+		))
+		if err == nil {
+			t.Errorf("Expected %s to be rejected", attributes)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package toxics
+
+import "context"
+
+// The RefuseToxic turns new clients away before the upstream is dialed, as if
+// the connection was refused. With a toxicity below 1, only a fraction of the
+// clients is refused.
+type RefuseToxic struct {
+	// Close clients with a TCP RST instead of a FIN
+	Reset bool `json:"reset"`
+}
+
+func (t *RefuseToxic) Pipe(stub *ToxicStub) {
+	new(NoopToxic).Pipe(stub)
+}
+
+func (t *RefuseToxic) Accept(ctx context.Context, active int) ConnectAction {
+	return turnAway(t.Reset)
+}
+
+func init() {
+	Register("refuse", new(RefuseToxic))
+}
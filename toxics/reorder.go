@@ -0,0 +1,158 @@
+package toxics
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+)
+
+// The ReorderToxic holds chunks back with a given probability, and releases them
+// once a number of following chunks have arrived, or after a delay, whichever
+// comes first. Held chunks arrive out of order.
+//
+// If neither gap nor delay is set, a held chunk is released after the next one.
+type ReorderToxic struct {
+	// Probability of holding a chunk back, between 0 and 1
+	Probability float64 `json:"probability"`
+	// Number of following chunks to arrive before releasing a held chunk
+	Gap int `json:"gap"`
+	// Time in milliseconds after which a held chunk is released
+	Delay int64 `json:"delay"`
+}
+
+type ReorderToxicState struct {
+	// Chunks held back, in the order they were received
+	held []*heldChunk
+	// Fires when the earliest held chunk with a delay is due
+	timer *time.Timer
+}
+
+type heldChunk struct {
+	chunk     *stream.StreamChunk
+	remaining int
+	due       time.Time
+}
+
+func (t *ReorderToxic) Validate() error {
+	err := validateProbability("probability", t.Probability)
+	if err != nil {
+		return err
+	}
+	err = validateNonNegative("gap", int64(t.Gap))
+	if err != nil {
+		return err
+	}
+	return validateNonNegative("delay", t.Delay)
+}
+
+func (t *ReorderToxic) gap() int {
+	if t.Gap <= 0 && t.Delay <= 0 {
+		return 1
+	}
+	return t.Gap
+}
+
+// next returns a channel firing when the earliest held chunk with a delay is
+// due, or nil if there is none.
+func (s *ReorderToxicState) next() <-chan time.Time {
+	var earliest time.Time
+	for _, held := range s.held {
+		if !held.due.IsZero() && (earliest.IsZero() || held.due.Before(earliest)) {
+			earliest = held.due
+		}
+	}
+	if earliest.IsZero() {
+		return nil
+	}
+	s.timer = resetTimer(s.timer, time.Until(earliest))
+	return s.timer.C
+}
+
+// release writes all the held chunks which are due by time or by gap.
+func (s *ReorderToxicState) release(stub *ToxicStub, now time.Time) {
+	remaining := s.held[:0]
+	for _, held := range s.held {
+		byGap := held.remaining == 0
+		byTime := !held.due.IsZero() && !held.due.After(now)
+		if byGap || byTime {
+			stub.Output <- held.chunk
+		} else {
+			remaining = append(remaining, held)
+		}
+	}
+	s.held = remaining
+}
+
+// arrived counts a new chunk towards the gap of held chunks, whether the new chunk
+// is held too or not, so chunks are released even when they are all held.
+func (s *ReorderToxicState) arrived() {
+	for _, held := range s.held {
+		if held.remaining > 0 {
+			held.remaining--
+		}
+	}
+}
+
+// flush writes all the held chunks, so no data is lost when the toxic is
+// removed or the link is closed.
+func (s *ReorderToxicState) flush(stub *ToxicStub) {
+	for _, held := range s.held {
+		err := stub.WriteOutput(held.chunk, 5*time.Second)
+		if err != nil {
+			break
+		}
+	}
+	s.held = nil
+}
+
+func (t *ReorderToxic) Pipe(stub *ToxicStub) {
+	state := stub.State.(*ReorderToxicState)
+
+	for {
+		select {
+		case <-stub.Interrupt:
+			return
+		case now := <-state.next():
+			state.release(stub, now)
+		case c := <-stub.Input:
+			if c == nil {
+				state.flush(stub)
+				stub.Close()
+				return
+			}
+
+			state.arrived()
+			//#nosec
+			if rand.Float64() < t.Probability {
+				held := &heldChunk{chunk: c, remaining: -1}
+				if gap := t.gap(); gap > 0 {
+					held.remaining = gap
+				}
+				if t.Delay > 0 {
+					held.due = time.Now().Add(time.Duration(t.Delay) * time.Millisecond)
+				}
+				state.held = append(state.held, held)
+			} else {
+				stub.Output <- c
+			}
+			state.release(stub, time.Now())
+		}
+	}
+}
+
+func (t *ReorderToxic) Cleanup(stub *ToxicStub) {
+	stub.State.(*ReorderToxicState).flush(stub)
+}
+
+func (t *ReorderToxic) NewState() interface{} {
+	return new(ReorderToxicState)
+}
+
+func (t *ReorderToxic) PreservesDatagrams() bool {
+	return true
+}
+
+func init() {
+	Register("reorder", new(ReorderToxic))
+}
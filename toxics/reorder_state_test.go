@@ -0,0 +1,27 @@
+package toxics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReorderStateReusesTimer(t *testing.T) {
+	state := &ReorderToxicState{
+		held: []*heldChunk{{due: time.Now().Add(time.Millisecond)}},
+	}
+	state.next()
+	timer := state.timer
+	// The timer fires without its value being received.
+	time.Sleep(10 * time.Millisecond)
+
+	state.held[0].due = time.Now().Add(time.Hour)
+	next := state.next()
+	if state.timer != timer {
+		t.Fatal("Expected the timer to be reused")
+	}
+	select {
+	case <-next:
+		t.Fatal("Expected the value fired before the reset to be dropped")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
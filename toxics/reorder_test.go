@@ -0,0 +1,162 @@
+package toxics_test
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+func TestReorderToxicNoReordering(t *testing.T) {
+	toxic := &toxics.ReorderToxic{Probability: 0, Gap: 1}
+
+	received := pipeChunks(toxic, []byte{1, 2, 3})
+	if !bytes.Equal(received, []byte{1, 2, 3}) {
+		t.Fatalf("Expected chunks to pass in order, got %v", received)
+	}
+}
+
+func TestReorderToxicFlushesHeldChunksOnClose(t *testing.T) {
+	toxic := &toxics.ReorderToxic{Probability: 1}
+
+	received := pipeChunks(toxic, []byte{1, 2, 3})
+	if !bytes.Equal(received, []byte{1, 2, 3}) {
+		t.Fatalf("Expected held chunks to be flushed in order, got %v", received)
+	}
+}
+
+func TestReorderToxicReordersChunks(t *testing.T) {
+	toxic := &toxics.ReorderToxic{Probability: 0.5, Gap: 2}
+
+	values := make([]byte, 200)
+	for i := range values {
+		values[i] = byte(i)
+	}
+
+	received := pipeChunks(toxic, values)
+	if len(received) != len(values) {
+		t.Fatalf("Expected %d chunks, got %d", len(values), len(received))
+	}
+	if sort.SliceIsSorted(received, func(i, j int) bool { return received[i] < received[j] }) {
+		t.Fatal("Expected chunks to be reordered")
+	}
+
+	sorted := append([]byte{}, received...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	if !bytes.Equal(sorted, values) {
+		t.Fatalf("Expected every chunk exactly once, got %v", received)
+	}
+}
+
+func TestReorderToxicReleasesAfterDelay(t *testing.T) {
+	toxic := &toxics.ReorderToxic{Probability: 1, Delay: 50}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 1)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = toxic.NewState()
+	go toxic.Pipe(stub)
+	defer close(input)
+
+	start := time.Now()
+	input <- &stream.StreamChunk{Data: []byte("hello")}
+
+	select {
+	case c := <-output:
+		elapsed := time.Since(start)
+		if elapsed < 50*time.Millisecond {
+			t.Fatalf("Expected chunk to be held for 50ms, got %s", elapsed)
+		}
+		if string(c.Data) != "hello" {
+			t.Fatalf("Expected hello, got %q", c.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Held chunk was never released")
+	}
+}
+
+func TestReorderToxicReleasesByGapWhenHoldingAll(t *testing.T) {
+	toxic := &toxics.ReorderToxic{Probability: 1, Gap: 3}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 10)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = toxic.NewState()
+	go toxic.Pipe(stub)
+	defer close(input)
+
+	for i := byte(1); i <= 5; i++ {
+		input <- &stream.StreamChunk{Data: []byte{i}}
+	}
+
+	// Chunks 4 and 5 arrived 3 chunks after chunks 1 and 2.
+	for _, expected := range []byte{1, 2} {
+		select {
+		case c := <-output:
+			if c.Data[0] != expected {
+				t.Fatalf("Expected chunk %d to be released, got %d", expected, c.Data[0])
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected chunk %d to be released by the gap", expected)
+		}
+	}
+	select {
+	case c := <-output:
+		t.Fatalf("Expected chunk %d to still be held", c.Data[0])
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestReorderToxicCleanupFlushesHeldChunks(t *testing.T) {
+	toxic := &toxics.ReorderToxic{Probability: 1, Gap: 10}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 3)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = toxic.NewState()
+
+	done := make(chan struct{})
+	go func() {
+		toxic.Pipe(stub)
+		close(done)
+	}()
+	for i := byte(1); i <= 3; i++ {
+		input <- &stream.StreamChunk{Data: []byte{i}}
+	}
+	stub.Interrupt <- struct{}{}
+	<-done
+
+	toxic.Cleanup(stub)
+	close(output)
+
+	var received []byte
+	for c := range output {
+		received = append(received, c.Data...)
+	}
+	if !bytes.Equal(received, []byte{1, 2, 3}) {
+		t.Fatalf("Expected held chunks to be flushed on cleanup, got %v", received)
+	}
+}
+
+func TestReorderToxicRejectsInvalidProbability(t *testing.T) {
+	for _, probability := range []float64{-0.5, 1.5} {
+		toxic := &toxics.ReorderToxic{Probability: probability}
+		if toxic.Validate() == nil {
+			t.Errorf("Expected probability %v to be rejected", probability)
+		}
+	}
+}
+
+func TestReorderToxicRejectsNegativeGapAndDelay(t *testing.T) {
+	for _, toxic := range []*toxics.ReorderToxic{
+		{Probability: 0.5, Gap: -1},
+		{Probability: 0.5, Delay: -10},
+	} {
+		if toxic.Validate() == nil {
+			t.Errorf("Expected %+v to be rejected", toxic)
+		}
+	}
+}
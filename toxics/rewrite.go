@@ -0,0 +1,223 @@
+package toxics
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+)
+
+// The RewriteToxic replaces every occurrence of a literal or a regular expression
+// in the data passing through it. Data which could be the start of a match is
+// held back until the next chunk arrives, so matches spanning chunks are found.
+// The held data is bounded by MaxLookahead bytes and LookaheadTimeout.
+type RewriteToxic struct {
+	// Literal bytes, or regular expression when Regex is set, to search for
+	Search string `json:"search"`
+	// Replacement, with $1 style references to submatches when Regex is set
+	Replace string `json:"replace"`
+	Regex   bool   `json:"regex"`
+	// Number of bytes to hold back waiting for the rest of a match
+	MaxLookahead int `json:"max_lookahead"`
+	// Time in milliseconds to wait for the rest of a match
+	LookaheadTimeout int64 `json:"lookahead_timeout"`
+}
+
+const (
+	defaultRewriteLookahead        = 1024
+	defaultRewriteLookaheadTimeout = 100
+)
+
+type RewriteToxicState struct {
+	// Data held back waiting for the rest of a possible match
+	buffer []byte
+	// Compiled expression for the search, and what it was compiled from
+	pattern *regexp.Regexp
+	search  string
+	regex   bool
+}
+
+func (t *RewriteToxic) Validate() error {
+	if t.Search == "" {
+		return errors.New("rewrite toxic requires a search")
+	}
+	if t.Regex {
+		_, err := regexp.Compile(t.Search)
+		return err
+	}
+	return nil
+}
+
+func (t *RewriteToxic) lookahead() int {
+	if t.MaxLookahead <= 0 {
+		return defaultRewriteLookahead
+	}
+	return t.MaxLookahead
+}
+
+func (t *RewriteToxic) lookaheadTimeout() time.Duration {
+	timeout := t.LookaheadTimeout
+	if timeout <= 0 {
+		timeout = defaultRewriteLookaheadTimeout
+	}
+	return time.Duration(timeout) * time.Millisecond
+}
+
+// compile returns the expression for the search, compiling it again when the
+// toxic was updated. It returns nil when the search is not a valid expression.
+func (t *RewriteToxic) compile(state *RewriteToxicState) *regexp.Regexp {
+	if state.search != t.Search || state.regex != t.Regex || state.pattern == nil {
+		expr := regexp.QuoteMeta(t.Search)
+		if t.Regex {
+			expr = t.Search
+		}
+		// Invalid expressions are rejected when the toxic is added as JSON,
+		// toxics created otherwise pass data through unchanged.
+		state.pattern, _ = regexp.Compile(expr)
+		state.search = t.Search
+		state.regex = t.Regex
+	}
+	return state.pattern
+}
+
+// holdBack returns how many bytes at the end of the data could still be the
+// start of a match, the data following the last match is given.
+func (t *RewriteToxic) holdBack(data []byte) int {
+	if t.Regex {
+		return t.lookahead()
+	}
+
+	// A literal can only continue from a suffix which is a prefix of the search.
+	search := []byte(t.Search)
+	longest := len(search) - 1
+	if longest > t.lookahead() {
+		longest = t.lookahead()
+	}
+	if longest > len(data) {
+		longest = len(data)
+	}
+	for n := longest; n > 0; n-- {
+		if bytes.HasPrefix(search, data[len(data)-n:]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// rewrite applies the replacements to the buffer, and returns the rewritten
+// data which is safe to send. Unless flush is set, the data which could still
+// become part of a match stays in the buffer.
+func (t *RewriteToxic) rewrite(state *RewriteToxicState, flush bool) []byte {
+	data := state.buffer
+	pattern := t.compile(state)
+	if pattern == nil || t.Search == "" {
+		state.buffer = nil
+		return data
+	}
+	matches := pattern.FindAllSubmatchIndex(data, -1)
+
+	cut := len(data)
+	if !flush {
+		end := 0
+		if len(matches) > 0 {
+			end = matches[len(matches)-1][1]
+		}
+		cut -= t.holdBack(data[end:])
+		if cut < 0 {
+			cut = 0
+		}
+	}
+
+	var output []byte
+	last := 0
+	for _, match := range matches {
+		if match[0] == match[1] {
+			// Empty matches would insert the replacement between every chunk.
+			continue
+		}
+		if match[1] > cut {
+			// The match is not final yet, more data may change it.
+			if match[0] < cut {
+				cut = match[0]
+			}
+			break
+		}
+		output = append(output, data[last:match[0]]...)
+		if t.Regex {
+			output = pattern.Expand(output, []byte(t.Replace), data, match)
+		} else {
+			output = append(output, t.Replace...)
+		}
+		last = match[1]
+	}
+	output = append(output, data[last:cut]...)
+
+	state.buffer = append([]byte{}, data[cut:]...)
+	return output
+}
+
+func (t *RewriteToxic) write(stub *ToxicStub, state *RewriteToxicState, flush bool) {
+	data := t.rewrite(state, flush)
+	if len(data) > 0 {
+		stub.Output <- &stream.StreamChunk{
+			Data:      data,
+			Timestamp: time.Now(),
+		}
+	}
+}
+
+func (t *RewriteToxic) Pipe(stub *ToxicStub) {
+	state := stub.State.(*RewriteToxicState)
+
+	for {
+		var timeout <-chan time.Time
+		if len(state.buffer) > 0 {
+			timeout = time.After(t.lookaheadTimeout())
+		}
+
+		select {
+		case <-stub.Interrupt:
+			return
+		case <-timeout:
+			t.write(stub, state, true)
+		case c := <-stub.Input:
+			if c == nil {
+				t.write(stub, state, true)
+				stub.Close()
+				return
+			}
+			state.buffer = append(state.buffer, c.Data...)
+			t.write(stub, state, false)
+		}
+	}
+}
+
+func (t *RewriteToxic) Cleanup(stub *ToxicStub) {
+	state := stub.State.(*RewriteToxicState)
+	data := t.rewrite(state, true)
+	if len(data) == 0 {
+		return
+	}
+
+	chunk := &stream.StreamChunk{Data: data, Timestamp: time.Now()}
+	err := stub.WriteOutput(chunk, 5*time.Second) // Don't drop any data on the floor
+	if err != nil {
+		log.Warn().
+			Str("component", "RewriteToxic").
+			Str("method", "Cleanup").
+			Err(err).
+			Msg("Could not write held data to Output")
+	}
+}
+
+func (t *RewriteToxic) NewState() interface{} {
+	return new(RewriteToxicState)
+}
+
+func init() {
+	Register("rewrite", new(RewriteToxic))
+}
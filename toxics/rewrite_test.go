@@ -0,0 +1,155 @@
+package toxics_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// rewriteChunks sends the chunks through the toxic without closing it, and
+// returns the data written until the output stays idle.
+func rewriteChunks(t *testing.T, toxic *toxics.RewriteToxic, chunks ...string) string {
+	t.Helper()
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, len(chunks)+1)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = toxic.NewState()
+	go toxic.Pipe(stub)
+	t.Cleanup(func() { close(input) })
+
+	for _, chunk := range chunks {
+		input <- &stream.StreamChunk{Data: []byte(chunk)}
+	}
+
+	var received string
+	for {
+		select {
+		case c := <-output:
+			received += string(c.Data)
+		case <-time.After(50 * time.Millisecond):
+			return received
+		}
+	}
+}
+
+func TestRewriteToxicLiteral(t *testing.T) {
+	toxic := &toxics.RewriteToxic{
+		Search:           `"status":"ok"`,
+		Replace:          `"status":"error"`,
+		LookaheadTimeout: 1000,
+	}
+
+	received := rewriteChunks(t, toxic, `{"status":"ok","id":1} {"status":"ok"}`)
+	expected := `{"status":"error","id":1} {"status":"error"}`
+	if received != expected {
+		t.Fatalf("Expected %s, got %s", expected, received)
+	}
+}
+
+func TestRewriteToxicLiteralSpanningChunks(t *testing.T) {
+	toxic := &toxics.RewriteToxic{
+		Search:           `"status":"ok"`,
+		Replace:          `"status":"error"`,
+		LookaheadTimeout: 1000,
+	}
+
+	received := rewriteChunks(t, toxic, `{"id":1,"sta`, `tus":"o`, `k"}`)
+	expected := `{"id":1,"status":"error"}`
+	if received != expected {
+		t.Fatalf("Expected %s, got %s", expected, received)
+	}
+}
+
+func TestRewriteToxicRegex(t *testing.T) {
+	toxic := &toxics.RewriteToxic{
+		Search:           `(?m)^X-Debug: .*\r\n`,
+		Replace:          "",
+		Regex:            true,
+		MaxLookahead:     16,
+		LookaheadTimeout: 10,
+	}
+
+	received := rewriteChunks(t, toxic, "HTTP/1.1 200 OK\r\nX-Deb", "ug: 1\r\nServer: x\r\n\r\n")
+	expected := "HTTP/1.1 200 OK\r\nServer: x\r\n\r\n"
+	if received != expected {
+		t.Fatalf("Expected %q, got %q", expected, received)
+	}
+}
+
+func TestRewriteToxicRegexSubmatches(t *testing.T) {
+	toxic := &toxics.RewriteToxic{
+		Search:           `id=(\d+)`,
+		Replace:          `id=0$1`,
+		Regex:            true,
+		LookaheadTimeout: 10,
+	}
+
+	received := rewriteChunks(t, toxic, "id=12&id=3")
+	if received != "id=012&id=03" {
+		t.Fatalf("Expected id=012&id=03, got %s", received)
+	}
+}
+
+func TestRewriteToxicFlushesAfterLookaheadTimeout(t *testing.T) {
+	toxic := &toxics.RewriteToxic{
+		Search:           "hello",
+		Replace:          "bye",
+		LookaheadTimeout: 10,
+	}
+
+	// The trailing "hel" could be the start of a match, but no more data arrives.
+	received := rewriteChunks(t, toxic, "hello hel")
+	if received != "bye hel" {
+		t.Fatalf("Expected held data to be flushed, got %s", received)
+	}
+}
+
+func TestRewriteToxicCleanupFlushesHeldData(t *testing.T) {
+	toxic := &toxics.RewriteToxic{
+		Search:           "hello",
+		Replace:          "bye",
+		LookaheadTimeout: 1000,
+	}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 2)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = toxic.NewState()
+
+	done := make(chan struct{})
+	go func() {
+		toxic.Pipe(stub)
+		close(done)
+	}()
+	input <- &stream.StreamChunk{Data: []byte("hello hel")}
+	stub.Interrupt <- struct{}{}
+	<-done
+
+	toxic.Cleanup(stub)
+	close(output)
+
+	var received string
+	for c := range output {
+		received += string(c.Data)
+	}
+	if received != "bye hel" {
+		t.Fatalf("Expected held data to be flushed on cleanup, got %s", received)
+	}
+}
+
+func TestRewriteToxicRejectsInvalidAttributes(t *testing.T) {
+	for _, attrs := range []string{
+		`{"search": "", "replace": "x"}`,
+		`{"search": "(", "regex": true}`,
+	} {
+		toxic := &toxics.RewriteToxic{Search: "hello"}
+		err := json.Unmarshal([]byte(attrs), toxic)
+		if err != nil || toxic.Validate() == nil {
+			t.Fatalf("Expected %s to be rejected", attrs)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package toxics
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// A Schedule turns a toxic on and off over time. The toxic is activated after
+// Start, stays active for Duration, and is activated again every Period. While
+// it is not active, the toxic is not part of any link.
+type Schedule struct {
+	// Times in milliseconds
+	Start    int64 `json:"start"`
+	Duration int64 `json:"duration"`
+	Period   int64 `json:"period"`
+	// Random variation of every period, in milliseconds
+	Jitter int64 `json:"jitter"`
+
+	mutex sync.Mutex
+	phase SchedulePhase
+}
+
+// A SchedulePhase is the current state of a scheduled toxic.
+type SchedulePhase string
+
+const (
+	// Waiting for the first activation
+	PhasePending SchedulePhase = "pending"
+	// Part of the links
+	PhaseActive SchedulePhase = "active"
+	// Waiting for the next activation
+	PhaseInactive SchedulePhase = "inactive"
+	// Never activated again
+	PhaseFinished SchedulePhase = "finished"
+)
+
+type scheduleAttributes struct {
+	Start    int64         `json:"start"`
+	Duration int64         `json:"duration"`
+	Period   int64         `json:"period"`
+	Jitter   int64         `json:"jitter"`
+	Phase    SchedulePhase `json:"phase,omitempty"`
+}
+
+func (s *Schedule) UnmarshalJSON(data []byte) error {
+	var decoded scheduleAttributes
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return err
+	}
+
+	if decoded.Start < 0 || decoded.Duration < 0 || decoded.Period < 0 || decoded.Jitter < 0 {
+		return fmt.Errorf("invalid schedule, times must not be negative")
+	}
+	if decoded.Period > 0 && (decoded.Duration == 0 || decoded.Period <= decoded.Duration) {
+		return fmt.Errorf("invalid schedule, period must be longer than a non-zero duration")
+	}
+
+	s.Start = decoded.Start
+	s.Duration = decoded.Duration
+	s.Period = decoded.Period
+	s.Jitter = decoded.Jitter
+	return nil
+}
+
+func (s *Schedule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(scheduleAttributes{
+		Start:    s.Start,
+		Duration: s.Duration,
+		Period:   s.Period,
+		Jitter:   s.Jitter,
+		Phase:    s.Phase(),
+	})
+}
+
+func (s *Schedule) Phase() SchedulePhase {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.phase
+}
+
+func (s *Schedule) SetPhase(phase SchedulePhase) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.phase = phase
+}
+
+// Inactive returns how long the toxic stays inactive before its next activation.
+// The period is measured from the start of an activation to the next.
+func (s *Schedule) Inactive() time.Duration {
+	wait := s.Period - s.Duration
+	if s.Jitter > 0 {
+		//#nosec
+		wait += rand.Int63n(2*s.Jitter+1) - s.Jitter
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return time.Duration(wait) * time.Millisecond
+}
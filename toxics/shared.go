@@ -0,0 +1,41 @@
+package toxics
+
+import "sync"
+
+// Shared holds the state toxics share across the links of a proxy, in both
+// directions, for example a bandwidth limit for the whole proxy. The state of a
+// key is dropped once the last toxic sharing it is removed.
+type Shared struct {
+	sync.Mutex
+
+	values map[string]interface{}
+}
+
+func NewShared() *Shared {
+	return &Shared{values: make(map[string]interface{})}
+}
+
+// Load returns the value stored with the key, it is created on first use.
+func (s *Shared) Load(key string, create func() interface{}) interface{} {
+	s.Lock()
+	defer s.Unlock()
+
+	value, ok := s.values[key]
+	if !ok {
+		value = create()
+		s.values[key] = value
+	}
+	return value
+}
+
+// Retain drops the state of the keys which are not in keys.
+func (s *Shared) Retain(keys map[string]bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	for key := range s.values {
+		if !keys[key] {
+			delete(s.values, key)
+		}
+	}
+}
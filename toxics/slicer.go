@@ -80,6 +80,10 @@ func (t *SlicerToxic) Pipe(stub *ToxicStub) {
 	}
 }
 
+func (t *SlicerToxic) PipesChunks() bool {
+	return true
+}
+
 func init() {
 	Register("slicer", new(SlicerToxic))
 }
@@ -29,6 +29,10 @@ func (t *SlowCloseToxic) Pipe(stub *ToxicStub) {
 	}
 }
 
+func (t *SlowCloseToxic) PreservesDatagrams() bool {
+	return true
+}
+
 func init() {
 	Register("slow_close", new(SlowCloseToxic))
 }
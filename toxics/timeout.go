@@ -48,6 +48,10 @@ func (t *TimeoutToxic) Cleanup(stub *ToxicStub) {
 	stub.Close()
 }
 
+func (t *TimeoutToxic) PreservesDatagrams() bool {
+	return true
+}
+
 func init() {
 	Register("timeout", new(TimeoutToxic))
 }
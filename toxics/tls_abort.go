@@ -0,0 +1,35 @@
+package toxics
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+)
+
+var ErrHandshakeAborted = errors.New("TLS handshake aborted by toxic")
+
+// The TLSAbortToxic aborts the TLS handshake once the client sent its ClientHello,
+// with an alert or by resetting the connection.
+type TLSAbortToxic struct {
+	// Reset the connection instead of sending an alert
+	Reset bool `json:"reset"`
+}
+
+func (t *TLSAbortToxic) Pipe(stub *ToxicStub) {
+	new(NoopToxic).Pipe(stub)
+}
+
+func (t *TLSAbortToxic) Handshake(ctx context.Context, handshake *Handshake) {
+	reset := t.Reset
+	handshake.OnClientHello(func(hello *tls.ClientHelloInfo) error {
+		if reset {
+			_ = handshake.Reset(hello.Conn)
+			hello.Conn.Close()
+		}
+		return ErrHandshakeAborted
+	})
+}
+
+func init() {
+	Register("tls_abort", new(TLSAbortToxic))
+}
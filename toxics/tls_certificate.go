@@ -0,0 +1,45 @@
+package toxics
+
+import (
+	"context"
+	"crypto/tls"
+)
+
+// The TLSCertificateToxic replaces the certificate presented to clients with one
+// signed by the internal CA, which can be expired, self-signed or valid for
+// another host. Clients pinning the certificate of the proxy reject all of them.
+type TLSCertificateToxic struct {
+	// Host the certificate is valid for, the server name sent by the client when
+	// empty
+	Host       string `json:"host"`
+	Expired    bool   `json:"expired"`
+	SelfSigned bool   `json:"self_signed"`
+}
+
+func (t *TLSCertificateToxic) Pipe(stub *ToxicStub) {
+	new(NoopToxic).Pipe(stub)
+}
+
+func (t *TLSCertificateToxic) Handshake(ctx context.Context, handshake *Handshake) {
+	options := CertificateOptions{
+		ServerName: t.Host,
+		Expired:    t.Expired,
+		SelfSigned: t.SelfSigned,
+	}
+	sign := handshake.Sign
+
+	// The certificates of the proxy would be presented to clients sending no
+	// server name.
+	handshake.Config.Certificates = nil
+	handshake.Config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		certificate := options
+		if certificate.ServerName == "" {
+			certificate.ServerName = hello.ServerName
+		}
+		return sign(certificate)
+	}
+}
+
+func init() {
+	Register("tls_certificate", new(TLSCertificateToxic))
+}
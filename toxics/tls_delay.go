@@ -0,0 +1,32 @@
+package toxics
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+)
+
+// The TLSDelayToxic delays the answer of the proxy to the ClientHello, making the
+// TLS handshake slow.
+type TLSDelayToxic struct {
+	// Time in milliseconds
+	Delay int64 `json:"delay"`
+}
+
+func (t *TLSDelayToxic) Pipe(stub *ToxicStub) {
+	new(NoopToxic).Pipe(stub)
+}
+
+func (t *TLSDelayToxic) Handshake(ctx context.Context, handshake *Handshake) {
+	delay := time.Duration(t.Delay) * time.Millisecond
+	handshake.OnClientHello(func(hello *tls.ClientHelloInfo) error {
+		if !sleep(ctx, delay) {
+			return ctx.Err()
+		}
+		return nil
+	})
+}
+
+func init() {
+	Register("tls_delay", new(TLSDelayToxic))
+}
@@ -0,0 +1,60 @@
+package toxics_test
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"testing"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+func TestTLSVersionToxic(t *testing.T) {
+	for _, invalid := range []string{
+		`{"min_version": "1.4"}`,
+		`{"max_version": "TLS12"}`,
+		`{"min_version": "1.3", "max_version": "1.2"}`,
+		`{"min_version": 1.25}`,
+		`{"max_version": true}`,
+	} {
+		toxic := toxics.TLSVersionToxic{MaxVersion: "1.2"}
+		if err := json.Unmarshal([]byte(invalid), &toxic); err == nil && toxic.Validate() == nil {
+			t.Errorf("Expected %s to be rejected", invalid)
+		}
+	}
+
+	var toxic toxics.TLSVersionToxic
+	err := json.Unmarshal([]byte(`{"min_version": "1.0", "max_version": "1.1"}`), &toxic)
+	if err != nil {
+		t.Fatal("Failed to decode toxic", err)
+	}
+	handshake := &toxics.Handshake{Config: &tls.Config{MinVersion: tls.VersionTLS12}}
+	toxic.Handshake(context.Background(), handshake)
+	config := handshake.Config
+	if config.MinVersion != tls.VersionTLS10 || config.MaxVersion != tls.VersionTLS11 {
+		t.Fatalf("Expected versions to be restricted, got %+v", handshake.Config)
+	}
+
+	// The CLI sends versions as numbers.
+	err = json.Unmarshal([]byte(`{"min_version": 1.2, "max_version": 1}`), &toxic)
+	if err != nil || toxic.Validate() == nil {
+		t.Fatal("Expected min_version above max_version to be rejected")
+	}
+	toxic = toxics.TLSVersionToxic{MinVersion: "1.0"}
+	err = json.Unmarshal([]byte(`{"max_version": 1.2}`), &toxic)
+	if err != nil || toxic.Validate() != nil ||
+		toxic.MinVersion != "1.0" || toxic.MaxVersion != "1.2" {
+		t.Fatalf("Expected numeric versions to be accepted, got %+v: %v", toxic, err)
+	}
+}
+
+func TestTLSHandshakeCallbacksChain(t *testing.T) {
+	handshake := &toxics.Handshake{Config: &tls.Config{MinVersion: tls.VersionTLS12}}
+	(&toxics.TLSDelayToxic{Delay: 0}).Handshake(context.Background(), handshake)
+	(&toxics.TLSAbortToxic{}).Handshake(context.Background(), handshake)
+
+	_, err := handshake.Config.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != toxics.ErrHandshakeAborted {
+		t.Fatal("Expected the chained abort to fail the handshake, got", err)
+	}
+}
@@ -0,0 +1,83 @@
+package toxics
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// The TLSVersionToxic restricts the TLS versions the proxy accepts, so clients
+// which do not support them fail the handshake with a protocol_version alert.
+type TLSVersionToxic struct {
+	// Versions from 1.0 to 1.3, no limit when empty
+	MinVersion TLSVersion `json:"min_version"`
+	MaxVersion TLSVersion `json:"max_version"`
+}
+
+// A TLSVersion is a version like 1.2, it can be decoded from a number as the CLI
+// sends them.
+type TLSVersion string
+
+func (v *TLSVersion) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+
+	switch value := value.(type) {
+	case nil:
+		*v = ""
+	case string:
+		*v = TLSVersion(value)
+	case float64:
+		version := strconv.FormatFloat(value, 'f', -1, 64)
+		if !strings.Contains(version, ".") {
+			version += ".0"
+		}
+		*v = TLSVersion(version)
+	default:
+		return fmt.Errorf("tls_version toxic requires versions from 1.0 to 1.3, got %v", value)
+	}
+	return nil
+}
+
+func (t *TLSVersionToxic) Validate() error {
+	for _, version := range []TLSVersion{t.MinVersion, t.MaxVersion} {
+		if _, ok := tlsVersions[string(version)]; version != "" && !ok {
+			return fmt.Errorf("tls_version toxic requires versions from 1.0 to 1.3, got %q", version)
+		}
+	}
+	if t.MinVersion != "" && t.MaxVersion != "" &&
+		tlsVersions[string(t.MinVersion)] > tlsVersions[string(t.MaxVersion)] {
+		return fmt.Errorf("tls_version toxic requires min_version not above max_version")
+	}
+	return nil
+}
+
+func (t *TLSVersionToxic) Pipe(stub *ToxicStub) {
+	new(NoopToxic).Pipe(stub)
+}
+
+func (t *TLSVersionToxic) Handshake(ctx context.Context, handshake *Handshake) {
+	if version, ok := tlsVersions[string(t.MinVersion)]; ok {
+		handshake.Config.MinVersion = version
+	}
+	if version, ok := tlsVersions[string(t.MaxVersion)]; ok {
+		handshake.Config.MaxVersion = version
+	}
+}
+
+func init() {
+	Register("tls_version", new(TLSVersionToxic))
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"math/rand"
 	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/toxiproxy/v2/stream"
@@ -47,6 +49,44 @@ type StatefulToxic interface {
 	NewState() interface{}
 }
 
+// Datagram toxics never split or merge StreamChunks, they can only delay, drop,
+// duplicate or modify whole chunks. Only datagram toxics can be used on links
+// where every chunk is a single packet, such as the links of UDP proxies.
+type DatagramToxic interface {
+	// Reports whether the toxic preserves chunk boundaries
+	PreservesDatagrams() bool
+}
+
+// Chunk toxics handle every StreamChunk on its own, keeping nothing but their
+// state between chunks. Only chunk toxics can be used with the chunk toxicity
+// mode, where every chunk goes through the toxic alone.
+type ChunkToxic interface {
+	// Reports whether the toxic can run one chunk at a time
+	PipesChunks() bool
+}
+
+// Connection toxics look at both directions of the connection a link belongs
+// to. Attach is called once the state is created, before the toxic runs.
+type ConnectionToxic interface {
+	Attach(stub *ToxicStub, connection *Connection, direction stream.Direction)
+}
+
+// Shared toxics keep state across the links of a proxy, in both directions.
+// SharedKey returns the key of the state shared by the toxic with the given name,
+// or an empty string when it shares none. Share is called with the key once the
+// state is created, before the toxic runs.
+type SharedToxic interface {
+	SharedKey(name string) string
+	Share(stub *ToxicStub, shared *Shared, key string)
+}
+
+// Validated toxics check their attributes once they are decoded. The attributes
+// are decoded into a new toxic, or a copy of the toxic for updates, so an invalid
+// toxic is never added or updated.
+type ValidatedToxic interface {
+	Validate() error
+}
+
 type ToxicWrapper struct {
 	Toxic      `json:"attributes"`
 	Name       string           `json:"name"`
@@ -56,6 +96,72 @@ type ToxicWrapper struct {
 	Direction  stream.Direction `json:"-"`
 	Index      int              `json:"-"`
 	BufferSize int              `json:"-"`
+	// When the toxicity is rolled, every time the toxic starts on a link if empty
+	ToxicityMode ToxicityMode `json:"toxicity_mode,omitempty"`
+	// Turns the toxic on and off over time, it is always active when nil
+	Schedule *Schedule `json:"schedule,omitempty"`
+	// Restricts the toxic to some connections, it applies to all of them when nil
+	Match *Match `json:"match,omitempty"`
+}
+
+// A ToxicityMode tells when to decide whether a toxic applies, with a
+// probability of its toxicity.
+type ToxicityMode string
+
+const (
+	// Once for the lifetime of the connection
+	ToxicityConnection ToxicityMode = "connection"
+	// For every chunk independently
+	ToxicityChunk ToxicityMode = "chunk"
+)
+
+// Validate checks the toxicity mode and the attributes of the toxic.
+func (t *ToxicWrapper) Validate() error {
+	err := validateEnum("toxicity mode", string(t.ToxicityMode),
+		string(ToxicityConnection), string(ToxicityChunk))
+	if err != nil {
+		return err
+	}
+	if t.ToxicityMode == ToxicityChunk && !SupportsChunks(t.Toxic) {
+		return fmt.Errorf("invalid toxicity mode %q, not supported by %s toxics",
+			t.ToxicityMode, t.Type)
+	}
+	if validated, ok := t.Toxic.(ValidatedToxic); ok {
+		return validated.Validate()
+	}
+	return nil
+}
+
+// validateProbability checks that an attribute is a probability between 0 and 1.
+func validateProbability(name string, value float64) error {
+	if value < 0 || value > 1 {
+		return fmt.Errorf("invalid %s %v, must be between 0 and 1", name, value)
+	}
+	return nil
+}
+
+// validateNonNegative checks that an attribute like a count or a delay is not
+// negative.
+func validateNonNegative(name string, value int64) error {
+	if value < 0 {
+		return fmt.Errorf("invalid %s %v, must not be negative", name, value)
+	}
+	return nil
+}
+
+// validateEnum checks that the value of an attribute is empty or one of the values.
+func validateEnum(name, value string, values ...string) error {
+	if value == "" {
+		return nil
+	}
+	for _, valid := range values {
+		if value == valid {
+			return nil
+		}
+	}
+	last := len(values) - 1
+	return fmt.Errorf("invalid %s %q, must be %s or %s",
+		name, value, strings.Join(values[:last], ", "), values[last])
 }
 
 type ToxicStub struct {
@@ -65,6 +171,13 @@ type ToxicStub struct {
 	Interrupt chan struct{}
 	running   chan struct{}
 	closed    chan struct{}
+	// Decision of the connection toxicity mode, once rolled
+	decided bool
+	enabled bool
+	// Whether the connection matches the toxic, nil without a match
+	match *matchState
+	// Set to 1 once the toxicity is rolled and the toxic applies, read with atomic
+	applied int32
 }
 
 func NewToxicStub(input <-chan *stream.StreamChunk, output chan<- *stream.StreamChunk) *ToxicStub {
@@ -76,19 +189,278 @@ func NewToxicStub(input <-chan *stream.StreamChunk, output chan<- *stream.Stream
 	}
 }
 
+// Filter restricts the toxic running on this stub to the connection matching it.
+// The connection may be nil, then only toxics without a match apply.
+func (s *ToxicStub) Filter(match *Match, connection *Connection) {
+	if match == nil {
+		s.match = nil
+		return
+	}
+	s.match = newMatchState(match, connection)
+}
+
+// Matches reports whether the toxic applies to the connection of this stub, which
+// is always the case without a match, and never while it is not decided yet.
+func (s *ToxicStub) Matches() bool {
+	if s.match == nil {
+		return true
+	}
+	matched, decided := s.match.result()
+	return matched && decided
+}
+
+// Applies reports whether the toxic running on this stub affects its connection:
+// the connection matches, and the toxicity rolled enabled the toxic. In the chunk
+// toxicity mode the toxic applies unless its toxicity is 0.
+func (s *ToxicStub) Applies() bool {
+	return s.Matches() && atomic.LoadInt32(&s.applied) == 1
+}
+
+func (s *ToxicStub) setApplied(applied bool) {
+	var value int32
+	if applied {
+		value = 1
+	}
+	atomic.StoreInt32(&s.applied, value)
+}
+
 // Begin running a toxic on this stub, can be interrupted.
 // Runs a noop toxic randomly depending on toxicity.
 func (s *ToxicStub) Run(toxic *ToxicWrapper) {
 	s.running = make(chan struct{})
 	defer close(s.running)
-	//#nosec
-	if rand.Float32() < toxic.Toxicity {
+	s.setApplied(false)
+
+	var pending *stream.StreamChunk
+	if s.match != nil {
+		var matched, stop bool
+		pending, matched, stop = s.awaitMatch()
+		if stop {
+			return
+		}
+		if !matched {
+			if pending != nil {
+				s.Output <- pending
+			}
+			new(NoopToxic).Pipe(s)
+			return
+		}
+	}
+
+	enabled := false
+	switch toxic.ToxicityMode {
+	case ToxicityChunk:
+		s.setApplied(toxic.Toxicity > 0)
+		s.runChunks(toxic, pending)
+		return
+	case ToxicityConnection:
+		if !s.decided {
+			//#nosec
+			s.enabled = rand.Float32() < toxic.Toxicity
+			s.decided = true
+		}
+		enabled = s.enabled
+	default:
+		//#nosec
+		enabled = rand.Float32() < toxic.Toxicity
+	}
+	s.setApplied(enabled)
+
+	if pending != nil {
+		if !enabled {
+			s.Output <- pending
+		} else if !SupportsChunks(toxic.Toxic) {
+			// The toxic keeps state across chunks, the pending chunk is the
+			// first of the chunks it sees.
+			s.pipeFollowing(toxic.Toxic, pending)
+			return
+		} else if s.pipeChunk(toxic.Toxic, pending) {
+			return
+		}
+	}
+	if enabled {
 		toxic.Pipe(s)
 	} else {
 		new(NoopToxic).Pipe(s)
 	}
 }
 
+// awaitMatch passes chunks through unaffected until it is decided whether the
+// connection matches. It returns the chunk read when the decision was made, which
+// is still to be passed on, and whether the toxic should stop running.
+func (s *ToxicStub) awaitMatch() (*stream.StreamChunk, bool, bool) {
+	for {
+		if matched, decided := s.match.result(); decided {
+			return nil, matched, false
+		}
+
+		select {
+		case <-s.Interrupt:
+			return nil, false, true
+		case c := <-s.Input:
+			if c == nil {
+				s.Close()
+				return nil, false, true
+			}
+			if matched, decided := s.match.result(); decided {
+				return c, matched, false
+			}
+			s.Output <- c
+		}
+	}
+}
+
+// runChunks decides for every chunk whether it goes through the toxic. Chunks
+// go through the toxic one at a time, so they stay in order. The pending chunk,
+// if any, goes first.
+func (s *ToxicStub) runChunks(toxic *ToxicWrapper, pending *stream.StreamChunk) {
+	for {
+		c := pending
+		pending = nil
+		if c == nil {
+			select {
+			case <-s.Interrupt:
+				return
+			case c = <-s.Input:
+			}
+			if c == nil {
+				s.Close()
+				return
+			}
+		}
+
+		//#nosec
+		if rand.Float32() >= toxic.Toxicity {
+			s.Output <- c
+		} else if s.pipeChunk(toxic.Toxic, c) {
+			return
+		}
+	}
+}
+
+// pipeChunk passes a single chunk through the toxic, on a stub sharing the state
+// of this one. It returns true if the toxic was interrupted.
+func (s *ToxicStub) pipeChunk(toxic Toxic, c *stream.StreamChunk) bool {
+	input := make(chan *stream.StreamChunk, 1)
+	input <- c
+	close(input)
+	output := make(chan *stream.StreamChunk)
+
+	chunk := NewToxicStub(input, output)
+	chunk.State = s.State
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		toxic.Pipe(chunk)
+	}()
+
+	interrupted := false
+	received := s.Interrupt
+	var interrupt chan struct{}
+	for {
+		select {
+		case p, ok := <-output:
+			if !ok {
+				// The toxic closed its stub, it is done with the chunk.
+				output = nil
+				continue
+			}
+			s.Output <- p
+		case <-received:
+			// Forward the interrupt to the toxic.
+			interrupted = true
+			received = nil
+			interrupt = chunk.Interrupt
+		case interrupt <- struct{}{}:
+			interrupt = nil
+		case <-done:
+			// The toxic may have been interrupted before reading the chunk,
+			// pass it through rather than dropping it.
+			select {
+			case c, ok := <-input:
+				if ok && c != nil {
+					s.Output <- c
+				}
+			default:
+			}
+			return interrupted
+		}
+	}
+}
+
+// pipeFollowing runs the toxic on a stub sharing the state of this one, which
+// reads the pending chunk first and then the input of this stub.
+func (s *ToxicStub) pipeFollowing(toxic Toxic, pending *stream.StreamChunk) {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	follower := NewToxicStub(input, output)
+	follower.State = s.State
+	follower.match = s.match
+	follower.applied = atomic.LoadInt32(&s.applied)
+
+	// The chunk read from the input but not passed on once the toxic is done.
+	stop := make(chan struct{})
+	left := make(chan *stream.StreamChunk, 1)
+	go func() {
+		c := pending
+		for {
+			select {
+			case input <- c:
+			case <-stop:
+				left <- c
+				return
+			}
+			select {
+			case c = <-s.Input:
+				if c == nil {
+					close(input)
+					left <- nil
+					return
+				}
+			case <-stop:
+				left <- nil
+				return
+			}
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		toxic.Pipe(follower)
+	}()
+
+	interrupted := false
+	received := s.Interrupt
+	var interrupt chan struct{}
+	for {
+		select {
+		case p, ok := <-output:
+			if !ok {
+				output = nil
+				continue
+			}
+			s.Output <- p
+		case <-received:
+			interrupted = true
+			received = nil
+			interrupt = follower.Interrupt
+		case interrupt <- struct{}{}:
+			interrupt = nil
+		case <-done:
+			close(stop)
+			c := <-left
+			if !interrupted {
+				// The toxic closed its stub.
+				s.Close()
+			} else if c != nil {
+				s.Output <- c
+			}
+			return
+		}
+	}
+}
+
 // WriteOutput allows to write to Output with timeout to avoid deadlocks.
 // If duration is 0, then wait until other goroutines finish reading from Output.
 func (s *ToxicStub) WriteOutput(p *stream.StreamChunk, d time.Duration) error {
@@ -138,6 +510,22 @@ var (
 	registryMutex sync.RWMutex
 )
 
+// resetTimer sets the timer to fire after d, dropping a value it fired which was
+// not received. The timer is created on first use.
+func resetTimer(timer *time.Timer, d time.Duration) *time.Timer {
+	if timer == nil {
+		return time.NewTimer(d)
+	}
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+	return timer
+}
+
 func Register(typeName string, toxic Toxic) {
 	registryMutex.Lock()
 	defer registryMutex.Unlock()
@@ -165,6 +553,18 @@ func New(wrapper *ToxicWrapper) Toxic {
 	return wrapper.Toxic
 }
 
+// SupportsDatagrams returns true if the toxic can be used on datagram links.
+func SupportsDatagrams(toxic Toxic) bool {
+	datagram, ok := toxic.(DatagramToxic)
+	return ok && datagram.PreservesDatagrams()
+}
+
+// SupportsChunks returns true if the toxic can be used with the chunk toxicity mode.
+func SupportsChunks(toxic Toxic) bool {
+	chunk, ok := toxic.(ChunkToxic)
+	return ok && chunk.PipesChunks()
+}
+
 func Count() int {
 	registryMutex.RLock()
 	defer registryMutex.RUnlock()
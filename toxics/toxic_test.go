@@ -6,8 +6,11 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/json"
+	"flag"
 	"io"
 	"net"
+	"os"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -265,6 +268,59 @@ func TestToxicAddRemove(t *testing.T) {
 	}
 }
 
+func TestScheduledToxic(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal("Failed to create TCP server", err)
+	}
+
+	defer ln.Close()
+
+	proxy := NewTestProxy("test", ln.Addr().String())
+	proxy.Start()
+	defer proxy.Stop()
+
+	serverConnRecv := make(chan net.Conn)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Error("Unable to accept TCP connection", err)
+		}
+		serverConnRecv <- conn
+	}()
+
+	conn, err := net.Dial("tcp", proxy.Listen())
+	if err != nil {
+		t.Error("Unable to dial TCP server", err)
+	}
+	defer conn.Close()
+
+	serverConn := <-serverConnRecv
+
+	_, err = proxy.Toxics().AddToxicJson(strings.NewReader(`{
+		"type": "latency",
+		"stream": "upstream",
+		"attributes": {"latency": 100},
+		"schedule": {"start": 100, "duration": 300}
+	}`))
+	if err != nil {
+		t.Fatal("Failed to add scheduled toxic", err)
+	}
+
+	roundTrip := func() time.Duration {
+		start := time.Now()
+		AssertEchoResponse(t, conn, serverConn)
+		return time.Since(start)
+	}
+
+	AssertDeltaTime(t, "Pending toxic", roundTrip(), 0, 20*time.Millisecond)
+	time.Sleep(150 * time.Millisecond)
+	AssertDeltaTime(t, "Active toxic", roundTrip(), 100*time.Millisecond, 20*time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
+	AssertDeltaTime(t, "Finished toxic", roundTrip(), 0, 20*time.Millisecond)
+}
+
 func TestProxyLatency(t *testing.T) {
 	ln, err := net.Listen("tcp", "localhost:0")
 	if err != nil {
@@ -396,3 +452,203 @@ func TestToxicStub_WriteOutput(t *testing.T) {
 		}
 	})
 }
+
+// markToxic prefixes the data of every chunk with an exclamation mark.
+type markToxic struct{}
+
+func (t *markToxic) Pipe(stub *toxics.ToxicStub) {
+	for {
+		select {
+		case <-stub.Interrupt:
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				stub.Close()
+				return
+			}
+			stub.Output <- &stream.StreamChunk{Data: append([]byte("!"), c.Data...)}
+		}
+	}
+}
+
+func (t *markToxic) PipesChunks() bool {
+	return true
+}
+
+// runStub runs the toxic on the stub until it is interrupted, and returns the
+// output for the chunks.
+func runStub(
+	stub *toxics.ToxicStub,
+	toxic *toxics.ToxicWrapper,
+	input chan<- *stream.StreamChunk,
+	output <-chan *stream.StreamChunk,
+	chunks []string,
+) []string {
+	go stub.Run(toxic)
+
+	var received []string
+	for _, chunk := range chunks {
+		input <- &stream.StreamChunk{Data: []byte(chunk)}
+		received = append(received, string((<-output).Data))
+	}
+	stub.InterruptToxic()
+	return received
+}
+
+func TestToxicityConnectionMode(t *testing.T) {
+	toxic := &toxics.ToxicWrapper{
+		Toxic:        new(markToxic),
+		Toxicity:     0.5,
+		ToxicityMode: toxics.ToxicityConnection,
+	}
+
+	decisions := make(map[bool]int)
+	for i := 0; i < 50; i++ {
+		input := make(chan *stream.StreamChunk)
+		output := make(chan *stream.StreamChunk)
+		stub := toxics.NewToxicStub(input, output)
+
+		first := runStub(stub, toxic, input, output, []string{"a"})[0]
+		// The decision is kept when the toxic restarts, as when it is updated.
+		for j := 0; j < 5; j++ {
+			if received := runStub(stub, toxic, input, output, []string{"a"})[0]; received != first {
+				t.Fatalf("Expected the decision of the connection to be kept, got %s then %s",
+					first, received)
+			}
+		}
+		decisions[first == "!a"]++
+	}
+
+	if decisions[true] == 0 || decisions[false] == 0 {
+		t.Fatalf("Expected connections to be both affected and not, got %v", decisions)
+	}
+}
+
+func TestToxicityChunkMode(t *testing.T) {
+	toxic := &toxics.ToxicWrapper{
+		Toxic:        new(markToxic),
+		Toxicity:     0.5,
+		ToxicityMode: toxics.ToxicityChunk,
+	}
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+
+	chunks := make([]string, 1000)
+	for i := range chunks {
+		chunks[i] = strconv.Itoa(i)
+	}
+
+	marked := 0
+	for i, received := range runStub(stub, toxic, input, output, chunks) {
+		if received == "!"+chunks[i] {
+			marked++
+		} else if received != chunks[i] {
+			t.Fatalf("Expected chunks to stay in order, got %s for chunk %s", received, chunks[i])
+		}
+	}
+	if marked < 400 || marked > 600 {
+		t.Fatalf("Expected about half of the chunks to be affected, got %d", marked)
+	}
+}
+
+func TestToxicityChunkModeInterrupt(t *testing.T) {
+	toxic := &toxics.ToxicWrapper{
+		Toxic:        &toxics.LatencyToxic{Latency: 10000},
+		Toxicity:     1,
+		ToxicityMode: toxics.ToxicityChunk,
+	}
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 1)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = toxic.Toxic.(toxics.StatefulToxic).NewState()
+
+	go stub.Run(toxic)
+	input <- &stream.StreamChunk{Data: []byte("hello"), Timestamp: time.Now()}
+
+	start := time.Now()
+	if !stub.InterruptToxic() {
+		t.Fatal("Expected the toxic to be interrupted")
+	}
+	AssertDeltaTime(t, "Interrupt", time.Since(start), 0, 100*time.Millisecond)
+
+	if chunk := <-output; string(chunk.Data) != "hello" {
+		t.Fatalf("Expected the chunk to be passed on when interrupted, got %s", chunk.Data)
+	}
+}
+
+// idleToxic waits to be interrupted without reading its input.
+type idleToxic struct{}
+
+func (t *idleToxic) Pipe(stub *toxics.ToxicStub) {
+	<-stub.Interrupt
+}
+
+func (t *idleToxic) PipesChunks() bool {
+	return true
+}
+
+func TestToxicityChunkModeInterruptBeforeRead(t *testing.T) {
+	toxic := &toxics.ToxicWrapper{
+		Toxic:        &idleToxic{},
+		Toxicity:     1,
+		ToxicityMode: toxics.ToxicityChunk,
+	}
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 1)
+	stub := toxics.NewToxicStub(input, output)
+
+	go stub.Run(toxic)
+	input <- &stream.StreamChunk{Data: []byte("hello")}
+
+	if !stub.InterruptToxic() {
+		t.Fatal("Expected the toxic to be interrupted")
+	}
+
+	select {
+	case chunk := <-output:
+		if string(chunk.Data) != "hello" {
+			t.Fatalf("Expected the unread chunk to be passed on, got %s", chunk.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the unread chunk to be passed on when interrupted")
+	}
+}
+
+func TestInvalidUpdateLeavesToxicUnchanged(t *testing.T) {
+	proxy := NewTestProxy("test", "localhost:20001")
+
+	_, err := proxy.Toxics().AddToxicJson(strings.NewReader(
+		`{"name": "latency", "type": "latency", "attributes": {"latency": 100}}`,
+	))
+	if err != nil {
+		t.Fatal("Failed to add toxic", err)
+	}
+
+	for _, update := range []string{
+		`{"attributes": {"latency": 200, "correlation": 1.5}}`,
+		`{"attributes": {"latency": 200}, "toxicity_mode": "packet"}`,
+		`{"attributes": {"latency": "slow"}}`,
+	} {
+		_, err = proxy.Toxics().UpdateToxicJson("latency", strings.NewReader(update))
+		if err == nil {
+			t.Errorf("Expected %s to be rejected", update)
+		}
+	}
+
+	toxic := proxy.Toxics().GetToxic("latency")
+	if latency := toxic.Toxic.(*toxics.LatencyToxic); latency.Latency != 100 {
+		t.Fatalf("Expected invalid updates to leave the toxic unchanged, got %+v", latency)
+	}
+}
+
+func TestInvalidToxicityMode(t *testing.T) {
+	proxy := NewTestProxy("test", "localhost:20001")
+
+	_, err := proxy.Toxics().AddToxicJson(strings.NewReader(
+		`{"type": "latency", "toxicity_mode": "packet"}`,
+	))
+	if err == nil {
+		t.Fatal("Expected invalid toxicity mode to be rejected")
+	}
+}
@@ -1,7 +1,9 @@
 package toxiproxy_test
 
 import (
+	"flag"
 	"net"
+	"os"
 	"testing"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -0,0 +1,206 @@
+package toxiproxy
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+)
+
+// Strategies choosing the upstream of each new client among the upstreams of a
+// proxy which are not marked down.
+const (
+	// BalanceRoundRobin takes turns between the upstreams.
+	BalanceRoundRobin = "round_robin"
+	// BalanceRandom picks an upstream at random.
+	BalanceRandom = "random"
+	// BalanceFailover picks the first upstream, the others are backups.
+	BalanceFailover = "failover"
+)
+
+var ErrNoUpstreamAvailable = errors.New("All upstreams are marked down")
+
+func parseBalance(value string) (string, error) {
+	switch strings.ToLower(value) {
+	case "", BalanceRoundRobin:
+		return BalanceRoundRobin, nil
+	case BalanceRandom:
+		return BalanceRandom, nil
+	case BalanceFailover:
+		return BalanceFailover, nil
+	}
+	return "", ErrInvalidBalance
+}
+
+// upstreamAddresses returns the upstreams of the config. When both are set, the
+// upstream must be the first of the upstreams, unless only one of them was changed
+// from the current upstreams of the proxy. Forward proxies have no upstream.
+func (config *ProxyConfig) upstreamAddresses(current []string) ([]string, error) {
+	if config.Mode != "" {
+		if config.Upstream != "" || len(config.Upstreams) > 0 {
+			return nil, ErrForwardUpstream
+		}
+		return []string{}, nil
+	}
+	if len(config.Upstreams) == 0 {
+		if config.Upstream == "" {
+			return nil, joinError(fmt.Errorf("upstream"), ErrMissingField)
+		}
+		return []string{config.Upstream}, nil
+	}
+
+	seen := make(map[string]bool, len(config.Upstreams))
+	for _, address := range config.Upstreams {
+		if address == "" || seen[address] {
+			return nil, ErrInvalidUpstreams
+		}
+		seen[address] = true
+	}
+
+	if config.Upstream == "" || config.Upstream == config.Upstreams[0] {
+		return config.Upstreams, nil
+	}
+	if sameUpstreams(config.Upstreams, current) {
+		return []string{config.Upstream}, nil
+	}
+	if len(current) > 0 && config.Upstream == current[0] {
+		return config.Upstreams, nil
+	}
+	return nil, ErrInvalidUpstreams
+}
+
+// firstUpstream returns the first of the upstreams, which is the upstream of the
+// proxy, or an empty string for forward proxies.
+func firstUpstream(addresses []string) string {
+	if len(addresses) == 0 {
+		return ""
+	}
+	return addresses[0]
+}
+
+func sameUpstreams(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// UpstreamState is an upstream of a proxy, and whether it was marked down.
+type UpstreamState struct {
+	Address string `json:"address"`
+	Down    bool   `json:"down"`
+}
+
+// upstreamList chooses the upstreams new clients connect to. Upstreams marked
+// down are skipped, the connections already open to them are left alone.
+type upstreamList struct {
+	sync.Mutex
+
+	addresses []string
+	down      map[string]bool
+	balance   string
+	next      int
+}
+
+func newUpstreamList(addresses []string, balance string) *upstreamList {
+	return &upstreamList{
+		addresses: addresses,
+		down:      make(map[string]bool),
+		balance:   balance,
+	}
+}
+
+// withAddresses returns a list of the new addresses, which keeps the upstreams
+// still part of it marked down.
+func (u *upstreamList) withAddresses(addresses []string) *upstreamList {
+	u.Lock()
+	defer u.Unlock()
+
+	list := newUpstreamList(addresses, u.balance)
+	for _, address := range addresses {
+		list.down[address] = u.down[address]
+	}
+	return list
+}
+
+func (u *upstreamList) getAddresses() []string {
+	u.Lock()
+	defer u.Unlock()
+
+	return append([]string(nil), u.addresses...)
+}
+
+func (u *upstreamList) getBalance() string {
+	u.Lock()
+	defer u.Unlock()
+
+	return u.balance
+}
+
+func (u *upstreamList) setBalance(balance string) {
+	u.Lock()
+	defer u.Unlock()
+
+	u.balance = balance
+}
+
+// order returns the upstreams a new client tries to connect to, starting with the
+// one chosen by the balancing strategy. The others follow in turn as fallbacks.
+func (u *upstreamList) order() []string {
+	u.Lock()
+	defer u.Unlock()
+
+	available := make([]string, 0, len(u.addresses))
+	for _, address := range u.addresses {
+		if !u.down[address] {
+			available = append(available, address)
+		}
+	}
+	if len(available) < 2 {
+		return available
+	}
+
+	first := 0
+	switch u.balance {
+	case BalanceRoundRobin:
+		first = u.next % len(available)
+		u.next++
+	case BalanceRandom:
+		//#nosec
+		first = rand.Intn(len(available))
+	}
+
+	order := make([]string, 0, len(available))
+	order = append(order, available[first:]...)
+	return append(order, available[:first]...)
+}
+
+func (u *upstreamList) states() []UpstreamState {
+	u.Lock()
+	defer u.Unlock()
+
+	states := make([]UpstreamState, len(u.addresses))
+	for i, address := range u.addresses {
+		states[i] = UpstreamState{Address: address, Down: u.down[address]}
+	}
+	return states
+}
+
+func (u *upstreamList) setDown(address string, down bool) (UpstreamState, error) {
+	u.Lock()
+	defer u.Unlock()
+
+	for _, existing := range u.addresses {
+		if existing == address {
+			u.down[address] = down
+			return UpstreamState{Address: address, Down: down}, nil
+		}
+	}
+	return UpstreamState{}, ErrUpstreamNotFound
+}
@@ -0,0 +1,126 @@
+package toxiproxy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUpstreamOrder(t *testing.T) {
+	testCases := []struct {
+		name     string
+		balance  string
+		down     []string
+		expected []string
+	}{
+		{"round robin", BalanceRoundRobin, nil, []string{"a,b,c", "b,c,a", "c,a,b", "a,b,c"}},
+		{"round robin with down", BalanceRoundRobin, []string{"b"}, []string{"a,c", "c,a", "a,c"}},
+		{"failover", BalanceFailover, nil, []string{"a,b,c", "a,b,c"}},
+		{"failover with down", BalanceFailover, []string{"a"}, []string{"b,c", "b,c"}},
+		{"all down", BalanceFailover, []string{"a", "b", "c"}, []string{"", ""}},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			upstreams := newUpstreamList([]string{"a", "b", "c"}, tc.balance)
+			for _, address := range tc.down {
+				if _, err := upstreams.setDown(address, true); err != nil {
+					t.Fatal("Failed to mark upstream down", err)
+				}
+			}
+
+			for i, expected := range tc.expected {
+				order := strings.Join(upstreams.order(), ",")
+				if order != expected {
+					t.Fatalf("Expected order %d to be %s, got %s", i, expected, order)
+				}
+			}
+		})
+	}
+}
+
+func TestUpstreamRandomOrder(t *testing.T) {
+	upstreams := newUpstreamList([]string{"a", "b", "c"}, BalanceRandom)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		order := upstreams.order()
+		if len(order) != 3 {
+			t.Fatal("Expected all upstreams to be tried, got", order)
+		}
+		seen[order[0]] = true
+	}
+	if len(seen) != 3 {
+		t.Fatal("Expected every upstream to be picked first, got", seen)
+	}
+}
+
+func TestUpstreamDownKeptOnUpdate(t *testing.T) {
+	upstreams := newUpstreamList([]string{"a", "b"}, BalanceFailover)
+	if _, err := upstreams.setDown("a", true); err != nil {
+		t.Fatal("Failed to mark upstream down", err)
+	}
+	if _, err := upstreams.setDown("c", true); err != ErrUpstreamNotFound {
+		t.Fatal("Expected unknown upstream to not be found, got", err)
+	}
+
+	updated := upstreams.withAddresses([]string{"c", "a"})
+	states := updated.states()
+	if states[0].Down || !states[1].Down {
+		t.Fatalf("Expected only upstream a to stay down, got %+v", states)
+	}
+	if updated.getBalance() != BalanceFailover {
+		t.Fatal("Expected balance to be kept, got", updated.getBalance())
+	}
+}
+
+func TestUpstreamAddresses(t *testing.T) {
+	testCases := []struct {
+		name      string
+		config    ProxyConfig
+		current   []string
+		expected  string
+		expectErr bool
+	}{
+		{"upstream", ProxyConfig{Upstream: "a"}, nil, "a", false},
+		{"upstreams", ProxyConfig{Upstreams: []string{"a", "b"}}, nil, "a,b", false},
+		{"both", ProxyConfig{Upstream: "a", Upstreams: []string{"a", "b"}}, nil, "a,b", false},
+		{"conflict", ProxyConfig{Upstream: "c", Upstreams: []string{"a", "b"}}, nil, "", true},
+		{"duplicate", ProxyConfig{Upstreams: []string{"a", "a"}}, nil, "", true},
+		{"empty", ProxyConfig{Upstreams: []string{"a", ""}}, nil, "", true},
+		{"missing", ProxyConfig{}, nil, "", true},
+		{
+			"changed upstream",
+			ProxyConfig{Upstream: "c", Upstreams: []string{"a", "b"}},
+			[]string{"a", "b"},
+			"c",
+			false,
+		},
+		{
+			"changed upstreams",
+			ProxyConfig{Upstream: "a", Upstreams: []string{"b", "c"}},
+			[]string{"a"},
+			"b,c",
+			false,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			addresses, err := tc.config.upstreamAddresses(tc.current)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("Expected an error, got", addresses)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("Unexpected error", err)
+			}
+			if strings.Join(addresses, ",") != tc.expected {
+				t.Fatalf("Expected upstreams %s, got %v", tc.expected, addresses)
+			}
+		})
+	}
+}